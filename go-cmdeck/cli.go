@@ -1,195 +1,1832 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
 	"text/tabwriter"
 	"time"
 )
 
+// Exit statuses of run/execute besides the job's own exit code.
+const (
+	exitJobsFailed = 1   // run-all/--label/--tag: at least one job did not succeed
+	exitTimeout    = 124 // the job timed out
+	exitInternal   = 125 // go-cmdeck itself failed (unknown job, bad config, ...)
+	exitCancelled  = 130 // the job was cancelled (Ctrl+C)
+)
+
+// exitError makes go-cmdeck exit with code. err is printed if non-nil.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	if e.err != nil {
+		return e.err.Error()
+	}
+	return fmt.Sprintf("exit status %d", e.code)
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+func internalError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitError{code: exitInternal, err: err}
+}
+
+// exitStatus maps a run result to the exit status of go-cmdeck.
+func exitStatus(result *ExecutionResult) int {
+	switch result.status() {
+	case StatusSuccess:
+		return 0
+	case StatusTimeout:
+		return exitTimeout
+	case StatusCancelled:
+		return exitCancelled
+	}
+	if result.ExitCode > 0 {
+		return result.ExitCode
+	}
+	return exitInternal
+}
+
 type CLI struct {
-	executor *Executor
+	executor   *Executor
+	output     string
+	configPath string
+}
+
+// NewCLI returns the command line interface. configPath is the --config
+// file, if any.
+func NewCLI(executor *Executor, configPath string) *CLI {
+	return &CLI{executor: executor, configPath: configPath}
+}
+
+func (c *CLI) Run(args []string) error {
+	output, args, err := extractOutputFlag(args)
+	if err != nil {
+		return err
+	}
+	c.output = output
+
+	if len(args) < 2 {
+		c.showUsage()
+		return nil
+	}
+
+	switch args[1] {
+	case "init":
+		return c.initConfig()
+	case "list", "ls":
+		return c.listContexts(args[2:])
+	case "execute", "exec", "run":
+		return c.runCommand(args[1], args[2:])
+	case "run-all":
+		return c.runCommand(args[1], args[2:])
+	case "history":
+		if len(args) < 3 {
+			fmt.Fprintf(os.Stderr, "Usage: %s history <job-name> [-n <count>]\n", args[0])
+			return fmt.Errorf("job name required")
+		}
+		return c.showHistory(args[2], args[3:])
+	case "show":
+		if len(args) < 3 {
+			fmt.Fprintf(os.Stderr, "Usage: %s show <run-id>\n", args[0])
+			return fmt.Errorf("run id required")
+		}
+		return c.showRun(args[2])
+	case "vars":
+		if len(args) < 3 {
+			fmt.Fprintf(os.Stderr, "Usage: %s vars <job-name>\n", args[0])
+			return fmt.Errorf("job name required")
+		}
+		return c.showVariables(args[2], args[3:])
+	case "add":
+		return c.addContext(args[2:])
+	case "edit":
+		if len(args) < 3 {
+			fmt.Fprintf(os.Stderr, "Usage: %s edit <job-name> [flags]\n", args[0])
+			return fmt.Errorf("job name required")
+		}
+		return c.editContext(args[2], args[3:])
+	case "remove", "rm":
+		if len(args) < 3 {
+			fmt.Fprintf(os.Stderr, "Usage: %s remove <job-name>\n", args[0])
+			return fmt.Errorf("job name required")
+		}
+		return c.removeContext(args[2])
+	case "service":
+		return c.serviceCommand(args[2:])
+	case "status":
+		return c.showStatus(args[2:])
+	case "scheduler":
+		return c.runScheduler(args[2:])
+	case "config":
+		return c.configCommand(args[2:])
+	case "validate":
+		return c.validateConfig(args[2:])
+	case "tui":
+		return c.startTUI()
+	case "help", "-h", "--help":
+		c.showUsage()
+		return nil
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", args[1])
+		c.showUsage()
+		return fmt.Errorf("unknown command")
+	}
+}
+
+func (c *CLI) showUsage() {
+	fmt.Printf(`Usage: go-cmdeck [--output json|yaml|table] <command> [arguments]
+
+Commands:
+  init                  Initialize configuration with example jobs
+  list, ls [--tag <tag>]
+                        List all jobs, or the jobs with the tag
+  execute, exec <name> [action]
+                        Execute job with execution history
+  run <name> [action]   Execute job action (default: run)
+  run --label <label>   Execute all jobs with the given label
+  run --tag <tag>       Execute all jobs with the given tag (repeatable: all tags)
+  run <name>... --parallel N
+                        Execute the jobs, up to N at a time
+  run-all               Execute all jobs
+  history <name>        Show execution history of a job
+  show <run-id>         Show details and output of a run
+  vars <name>           Show the resolved variables of a job
+  add                   Add new job (flags, or interactive with -i)
+  edit <name>           Change fields of a job (flags, or interactive with -i)
+  remove, rm <name>     Remove job
+  service start|stop|restart <name>...
+                        Start, stop or restart service jobs in the background
+  service status [name...]
+                        Show whether the service jobs are running
+  service logs <name> [-n N] [-f]
+                        Show the last N lines of a service log (-f: follow)
+  status [name...]      Run the health checks and show which jobs are up
+  scheduler             Run the jobs with a schedule at their times (foreground)
+  config show           Show the merged configuration (--sources: files and
+                        where each job is defined)
+  validate [file...]    Check the configuration files and report every problem
+  tui                   Start TUI mode
+  help                  Show this help
+
+Options:
+  --config <file>       Use this file instead of ~/.config/go-cmdeck/config.json
+                        (also CMDECK_CONFIG); .cmdeck.json files from the
+                        current directory upwards are merged on top. Files
+                        may also be YAML (.yaml, .yml) or TOML (.toml)
+  -o, --output <format> Output format of list, run, history, show and vars:
+                        table (default), json or yaml
+  -v KEY=VALUE          run/vars: set a variable or parameter (repeatable)
+  --parallel N          run/run-all: run up to N jobs at a time
+  --no-fail             run/run-all: always exit 0 when the job ran
+
+Exit status of run/execute:
+  job's exit code, 124 on timeout, 130 when cancelled, 125 when go-cmdeck
+  itself fails (including when the result cannot be saved); run-all,
+  --label, --tag and --parallel exit 1 if any job did not succeed.
+
+Examples:
+  go-cmdeck init
+  go-cmdeck list
+  go-cmdeck run monitoring
+  go-cmdeck run docker stop
+  go-cmdeck run db-tunnel -v DB_HOST=db.staging.internal
+  go-cmdeck run vpn docker monitoring --parallel 3
+  go-cmdeck list --tag infra
+  go-cmdeck run --tag infra --tag staging
+  go-cmdeck add -name web -label "Web" -tag frontend -cmd run='npm start' -var PORT=3000 -var-mode env
+  go-cmdeck edit web -cmd stop='pkill -f npm' -rm-var PORT
+  go-cmdeck edit db -secret DB_PASSWORD=cmd:'pass show db/prod'
+  go-cmdeck history monitoring
+  go-cmdeck edit db-tunnel -service -restart on-failure
+  go-cmdeck service start db-tunnel
+  go-cmdeck service logs db-tunnel -f
+  go-cmdeck edit db-tunnel -check tcp:localhost:5432 -check-interval 1m
+  go-cmdeck status
+  go-cmdeck edit backup -schedule '0 3 * * *' -catch-up once
+  go-cmdeck scheduler
+  go-cmdeck edit vpn -retry 5 -retry-delay 2s -retry-on 1,2
+  go-cmdeck edit backup -notify desktop -notify https://hooks.example.com/cmdeck -notify-on failure
+  go-cmdeck validate .cmdeck.yaml
+  go-cmdeck --output json run monitoring
+  go-cmdeck tui
+`)
+}
+
+func (c *CLI) listContexts(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	var tags stringListFlag
+	fs.Var(&tags, "tag", "Only list jobs with this tag (repeatable)")
+	fs.Parse(args)
+
+	jobs := c.executor.jobsWithTags(tags)
+
+	if c.output != outputTable {
+		return writeStructured(os.Stdout, c.output, jobs)
+	}
+
+	if len(jobs) == 0 {
+		if len(tags) > 0 {
+			fmt.Printf("No jobs with %s\n", tagFilter("", tags))
+			return nil
+		}
+		fmt.Println("No jobs configured")
+		return nil
+	}
+
+	// The SERVICE and NEXT RUN columns are only shown when there are
+	// services or scheduled jobs.
+	services, scheduled := false, false
+	for _, job := range jobs {
+		services = services || job.Service != nil
+		scheduled = scheduled || job.Schedule != ""
+	}
+	// Scheduled jobs only run while a scheduler does.
+	pending := ""
+	if scheduled && !schedulerRunning(c.executor.config.userLayer().Path) {
+		pending = " (scheduler not running)"
+	}
+	columns := []string{"NAME", "LABEL", "TAGS", "ACTIONS"}
+	if services {
+		columns = append(columns, "SERVICE")
+	}
+	if scheduled {
+		columns = append(columns, "NEXT RUN")
+	}
+	columns = append(columns, "LAST RUN", "DESCRIPTION")
+	rules := make([]string, len(columns))
+	for i, column := range columns {
+		rules[i] = strings.Repeat("-", len(column))
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(columns, "\t"))
+	fmt.Fprintln(w, strings.Join(rules, "\t"))
+
+	for _, job := range jobs {
+		lastRun := "Never"
+		if job.LastResult != nil {
+			lastRun = job.LastResult.statusLabel()
+		}
+
+		if job.LastResult != nil && job.LastResult.Action != "" && job.LastResult.Action != defaultAction {
+			lastRun += fmt.Sprintf(" (%s)", job.LastResult.Action)
+		}
+
+		actions := strings.Join(job.actions(), ",")
+		if services {
+			service := "-"
+			if job.Service != nil {
+				service = c.executor.services.status(job.Name).summary()
+			}
+			actions += "\t" + service
+		}
+		if scheduled {
+			nextRun := "-"
+			if next, ok := job.nextRun(time.Now()); ok {
+				nextRun = timeLabel(next) + pending
+			}
+			actions += "\t" + nextRun
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			job.Name, job.Label, strings.Join(job.Tags, ","), actions, lastRun, job.Description)
+	}
+
+	return w.Flush()
+}
+
+// parseInterspersed parses flags that may appear before, between or
+// after positional arguments and returns the positional arguments.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		// Everything after "--" is positional.
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+func (c *CLI) runCommand(command string, args []string) error {
+	// Bad flags fail like other usage errors, with exitInternal rather
+	// than the 2 of flag.ExitOnError that a failed job could also return.
+	fs := flag.NewFlagSet(command, flag.ContinueOnError)
+	noFail := fs.Bool("no-fail", false, "Exit 0 even if the job fails")
+	label := fs.String("label", "", "Run all jobs with this label")
+	var tags stringListFlag
+	fs.Var(&tags, "tag", "Run all jobs with this tag (repeatable: jobs with all the tags)")
+	parallel := fs.Int("parallel", 0, "Run the given jobs with up to N at a time")
+	values := keyValueFlag{}
+	fs.Var(values, "v", "Set a variable or parameter for this run (KEY=VALUE, repeatable)")
+	positional, err := parseInterspersed(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	if err != nil {
+		return internalError(err)
+	}
+
+	// With --parallel every argument is a job name; batches without it
+	// run one job at a time.
+	parallelSet := false
+	fs.Visit(func(f *flag.Flag) {
+		parallelSet = parallelSet || f.Name == "parallel"
+	})
+	if parallelSet && *parallel < 1 {
+		return internalError(fmt.Errorf("--parallel must be at least 1"))
+	}
+	limit := max(*parallel, 1)
+
+	var runErr error
+	switch {
+	case command == "run-all":
+		if len(positional) > 0 || *label != "" || len(tags) > 0 {
+			return internalError(fmt.Errorf("run-all takes no job names"))
+		}
+		var names []string
+		for _, job := range c.executor.listContexts() {
+			names = append(names, job.Name)
+		}
+		runErr = c.executeBatch(names, values, limit)
+	case *label != "" || len(tags) > 0:
+		if len(positional) > 0 {
+			return internalError(fmt.Errorf("--label and --tag cannot be combined with job names"))
+		}
+		var names []string
+		for _, job := range c.executor.jobsWithTags(tags) {
+			if *label == "" || strings.EqualFold(job.Label, *label) {
+				names = append(names, job.Name)
+			}
+		}
+		if len(names) == 0 {
+			return internalError(fmt.Errorf("no jobs with %s", tagFilter(*label, tags)))
+		}
+		runErr = c.executeBatch(names, values, limit)
+	case parallelSet:
+		if len(positional) == 0 {
+			return internalError(fmt.Errorf("--parallel requires job names"))
+		}
+		runErr = c.executeBatch(positional, values, limit)
+	default:
+		if len(positional) == 0 || len(positional) > 2 {
+			fmt.Fprintf(os.Stderr, "Usage: go-cmdeck %s <job-name> [action] [-v KEY=VALUE]... [--no-fail]\n", command)
+			fmt.Fprintf(os.Stderr, "       go-cmdeck %s <job-name>... --parallel N [-v KEY=VALUE]... [--no-fail]\n", command)
+			return internalError(fmt.Errorf("job name required"))
+		}
+		action := defaultAction
+		if len(positional) > 1 {
+			action = positional[1]
+		}
+		runErr = c.executeJob(positional[0], action, values)
+	}
+
+	var exitErr *exitError
+	if *noFail && errors.As(runErr, &exitErr) && exitErr.err == nil {
+		return nil
+	}
+	return runErr
+}
+
+func (c *CLI) executeJob(name, action string, values map[string]string) error {
+	job, exists := c.executor.config.Contexts[name]
+	if !exists {
+		return internalError(fmt.Errorf("job '%s' not found", name))
+	}
+
+	if _, exists := job.Commands[action]; !exists {
+		actions := job.actions()
+		if len(actions) == 0 {
+			return internalError(fmt.Errorf("job '%s' has no commands", name))
+		}
+		return internalError(fmt.Errorf("job '%s' has no %s command (available: %s)", name, action, strings.Join(actions, ", ")))
+	}
+
+	// Ctrl+C cancels the job (and its process group) instead of leaving
+	// it running without go-cmdeck.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Parameters without a value are asked for on a terminal; prompts go
+	// to stderr to keep structured output clean.
+	if isTerminal(os.Stdin) {
+		p := &prompter{in: bufio.NewReader(os.Stdin), out: os.Stderr}
+		if err := promptParams(p, job, values); err != nil {
+			return internalError(err)
+		}
+	}
+
+	plan, err := c.executor.planWorkflow(name, action, values)
+	if err != nil {
+		return internalError(err)
+	}
+
+	if c.output == outputTable {
+		fmt.Printf("Executing job: %s (%s)\n", job.Label, action)
+		if len(plan.steps) > 0 {
+			fmt.Printf("Workflow: %s\n", strings.Join(plan.chain(), " → "))
+		}
+	}
+	result, err := c.executor.executeWorkflow(ctx, plan, nil)
+	if err != nil {
+		return internalError(err)
+	}
+
+	// Save execution result; a failure is reported after the output.
+	recordErr := c.executor.recordResult(result)
+	if err := c.executor.notify(result); err != nil {
+		warn(err)
+	}
+
+	if c.output != outputTable {
+		if err := writeStructured(os.Stdout, c.output, result); err != nil {
+			return internalError(err)
+		}
+		if recordErr != nil {
+			return internalError(recordErr)
+		}
+		return resultError(result)
+	}
+
+	fmt.Printf("\nJob execution completed:\n")
+	fmt.Printf("Run ID: %s\n", result.RunID)
+	fmt.Printf("Exit Code: %d\n", result.ExitCode)
+	if len(result.Attempts) > 1 {
+		fmt.Printf("Attempts: %s\n", attemptsLabel(result.Attempts))
+	}
+	fmt.Printf("Duration: %s\n", result.Duration.Round(time.Millisecond))
+	fmt.Printf("Status: %s\n", result.statusLabel())
+	printSteps(result)
+
+	fmt.Printf("\nOutput:\n%s\n", result.Output)
+
+	if recordErr != nil {
+		return internalError(recordErr)
+	}
+	return resultError(result)
+}
+
+// warn prints every line of err as a warning.
+func warn(err error) {
+	for _, line := range strings.Split(err.Error(), "\n") {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", line)
+	}
+}
+
+// resultError turns an unsuccessful result into the matching exit status.
+func resultError(result *ExecutionResult) error {
+	if code := exitStatus(result); code != 0 {
+		return &exitError{code: code}
+	}
+	return nil
+}
+
+// batchJobDone is the outcome of one job of a batch run.
+type batchJobDone struct {
+	index  int
+	result *ExecutionResult
+	err    error
+}
+
+// executeBatch runs the jobs and their dependencies once each, with at
+// most parallel jobs at a time. A job starts once its dependencies
+// succeeded; jobs whose dependencies did not succeed are skipped. On a
+// terminal, parallel runs show the status of every job live.
+func (c *CLI) executeBatch(names []string, values map[string]string, parallel int) error {
+	jobs, err := c.executor.planBatch(names, values)
+	if err != nil {
+		return internalError(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	batch := newBatchRun(jobs, parallel)
+	started := make([]time.Time, len(jobs))
+	reported := make([]bool, len(jobs))
+	done := make(chan batchJobDone)
+	live := c.output == outputTable && parallel > 1 && isTerminal(os.Stdout)
+	var tick <-chan time.Time
+	if live {
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	var runErr, recordErr error
+	var notifyErrs []error
+	drawn, frame := 0, 0
+	for {
+		if ctx.Err() != nil {
+			batch.stop()
+		}
+		for _, i := range batch.next() {
+			job := jobs[i]
+			started[i] = time.Now()
+			if c.output == outputTable && !live {
+				fmt.Printf("▶ %s: %s\n", job.Name, job.Label)
+			}
+			go func() {
+				result, err := c.executor.executeJob(ctx, job, defaultAction, nil)
+				done <- batchJobDone{index: i, result: result, err: err}
+			}()
+		}
+		for i, state := range batch.states {
+			if state == batchSkipped && !reported[i] && c.output == outputTable && !live {
+				fmt.Printf("- %s: skipped\n", jobs[i].Name)
+			}
+			reported[i] = reported[i] || state == batchSkipped
+		}
+		if live {
+			drawn = drawBatch(batch, started, frame, drawn)
+		}
+		if batch.finished() {
+			break
+		}
+
+		select {
+		case <-tick:
+			frame = (frame + 1) % len(spinnerFrames)
+		case d := <-done:
+			if d.err != nil {
+				// go-cmdeck itself failed; stop the other jobs.
+				if runErr == nil {
+					runErr = d.err
+				}
+				batch.skip(d.index)
+				batch.stop()
+				cancel()
+				continue
+			}
+			if err := c.executor.recordResult(d.result); err != nil && recordErr == nil {
+				recordErr = err
+			}
+			if err := c.executor.notify(d.result); err != nil {
+				// Reported at the end, not to disturb the live status.
+				notifyErrs = append(notifyErrs, err)
+			}
+			batch.finish(d.index, d.result)
+			if c.output == outputTable && !live {
+				fmt.Printf("%s: %s (%s)\n", jobs[d.index].Name, d.result.statusLabel(), d.result.Duration.Round(time.Millisecond))
+				if !d.result.Success {
+					fmt.Printf("%s\n", d.result.Output)
+				}
+			}
+		}
+	}
+
+	if c.output != outputTable {
+		results := make([]*ExecutionResult, 0, len(jobs))
+		for _, result := range batch.results {
+			if result != nil {
+				results = append(results, result)
+			}
+		}
+		if err := writeStructured(os.Stdout, c.output, results); err != nil {
+			return internalError(err)
+		}
+	} else {
+		if live {
+			for i, result := range batch.results {
+				if result != nil && !result.Success {
+					fmt.Printf("\n%s: %s\n%s\n", jobs[i].Name, result.statusLabel(), result.Output)
+				}
+			}
+		}
+		fmt.Println()
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "JOB\tSTATUS\tEXIT\tDURATION\tRUN ID")
+		fmt.Fprintln(w, "---\t------\t----\t--------\t------")
+		for i, job := range jobs {
+			result := batch.results[i]
+			if result == nil {
+				fmt.Fprintf(w, "%s\t- Skipped\t\t\t\n", job.Name)
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n",
+				job.Name,
+				result.statusLabel(),
+				result.ExitCode,
+				result.Duration.Round(time.Millisecond),
+				result.RunID)
+		}
+		w.Flush()
+	}
+	for _, err := range notifyErrs {
+		warn(err)
+	}
+
+	if runErr != nil {
+		return internalError(runErr)
+	}
+	if recordErr != nil {
+		return internalError(recordErr)
+	}
+	if batch.failed() {
+		return &exitError{code: exitJobsFailed}
+	}
+	return nil
+}
+
+// drawBatch prints one status line per job of a batch run over the
+// lines drawn last time, and returns the number of lines drawn.
+func drawBatch(batch *batchRun, started []time.Time, frame, drawn int) int {
+	width := 0
+	for _, job := range batch.jobs {
+		width = max(width, len(job.Name))
+	}
+
+	var out strings.Builder
+	if drawn > 0 {
+		fmt.Fprintf(&out, "\x1b[%dA", drawn)
+	}
+	for i, job := range batch.jobs {
+		status := "· waiting"
+		switch batch.states[i] {
+		case batchRunning:
+			status = fmt.Sprintf("%s running %s", spinnerFrames[frame], time.Since(started[i]).Truncate(time.Second))
+		case batchDone:
+			result := batch.results[i]
+			status = fmt.Sprintf("%s (exit %d, %s)", result.statusLabel(), result.ExitCode, result.Duration.Round(time.Millisecond))
+		case batchSkipped:
+			status = "- skipped"
+		}
+		fmt.Fprintf(&out, "\r\x1b[2K  %-*s  %s\n", width, job.Name, status)
+	}
+	fmt.Print(out.String())
+	return len(batch.jobs)
+}
+
+// printSteps lists the steps of a workflow run.
+func printSteps(result *ExecutionResult) {
+	if len(result.Steps) == 0 {
+		return
+	}
+
+	fmt.Printf("\nSteps:\n")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for i, step := range result.Steps {
+		fmt.Fprintf(w, "  %d.\t%s\t%s\t%s\texit %d\n",
+			i+1, step.Job, step.statusLabel(), step.Duration.Round(time.Millisecond), step.ExitCode)
+	}
+	w.Flush()
+}
+
+func (c *CLI) showHistory(name string, args []string) error {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	limit := fs.Int("n", 20, "Number of runs to show (0 for all)")
+	fs.Parse(args)
+
+	if _, exists := c.executor.config.Contexts[name]; !exists {
+		return fmt.Errorf("job '%s' not found", name)
+	}
+
+	results, err := c.executor.history.list(name)
+	if err != nil {
+		return err
+	}
+
+	if *limit > 0 && len(results) > *limit {
+		results = results[:*limit]
+	}
+
+	if c.output != outputTable {
+		return writeStructured(os.Stdout, c.output, results)
+	}
+
+	if len(results) == 0 {
+		fmt.Printf("No execution history for job '%s'\n", name)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RUN ID\tACTION\tSTARTED\tDURATION\tEXIT\tSTATUS")
+	fmt.Fprintln(w, "------\t------\t-------\t--------\t----\t------")
+
+	for _, result := range results {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n",
+			result.RunID,
+			result.Action,
+			result.Timestamp.Format("2006-01-02 15:04:05"),
+			result.Duration.Round(time.Millisecond),
+			result.ExitCode,
+			result.statusLabel())
+	}
+
+	return w.Flush()
+}
+
+func (c *CLI) showRun(runID string) error {
+	result, err := c.executor.history.find(runID)
+	if err != nil {
+		return err
+	}
+
+	if c.output != outputTable {
+		return writeStructured(os.Stdout, c.output, result)
+	}
+
+	fmt.Printf("Run ID:    %s\n", result.RunID)
+	fmt.Printf("Job:       %s\n", result.Job)
+	fmt.Printf("Action:    %s\n", result.Action)
+	fmt.Printf("Started:   %s\n", result.Timestamp.Format("2006-01-02 15:04:05"))
+	fmt.Printf("Finished:  %s\n", result.EndTime.Format("2006-01-02 15:04:05"))
+	fmt.Printf("Duration:  %s\n", result.Duration.Round(time.Millisecond))
+	fmt.Printf("Exit Code: %d\n", result.ExitCode)
+	if len(result.Attempts) > 1 {
+		fmt.Printf("Attempts:  %s\n", attemptsLabel(result.Attempts))
+	}
+	fmt.Printf("Status:    %s\n", result.statusLabel())
+	if result.Trigger != "" {
+		fmt.Printf("Trigger:   %s\n", result.Trigger)
+	}
+	printSteps(result)
+	fmt.Printf("\nOutput:\n%s\n", result.Output)
+
+	return nil
+}
+
+// showVariables prints the variables of a job after resolution, with
+// where each value came from. Unresolved placeholders and missing
+// parameters are reported after the resolved ones.
+func (c *CLI) showVariables(name string, args []string) error {
+	fs := flag.NewFlagSet("vars", flag.ExitOnError)
+	values := keyValueFlag{}
+	fs.Var(values, "v", "Set a variable or parameter (KEY=VALUE, repeatable)")
+	fs.Parse(args)
+
+	job, exists := c.executor.config.Contexts[name]
+	if !exists {
+		return fmt.Errorf("job '%s' not found", name)
+	}
+
+	vars, err := resolveJobVariables(job, values)
+	if vars == nil && err != nil {
+		return err
+	}
+
+	if c.output != outputTable {
+		if werr := writeStructured(os.Stdout, c.output, vars); werr != nil {
+			return werr
+		}
+		return err
+	}
+
+	if len(vars) == 0 && err == nil {
+		fmt.Printf("Job '%s' uses no variables\n", name)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tVALUE\tSOURCE")
+	fmt.Fprintln(w, "----\t-----\t------")
+	for _, v := range vars {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", v.Name, v.Value, v.Source)
+	}
+	if werr := w.Flush(); werr != nil {
+		return werr
+	}
+	return err
+}
+
+func (c *CLI) addContext(args []string) error {
+	fs := flag.NewFlagSet("add", flag.ExitOnError)
+	name := fs.String("name", "", "Context name (required)")
+	label := fs.String("label", "", "Context label (required)")
+	description := fs.String("description", "", "Context description")
+	var tags stringListFlag
+	fs.Var(&tags, "tag", "Tag of the job (repeatable)")
+	commands := keyValueFlag{}
+	fs.Var(commands, "cmd", "Action command as action=command (repeatable)")
+	variables := keyValueFlag{}
+	fs.Var(variables, "var", "Variable as KEY=VALUE (repeatable)")
+	varMode := fs.String("var-mode", "", "How variables reach the commands: splice (default) or env")
+	secrets := secretFlag{}
+	fs.Var(secrets, "secret", "Secret as NAME=file:PATH, NAME=env:VAR or NAME=cmd:COMMAND (repeatable)")
+	timeout := fs.String("timeout", "", "Maximum run time of an action (e.g. 30s, 5m)")
+	var dependsOn stringListFlag
+	fs.Var(&dependsOn, "depends-on", "Job that has to run first (repeatable)")
+	service := fs.Bool("service", false, "Run the job as a service with 'service start'")
+	restart := fs.String("restart", "", "Restart policy of the service: no (default), on-failure or always (implies -service)")
+	check := fs.String("check", "", "Health check: cmd:COMMAND, tcp:HOST:PORT, http(s)://URL or process:PATTERN")
+	checkInterval := fs.String("check-interval", "", "How often the TUI runs the health check (default 30s)")
+	schedule := fs.String("schedule", "", "Run the job with the scheduler: cron expression or @every <duration>")
+	overlap := fs.String("overlap", "", "When the job is due while still running: skip (default), queue or allow")
+	catchUp := fs.String("catch-up", "", "Runs missed while the scheduler was down: none (default), once or all")
+	retry := fs.Int("retry", 0, "Run a failed run action up to N times in all")
+	retryDelay := fs.String("retry-delay", "", "Delay before the first retry, doubled after every attempt (default 1s)")
+	retryOn := fs.String("retry-on", "", "Only retry on these exit codes (comma-separated)")
+	var notify notifyFlag
+	fs.Var(&notify, "notify", "Notify about finished runs: desktop, bell, http(s)://URL or cmd:COMMAND (repeatable)")
+	notifyOn := fs.String("notify-on", "", "Runs to notify about: failure, success or always (default)")
+	interactive := fs.Bool("i", false, "Prompt for the job definition")
+	force := fs.Bool("force", false, "Replace an existing job")
+	project := fs.Bool("project", false, "Add the job to the project's "+projectConfigName+" file")
+
+	fs.Parse(args)
+
+	if *interactive || (fs.NFlag() == boolFlags(*project) && isTerminal(os.Stdin)) {
+		return c.addContextInteractive(*project)
+	}
+
+	if *name == "" || *label == "" {
+		fmt.Fprintf(os.Stderr, "Usage: go-cmdeck add -name <name> -label <label> [-description <desc>] [-tag <tag>]... [-cmd action=command]... [-var KEY=VALUE]...\n")
+		return fmt.Errorf("name and label are required")
+	}
+	if _, exists := c.executor.config.Contexts[*name]; exists && !*force {
+		return fmt.Errorf("job '%s' already exists (use -force to replace it)", *name)
+	}
+	if err := checkTimeout(*timeout); err != nil {
+		return err
+	}
+	if err := checkVariableMode(*varMode); err != nil {
+		return err
+	}
+	if err := checkRestartPolicy(*restart); err != nil {
+		return err
+	}
+
+	job := Context{
+		Name:         *name,
+		Label:        *label,
+		Description:  *description,
+		Tags:         tags,
+		Commands:     commands,
+		Variables:    variables,
+		VariableMode: *varMode,
+		Secrets:      secrets,
+		Timeout:      *timeout,
+		DependsOn:    dependsOn,
+		Schedule:     *schedule,
+		Overlap:      *overlap,
+		CatchUp:      *catchUp,
+	}
+	if *service || *restart != "" {
+		job.Service = &Service{Restart: *restart}
+	}
+	if *check != "" {
+		var err error
+		if job.Check, err = parseHealthCheck(*check); err != nil {
+			return err
+		}
+		job.Check.Interval = *checkInterval
+	} else if *checkInterval != "" {
+		return fmt.Errorf("-check-interval requires -check")
+	}
+	if err := job.checkHealth(); err != nil {
+		return err
+	}
+	if err := job.checkSchedule(); err != nil {
+		return err
+	}
+	if *retry > 0 {
+		job.Retry = &Retry{Attempts: *retry, Delay: *retryDelay}
+		if *retryOn != "" {
+			var err error
+			if job.Retry.ExitCodes, err = parseExitCodes(*retryOn); err != nil {
+				return err
+			}
+		}
+	} else if *retryDelay != "" || *retryOn != "" {
+		return fmt.Errorf("-retry-delay and -retry-on require -retry")
+	}
+	if err := job.checkRetry(); err != nil {
+		return err
+	}
+	var err error
+	if job.Notify, err = notify.with(*notifyOn); err != nil {
+		return err
+	}
+	if err := job.checkNotify(); err != nil {
+		return err
+	}
+	if err := job.checkSecrets(); err != nil {
+		return err
+	}
+	if err := job.checkTags(); err != nil {
+		return err
+	}
+
+	return c.storeNewJob(job, *project)
+}
+
+// storeNewJob saves a job created by add to the user configuration, or
+// to the project file if project is set.
+func (c *CLI) storeNewJob(job Context, project bool) error {
+	config := c.executor.config
+	layer := config.userLayer()
+	if project {
+		var err error
+		if layer, err = config.projectLayer(); err != nil {
+			return err
+		}
+	}
+
+	config.Contexts[job.Name] = job
+	config.setSource(job.Name, layer)
+	if err := config.save(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Added job: %s (%s)\n", job.Name, layer.Path)
+	return nil
+}
+
+// boolFlags counts the set boolean flags.
+func boolFlags(flags ...bool) int {
+	n := 0
+	for _, set := range flags {
+		if set {
+			n++
+		}
+	}
+	return n
+}
+
+func (c *CLI) addContextInteractive(project bool) error {
+	p := newPrompter()
+
+	var name string
+	for {
+		answer, err := p.askRequired("Name", "")
+		if err != nil {
+			return err
+		}
+		if _, exists := c.executor.config.Contexts[answer]; !exists {
+			name = answer
+			break
+		}
+		fmt.Printf("  job '%s' already exists\n", answer)
+	}
+
+	job := Context{
+		Name:      name,
+		Commands:  make(map[string]string),
+		Variables: make(map[string]string),
+	}
+	if err := c.promptContext(p, &job); err != nil {
+		return err
+	}
+
+	return c.storeNewJob(job, project)
+}
+
+// promptContext asks for every editable field of the job, offering the
+// current values as defaults.
+func (c *CLI) promptContext(p *prompter, job *Context) error {
+	var err error
+	if job.Label, err = p.askRequired("Label", firstNonEmpty(job.Label, job.Name)); err != nil {
+		return err
+	}
+	if job.Description, err = p.ask("Description", job.Description); err != nil {
+		return err
+	}
+	for {
+		answer, err := p.ask("Tags (comma-separated; - for none)", strings.Join(job.Tags, ","))
+		if err != nil {
+			return err
+		}
+		var tags stringListFlag
+		if answer != "-" {
+			tags.Set(answer)
+		}
+		job.Tags = tags
+		if err := job.checkTags(); err != nil {
+			fmt.Printf("  %v\n", err)
+			continue
+		}
+		break
+	}
+
+	if len(job.Commands) == 0 {
+		runCmd, err := p.ask("Command for the run action", "")
+		if err != nil {
+			return err
+		}
+		if runCmd != "" {
+			job.Commands[defaultAction] = runCmd
+		}
+	} else {
+		for _, action := range job.actions() {
+			command, err := p.ask(fmt.Sprintf("Command for %s (- to remove)", action), job.Commands[action])
+			if err != nil {
+				return err
+			}
+			if command == "-" {
+				delete(job.Commands, action)
+				delete(job.ActionResults, action)
+				continue
+			}
+			job.Commands[action] = command
+		}
+	}
+	commands, err := p.askPairs("Add action (action=command, empty to finish)")
+	if err != nil {
+		return err
+	}
+	for action, command := range commands {
+		job.Commands[action] = command
+	}
+
+	for _, key := range sortedKeys(job.Variables) {
+		value, err := p.ask(fmt.Sprintf("Variable %s (- to remove)", key), job.Variables[key])
+		if err != nil {
+			return err
+		}
+		if value == "-" {
+			delete(job.Variables, key)
+			continue
+		}
+		job.Variables[key] = value
+	}
+	variables, err := p.askPairs("Add variable (KEY=VALUE, empty to finish)")
+	if err != nil {
+		return err
+	}
+	for key, value := range variables {
+		job.Variables[key] = value
+	}
+
+	if job.Secrets == nil {
+		job.Secrets = make(map[string]Secret)
+	}
+	for _, name := range sortedSecretNames(job.Secrets) {
+		for {
+			spec, err := p.ask(fmt.Sprintf("Secret %s (- to remove)", name), job.Secrets[name].String())
+			if err != nil {
+				return err
+			}
+			if spec == "-" {
+				delete(job.Secrets, name)
+				break
+			}
+			secret, err := parseSecret(spec)
+			if err != nil {
+				fmt.Printf("  %v\n", err)
+				continue
+			}
+			job.Secrets[name] = secret
+			break
+		}
+	}
+	secretSpecs, err := p.askPairs("Add secret (NAME=file:PATH, env:VAR or cmd:COMMAND, empty to finish)")
+	if err != nil {
+		return err
+	}
+	for name, spec := range secretSpecs {
+		secret, err := parseSecret(spec)
+		if err != nil {
+			fmt.Printf("  %s: %v\n", name, err)
+			continue
+		}
+		job.Secrets[name] = secret
+	}
+	if len(job.Secrets) == 0 {
+		job.Secrets = nil
+	}
+
+	if len(job.Variables) > 0 || len(job.Secrets) > 0 {
+		for {
+			mode, err := p.ask("Variable mode (splice or env)", firstNonEmpty(job.VariableMode, variableModeSplice))
+			if err != nil {
+				return err
+			}
+			if err := checkVariableMode(mode); err != nil {
+				fmt.Printf("  %v\n", err)
+				continue
+			}
+			job.VariableMode = mode
+			if mode == variableModeSplice {
+				job.VariableMode = ""
+			}
+			break
+		}
+	}
+
+	for {
+		timeout, err := p.ask("Timeout (e.g. 30s, 5m; - for none)", job.Timeout)
+		if err != nil {
+			return err
+		}
+		if timeout == "-" {
+			timeout = ""
+		}
+		if err := checkTimeout(timeout); err != nil {
+			fmt.Printf("  %v\n", err)
+			continue
+		}
+		job.Timeout = timeout
+		break
+	}
+
+	deps, err := p.ask("Depends on (comma-separated jobs; - for none)", strings.Join(job.DependsOn, ","))
+	if err != nil {
+		return err
+	}
+	var dependsOn stringListFlag
+	if deps != "-" {
+		dependsOn.Set(deps)
+	}
+	job.DependsOn = dependsOn
+
+	return nil
+}
+
+func (c *CLI) editContext(name string, args []string) error {
+	job, exists := c.executor.config.Contexts[name]
+	if !exists {
+		return fmt.Errorf("job '%s' not found", name)
+	}
+	if job.Commands == nil {
+		job.Commands = make(map[string]string)
+	}
+	if job.Variables == nil {
+		job.Variables = make(map[string]string)
+	}
+
+	fs := flag.NewFlagSet("edit", flag.ExitOnError)
+	label := fs.String("label", "", "New label")
+	description := fs.String("description", "", "New description")
+	var tags stringListFlag
+	fs.Var(&tags, "tag", "Add tag (repeatable)")
+	var removeTags stringListFlag
+	fs.Var(&removeTags, "rm-tag", "Remove tag (repeatable)")
+	commands := keyValueFlag{}
+	fs.Var(commands, "cmd", "Set action command as action=command (repeatable)")
+	var removeCommands stringListFlag
+	fs.Var(&removeCommands, "rm-cmd", "Remove action (repeatable)")
+	variables := keyValueFlag{}
+	fs.Var(variables, "var", "Set variable as KEY=VALUE (repeatable)")
+	var removeVariables stringListFlag
+	fs.Var(&removeVariables, "rm-var", "Remove variable (repeatable)")
+	varMode := fs.String("var-mode", "", "How variables reach the commands: splice or env")
+	secrets := secretFlag{}
+	fs.Var(secrets, "secret", "Set secret as NAME=file:PATH, NAME=env:VAR or NAME=cmd:COMMAND (repeatable)")
+	var removeSecrets stringListFlag
+	fs.Var(&removeSecrets, "rm-secret", "Remove secret (repeatable)")
+	timeout := fs.String("timeout", "", "Maximum run time of an action (empty to remove)")
+	var dependsOn stringListFlag
+	fs.Var(&dependsOn, "depends-on", "Replace dependencies (repeatable, empty to remove)")
+	service := fs.Bool("service", false, "Run the job as a service (-service=false: run it in the foreground)")
+	restart := fs.String("restart", "", "Restart policy of the service: no, on-failure or always (implies -service)")
+	check := fs.String("check", "", "Health check: cmd:COMMAND, tcp:HOST:PORT, http(s)://URL or process:PATTERN (empty to remove)")
+	checkInterval := fs.String("check-interval", "", "How often the TUI runs the health check")
+	schedule := fs.String("schedule", "", "Cron expression or @every <duration> (empty to remove the schedule)")
+	overlap := fs.String("overlap", "", "When the job is due while still running: skip, queue or allow")
+	catchUp := fs.String("catch-up", "", "Runs missed while the scheduler was down: none, once or all")
+	retry := fs.Int("retry", 0, "Run a failed run action up to N times in all (0 to remove the retry settings)")
+	retryDelay := fs.String("retry-delay", "", "Delay before the first retry")
+	retryOn := fs.String("retry-on", "", "Only retry on these exit codes (comma-separated, empty for any)")
+	var notify notifyFlag
+	fs.Var(&notify, "notify", "Replace notifications: desktop, bell, http(s)://URL or cmd:COMMAND (repeatable, empty to remove)")
+	notifyOn := fs.String("notify-on", "", "Runs to notify about: failure, success or always")
+	interactive := fs.Bool("i", false, "Prompt for every field")
+
+	fs.Parse(args)
+
+	if *interactive || (fs.NFlag() == 0 && isTerminal(os.Stdin)) {
+		if err := c.promptContext(newPrompter(), &job); err != nil {
+			return err
+		}
+	} else if fs.NFlag() == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: go-cmdeck edit <job-name> [-label <label>] [-description <desc>] [-tag <tag>] [-rm-tag <tag>] [-cmd action=command] [-rm-cmd action] [-var KEY=VALUE] [-rm-var KEY] [-var-mode splice|env] [-secret NAME=SOURCE] [-rm-secret NAME] [-timeout <duration>] [-depends-on <job>] [-service] [-restart <policy>] [-check <probe>] [-check-interval <duration>] [-schedule <cron>] [-overlap <policy>] [-catch-up <policy>] [-retry <attempts>] [-retry-delay <duration>] [-retry-on <codes>] [-notify <target>] [-notify-on <policy>] [-i]\n")
+		return fmt.Errorf("nothing to change")
+	}
+
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		// Stop at the first invalid flag; later cases must not
+		// overwrite its error.
+		if err != nil {
+			return
+		}
+		switch f.Name {
+		case "label":
+			if *label == "" {
+				err = fmt.Errorf("label must not be empty")
+			}
+			job.Label = *label
+		case "description":
+			job.Description = *description
+		case "timeout":
+			if err = checkTimeout(*timeout); err == nil {
+				job.Timeout = *timeout
+			}
+		case "var-mode":
+			if err = checkVariableMode(*varMode); err == nil {
+				job.VariableMode = *varMode
+			}
+		case "depends-on":
+			job.DependsOn = dependsOn
+		case "service":
+			if !*service {
+				job.Service = nil
+			} else if job.Service == nil {
+				job.Service = &Service{}
+			}
+		case "restart":
+			if err = checkRestartPolicy(*restart); err == nil {
+				if job.Service == nil {
+					job.Service = &Service{}
+				}
+				job.Service.Restart = *restart
+			}
+		case "check":
+			if *check == "" {
+				job.Check = nil
+				break
+			}
+			var probe *HealthCheck
+			if probe, err = parseHealthCheck(*check); err == nil {
+				if job.Check != nil {
+					probe.Interval, probe.Timeout = job.Check.Interval, job.Check.Timeout
+				}
+				job.Check = probe
+			}
+		case "schedule":
+			job.Schedule = *schedule
+			if *schedule == "" {
+				job.Overlap, job.CatchUp = "", ""
+			}
+		case "overlap":
+			job.Overlap = *overlap
+		case "catch-up":
+			job.CatchUp = *catchUp
+		case "retry":
+			if *retry == 0 {
+				job.Retry = nil
+			} else if job.Retry == nil {
+				job.Retry = &Retry{Attempts: *retry}
+			} else {
+				retried := *job.Retry
+				retried.Attempts = *retry
+				job.Retry = &retried
+			}
+		case "retry-delay", "retry-on":
+			// Visit goes in lexical order, so -retry is already applied.
+			if job.Retry == nil {
+				err = fmt.Errorf("job '%s' has no retry settings", name)
+				break
+			}
+			retried := *job.Retry
+			if f.Name == "retry-delay" {
+				retried.Delay = *retryDelay
+			} else if *retryOn == "" {
+				retried.ExitCodes = nil
+			} else if codes, parseErr := parseExitCodes(*retryOn); parseErr != nil {
+				err = parseErr
+			} else {
+				retried.ExitCodes = codes
+			}
+			job.Retry = &retried
+		case "notify":
+			job.Notify = []Notification(notify)
+		case "notify-on":
+			// Visit goes in lexical order, so -notify is already applied.
+			if len(job.Notify) == 0 {
+				err = fmt.Errorf("job '%s' has no notifications", name)
+				break
+			}
+			job.Notify, err = notifyFlag(job.Notify).with(*notifyOn)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if *checkInterval != "" {
+		if job.Check == nil {
+			return fmt.Errorf("job '%s' has no check", name)
+		}
+		checked := *job.Check
+		checked.Interval = *checkInterval
+		job.Check = &checked
+	}
+	if err := job.checkHealth(); err != nil {
+		return err
+	}
+	if err := job.checkSchedule(); err != nil {
+		return err
+	}
+	if err := job.checkRetry(); err != nil {
+		return err
+	}
+	if err := job.checkNotify(); err != nil {
+		return err
+	}
+
+	for _, tag := range tags {
+		if !job.hasTags([]string{tag}) {
+			job.Tags = append(job.Tags, tag)
+		}
+	}
+	for _, tag := range removeTags {
+		var removed bool
+		if job.Tags, removed = removeTag(job.Tags, tag); !removed {
+			return fmt.Errorf("job '%s' has no tag %s", name, tag)
+		}
+	}
+	if err := job.checkTags(); err != nil {
+		return err
+	}
+	for action, command := range commands {
+		job.Commands[action] = command
+	}
+	for _, action := range removeCommands {
+		if _, exists := job.Commands[action]; !exists {
+			return fmt.Errorf("job '%s' has no %s command", name, action)
+		}
+		delete(job.Commands, action)
+		delete(job.ActionResults, action)
+	}
+	for key, value := range variables {
+		job.Variables[key] = value
+	}
+	for _, key := range removeVariables {
+		if _, exists := job.Variables[key]; !exists {
+			return fmt.Errorf("job '%s' has no variable %s", name, key)
+		}
+		delete(job.Variables, key)
+	}
+	if len(secrets) > 0 && job.Secrets == nil {
+		job.Secrets = make(map[string]Secret)
+	}
+	for key, secret := range secrets {
+		job.Secrets[key] = secret
+	}
+	for _, key := range removeSecrets {
+		if _, exists := job.Secrets[key]; !exists {
+			return fmt.Errorf("job '%s' has no secret %s", name, key)
+		}
+		delete(job.Secrets, key)
+	}
+	if err := job.checkSecrets(); err != nil {
+		return err
+	}
+
+	c.executor.config.Contexts[name] = job
+	if err := c.executor.config.save(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Updated job: %s\n", name)
+	return nil
 }
 
-func NewCLI(executor *Executor) *CLI {
-	return &CLI{executor: executor}
+func checkTimeout(timeout string) error {
+	if timeout == "" {
+		return nil
+	}
+	if _, err := parseDuration(timeout); err != nil {
+		return fmt.Errorf("invalid timeout: %w", err)
+	}
+	return nil
 }
 
-func (c *CLI) Run(args []string) error {
-	if len(args) < 2 {
-		c.showUsage()
+func checkVariableMode(mode string) error {
+	switch mode {
+	case "", variableModeSplice, variableModeEnv:
 		return nil
 	}
+	return fmt.Errorf("invalid variable mode %q (expected %s or %s)", mode, variableModeSplice, variableModeEnv)
+}
 
-	switch args[1] {
-	case "init":
-		return c.initConfig()
-	case "list", "ls":
-		return c.listContexts()
-	case "execute", "exec":
-		if len(args) < 3 {
-			fmt.Fprintf(os.Stderr, "Usage: %s execute <job-name>\n", args[0])
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (c *CLI) removeContext(name string) error {
+	if _, exists := c.executor.config.Contexts[name]; !exists {
+		return fmt.Errorf("job '%s' not found", name)
+	}
+
+	source := c.executor.config.source(name)
+	shadowed := c.executor.config.shadowed(name)
+	if err := c.executor.config.remove(name); err != nil {
+		return err
+	}
+
+	fmt.Printf("Removed job: %s (%s)\n", name, source.Path)
+	if len(shadowed) > 0 {
+		fmt.Printf("Note: '%s' is still defined in %s\n", name, shadowed[len(shadowed)-1].Path)
+	}
+	return nil
+}
+
+// serviceCommand starts, stops and inspects service jobs.
+func (c *CLI) serviceCommand(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: go-cmdeck service start|stop|restart|status|logs [name...]\n")
+		return fmt.Errorf("service command required")
+	}
+
+	switch args[0] {
+	case "start", "stop", "restart":
+		if len(args) < 2 {
+			fmt.Fprintf(os.Stderr, "Usage: go-cmdeck service %s <job-name>...\n", args[0])
 			return fmt.Errorf("job name required")
 		}
-		return c.executeJob(args[2])
-	case "run":
-		if len(args) < 3 {
-			fmt.Fprintf(os.Stderr, "Usage: %s run <job-name>\n", args[0])
+		failed := false
+		for _, name := range args[1:] {
+			if err := c.controlService(args[0], name); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				failed = true
+			}
+		}
+		if failed {
+			return &exitError{code: 1}
+		}
+		return nil
+	case "status":
+		return c.showServices(args[1:])
+	case "logs":
+		if len(args) < 2 {
+			fmt.Fprintf(os.Stderr, "Usage: go-cmdeck service logs <job-name> [-n <count>] [-f]\n")
 			return fmt.Errorf("job name required")
 		}
-		return c.executeJob(args[2])
-	case "add":
-		return c.addContext(args[2:])
-	case "remove", "rm":
-		if len(args) < 3 {
-			fmt.Fprintf(os.Stderr, "Usage: %s remove <job-name>\n", args[0])
+		return c.showServiceLog(args[1], args[2:])
+	case "supervise":
+		// Started by "service start" in the background; see
+		// superviseService.
+		if len(args) != 2 {
 			return fmt.Errorf("job name required")
 		}
-		return c.removeContext(args[2])
-	case "tui":
-		return c.startTUI()
-	case "help", "-h", "--help":
-		c.showUsage()
-		return nil
-	default:
-		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", args[1])
-		c.showUsage()
-		return fmt.Errorf("unknown command")
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		return c.executor.superviseService(ctx, args[1])
 	}
+	fmt.Fprintf(os.Stderr, "Unknown service command: %s\n", args[0])
+	return fmt.Errorf("unknown service command")
 }
 
-func (c *CLI) showUsage() {
-	fmt.Printf(`Usage: go-cmdeck <command> [arguments]
-
-Commands:
-  init                  Initialize configuration with example jobs
-  list, ls              List all jobs
-  execute, exec <name>  Execute job with execution history
-  run <name>            Execute job with execution history
-  add                   Add new job (interactive)
-  remove, rm <name>     Remove job
-  tui                   Start TUI mode
-  help                  Show this help
-
-Examples:
-  go-cmdeck init
-  go-cmdeck list
-  go-cmdeck run monitoring
-  go-cmdeck tui
-`)
+// controlService starts, stops or restarts a service.
+func (c *CLI) controlService(command, name string) error {
+	switch command {
+	case "start":
+		if err := c.executor.startService(name); err != nil {
+			return err
+		}
+		fmt.Printf("Started service: %s (log: %s)\n", name, c.executor.services.logPath(name))
+	case "stop":
+		if err := c.executor.stopService(name); err != nil {
+			return err
+		}
+		fmt.Printf("Stopped service: %s\n", name)
+	case "restart":
+		if _, err := c.executor.serviceJob(name); err != nil {
+			return err
+		}
+		if c.executor.services.status(name).State != serviceStopped {
+			if err := c.executor.stopService(name); err != nil {
+				return err
+			}
+		}
+		if err := c.executor.startService(name); err != nil {
+			return err
+		}
+		fmt.Printf("Restarted service: %s\n", name)
+	}
+	return nil
 }
 
-func (c *CLI) listContexts() error {
-	jobs := c.executor.listContexts()
-	
-	if len(jobs) == 0 {
-		fmt.Println("No jobs configured")
+// showServices lists the status of the given services, or of every
+// service job.
+func (c *CLI) showServices(names []string) error {
+	if len(names) == 0 {
+		for _, job := range c.executor.listContexts() {
+			if job.Service != nil {
+				names = append(names, job.Name)
+			}
+		}
+	}
+	statuses := make([]serviceStatus, 0, len(names))
+	for _, name := range names {
+		if _, err := c.executor.serviceJob(name); err != nil {
+			return err
+		}
+		statuses = append(statuses, c.executor.services.status(name))
+	}
+
+	if c.output != outputTable {
+		return writeStructured(os.Stdout, c.output, statuses)
+	}
+	if len(statuses) == 0 {
+		fmt.Println("No service jobs configured")
 		return nil
 	}
 
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-	fmt.Fprintln(w, "NAME\tLABEL\tLAST RUN\tDESCRIPTION")
-	fmt.Fprintln(w, "----\t-----\t--------\t-----------")
-
-	for _, job := range jobs {
-		lastRun := "Never"
-		if job.LastResult != nil {
-			if job.LastResult.Success {
-				lastRun = "✓ Success"
-			} else {
-				lastRun = "✗ Failed"
-			}
+	fmt.Fprintln(w, "NAME\tSTATUS\tPID\tRESTARTS\tLOG")
+	fmt.Fprintln(w, "----\t------\t---\t--------\t---")
+	for _, st := range statuses {
+		pid := "-"
+		if st.PID > 0 {
+			pid = fmt.Sprint(st.PID)
 		}
-		
-		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", 
-			job.Name, job.Label, lastRun, job.Description)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", st.Name, st.summary(), pid, st.Restarts, st.Log)
 	}
-	
 	return w.Flush()
 }
 
-func (c *CLI) executeJob(name string) error {
-	job, exists := c.executor.config.Contexts[name]
-	if !exists {
-		return fmt.Errorf("job '%s' not found", name)
+// showServiceLog prints the end of a service log and, with -f, what is
+// written to it until Ctrl+C.
+func (c *CLI) showServiceLog(name string, args []string) error {
+	fs := flag.NewFlagSet("service logs", flag.ExitOnError)
+	limit := fs.Int("n", 20, "Number of lines to show (0 for all)")
+	follow := fs.Bool("f", false, "Keep showing new lines")
+	fs.Parse(args)
+
+	if _, err := c.executor.serviceJob(name); err != nil {
+		return err
+	}
+	tail, offset, err := c.executor.services.tailLog(name, *limit)
+	if err != nil {
+		return err
+	}
+	if tail == "" && !*follow {
+		fmt.Fprintf(os.Stderr, "No log for service '%s' (%s)\n", name, c.executor.services.logPath(name))
+	}
+	fmt.Print(tail)
+	if !*follow {
+		return nil
 	}
 
-	runCmd, exists := job.Commands["run"]
-	if !exists {
-		return fmt.Errorf("job '%s' has no run command", name)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return c.executor.services.followLog(ctx, name, offset, os.Stdout)
+}
+
+// showStatus runs the health checks of the given jobs, or of every job
+// with a check or a service, and shows which of them are up. Services
+// without a check are up while they run. It exits with 1 if a job is
+// not up.
+func (c *CLI) showStatus(names []string) error {
+	var jobs []Context
+	if len(names) == 0 {
+		for _, job := range c.executor.listContexts() {
+			if job.Check != nil || job.Service != nil {
+				jobs = append(jobs, job)
+			}
+		}
+	}
+	for _, name := range names {
+		job, exists := c.executor.config.Contexts[name]
+		if !exists {
+			return fmt.Errorf("job '%s' not found", name)
+		}
+		if job.Check == nil && job.Service == nil {
+			return fmt.Errorf("job '%s' has no check", name)
+		}
+		jobs = append(jobs, job)
 	}
 
-	fmt.Printf("Executing job: %s\n", job.Label)
-	output, exitCode, err := c.executor.executeJobWithOutput(runCmd, job.Variables)
-	
-	// Save execution result
-	result := &ExecutionResult{
-		Timestamp: time.Now(),
-		Success:   err == nil && exitCode == 0,
-		ExitCode:  exitCode,
-		Output:    output,
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	statuses := make([]HealthStatus, len(jobs))
+	errs := make([]error, len(jobs))
+	done := make(chan struct{})
+	for i, job := range jobs {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			if job.Check == nil {
+				statuses[i] = serviceHealth(c.executor.services.status(job.Name))
+				return
+			}
+			statuses[i], errs[i] = c.executor.checkJob(ctx, job)
+		}()
 	}
-	
-	job.LastResult = result
-	c.executor.config.Contexts[name] = job
-	c.executor.config.save()
-	
-	fmt.Printf("\nJob execution completed:\n")
-	fmt.Printf("Exit Code: %d\n", exitCode)
-	if result.Success {
-		fmt.Printf("Status: ✓ Success\n")
+	for range jobs {
+		<-done
+	}
+	for _, err := range errs {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}
+
+	allUp := true
+	for _, st := range statuses {
+		allUp = allUp && st.State == healthUp
+	}
+	if c.output != outputTable {
+		if err := writeStructured(os.Stdout, c.output, statuses); err != nil {
+			return err
+		}
+	} else if len(statuses) == 0 {
+		fmt.Println("No jobs with a check or a service")
 	} else {
-		fmt.Printf("Status: ✗ Failed\n")
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tHEALTH\tSINCE\tDETAIL")
+		fmt.Fprintln(w, "----\t------\t-----\t------")
+		for _, st := range statuses {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", st.Job, st.State, timeLabel(st.Changed), st.Message)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	if !allUp {
+		return &exitError{code: exitJobsFailed}
 	}
-	
-	fmt.Printf("\nOutput:\n%s\n", output)
-	
 	return nil
 }
 
-func (c *CLI) addContext(args []string) error {
-	fs := flag.NewFlagSet("add", flag.ExitOnError)
-	name := fs.String("name", "", "Context name (required)")
-	label := fs.String("label", "", "Context label (required)")
-	description := fs.String("description", "", "Context description")
-	
-	fs.Parse(args)
-	
-	if *name == "" || *label == "" {
-		fmt.Fprintf(os.Stderr, "Usage: go-cmdeck add -name <name> -label <label> [-description <desc>]\n")
-		return fmt.Errorf("name and label are required")
+// runScheduler runs the scheduler in the foreground until it gets
+// Ctrl+C or SIGTERM.
+func (c *CLI) runScheduler(args []string) error {
+	if len(args) > 0 {
+		fmt.Fprintf(os.Stderr, "Usage: go-cmdeck scheduler\n")
+		return fmt.Errorf("scheduler takes no arguments")
 	}
 
-	job := Context{
-		Name:        *name,
-		Label:       *label,
-		Description: *description,
-		Commands:    make(map[string]string),
-		Variables:   make(map[string]string),
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return NewScheduler(c.executor, c.configPath, os.Stdout).Run(ctx)
+}
+
+// jobSource is where a job is defined, as shown by config show --sources.
+type jobSource struct {
+	Name      string   `json:"name"`
+	Source    string   `json:"source"`
+	Overrides []string `json:"overrides,omitempty"`
+}
+
+func (c *CLI) configCommand(args []string) error {
+	if len(args) == 0 || args[0] != "show" {
+		fmt.Fprintf(os.Stderr, "Usage: go-cmdeck config show [--sources]\n")
+		return fmt.Errorf("unknown config command")
 	}
 
-	c.executor.config.Contexts[*name] = job
-	if err := c.executor.config.save(); err != nil {
-		return err
+	fs := flag.NewFlagSet("config show", flag.ExitOnError)
+	sources := fs.Bool("sources", false, "Show the configuration files and where each job is defined")
+	fs.Parse(args[1:])
+
+	config := c.executor.config
+	if !*sources {
+		// Show the definitions only; results are runtime state.
+		merged := *config
+		merged.Contexts = make(map[string]Context, len(config.Contexts))
+		for name, job := range config.Contexts {
+			job.LastResult = nil
+			job.ActionResults = nil
+			merged.Contexts[name] = job
+		}
+		format := c.output
+		if format == outputTable {
+			format = outputJSON
+		}
+		return writeStructured(os.Stdout, format, &merged)
 	}
 
-	fmt.Printf("Added job: %s\n", *name)
-	return nil
+	var jobs []jobSource
+	for _, job := range c.executor.listContexts() {
+		entry := jobSource{Name: job.Name, Source: config.source(job.Name).Path}
+		for _, layer := range config.shadowed(job.Name) {
+			entry.Overrides = append(entry.Overrides, layer.Path)
+		}
+		jobs = append(jobs, entry)
+	}
+
+	if c.output != outputTable {
+		return writeStructured(os.Stdout, c.output, map[string]any{
+			"files": config.layers,
+			"jobs":  jobs,
+		})
+	}
+
+	fmt.Println("Configuration files (lowest to highest priority):")
+	for i, layer := range config.layers {
+		missing := ""
+		if !layer.Exists {
+			missing = " (not found)"
+		}
+		fmt.Printf("  %d. %-8s %s%s\n", i+1, layer.Kind, layer.Path, missing)
+	}
+	fmt.Println("\nA job replaces jobs of the same name from files listed before it.")
+	fmt.Println()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "JOB\tSOURCE\tOVERRIDES")
+	fmt.Fprintln(w, "---\t------\t---------")
+	for _, job := range jobs {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", job.Name, job.Source, strings.Join(job.Overrides, ", "))
+	}
+	return w.Flush()
 }
 
-func (c *CLI) removeContext(name string) error {
-	if _, exists := c.executor.config.Contexts[name]; !exists {
-		return fmt.Errorf("job '%s' not found", name)
+// validateConfig checks the given files, or all files of the
+// configuration, and reports every problem with its location. Jobs that
+// depend on unknown jobs and dependency cycles are only found when the
+// whole configuration is checked.
+func (c *CLI) validateConfig(files []string) error {
+	var layers []*configLayer
+	if len(files) == 0 {
+		var err error
+		if layers, err = configLayers(c.configPath); err != nil {
+			return err
+		}
+	}
+	for _, file := range files {
+		layers = append(layers, &configLayer{Path: file})
 	}
 
-	delete(c.executor.config.Contexts, name)
+	config := &Config{Contexts: make(map[string]Context), sources: make(map[string]*configLayer)}
+	var problems []*configProblem
+	checked := []string{}
+	complete := len(files) == 0
+	for _, layer := range layers {
+		err := layer.read()
+		if err == nil && !layer.Exists {
+			if len(files) > 0 {
+				problems = append(problems, &configProblem{File: layer.Path, Message: "file not found"})
+			}
+			continue
+		}
+		checked = append(checked, layer.Path)
+		if err != nil {
+			if layer.doc != nil {
+				problems = append(problems, layer.doc.validate()...)
+			} else {
+				problems = append(problems, asConfigProblem(layer.Path, err))
+			}
+			complete = false
+			continue
+		}
+		problems = append(problems, layer.doc.validate()...)
+		config.merge(layer)
+	}
+	if complete {
+		problems = append(problems, config.dependencyProblems()...)
+	}
 
-	if err := c.executor.config.save(); err != nil {
-		return err
+	if c.output != outputTable {
+		if problems == nil {
+			problems = []*configProblem{}
+		}
+		if err := writeStructured(os.Stdout, c.output, map[string]any{
+			"files":    checked,
+			"problems": problems,
+		}); err != nil {
+			return err
+		}
+	} else {
+		for _, p := range problems {
+			fmt.Println(p.Error())
+		}
+		switch {
+		case len(problems) > 0:
+			fmt.Printf("\n%d problem(s) in %d file(s) checked\n", len(problems), len(checked))
+		case len(checked) == 0:
+			fmt.Println("No configuration files found")
+		default:
+			fmt.Printf("No problems found in %s\n", strings.Join(checked, ", "))
+		}
 	}
 
-	fmt.Printf("Removed job: %s\n", name)
+	if len(problems) > 0 {
+		return &exitError{code: 1}
+	}
 	return nil
 }
 
@@ -199,10 +1836,7 @@ func (c *CLI) startTUI() error {
 }
 
 func (c *CLI) initConfig() error {
-	configPath, err := getConfigPath()
-	if err != nil {
-		return err
-	}
+	configPath := c.executor.config.userLayer().Path
 
 	if _, err := os.Stat(configPath); err == nil {
 		fmt.Printf("Configuration file already exists at: %s\n", configPath)
@@ -217,6 +1851,7 @@ func (c *CLI) initConfig() error {
 	}
 
 	exampleConfig := &Config{
+		Schema: configSchemaURL,
 		Theme: ColorTheme{
 			Title:       "205",
 			Selected:    "199", 
@@ -228,8 +1863,12 @@ func (c *CLI) initConfig() error {
 				Name:        "docker",
 				Label:       "Docker Services",
 				Description: "Start/stop Docker containers",
+				Tags:        []string{"infra"},
 				Commands: map[string]string{
-					"run": "docker-compose up -d && echo 'Docker services started'",
+					"run":    "docker-compose up -d && echo 'Docker services started'",
+					"stop":   "docker-compose down && echo 'Docker services stopped'",
+					"status": "docker-compose ps",
+					"logs":   "docker-compose logs --tail=100",
 				},
 				Variables: map[string]string{
 					"COMPOSE_FILE": "docker-compose.yml",
@@ -240,8 +1879,11 @@ func (c *CLI) initConfig() error {
 				Name:        "vpn",
 				Label:       "VPN Connection",
 				Description: "Connect to company VPN",
+				Tags:        []string{"network"},
 				Commands: map[string]string{
-					"run": "echo 'Connecting to VPN: ${VPN_SERVER}' && ping -c 1 ${VPN_SERVER}",
+					"run":    "echo 'Connecting to VPN: ${VPN_SERVER}' && ping -c 1 ${VPN_SERVER}",
+					"stop":   "echo 'Disconnecting from VPN: ${VPN_SERVER}'",
+					"status": "ping -c 1 -W 2 ${VPN_SERVER}",
 				},
 				Variables: map[string]string{
 					"VPN_SERVER": "vpn.company.com",
@@ -252,6 +1894,7 @@ func (c *CLI) initConfig() error {
 				Name:        "database",
 				Label:       "Database Tunnel",
 				Description: "SSH tunnel to database server",
+				Tags:        []string{"infra", "network"},
 				Commands: map[string]string{
 					"run": "echo 'Setting up SSH tunnel to ${DB_HOST}:${DB_PORT}' && nc -z ${DB_HOST} ${DB_PORT}",
 				},
@@ -265,6 +1908,7 @@ func (c *CLI) initConfig() error {
 				Name:        "monitoring",
 				Label:       "System Monitoring",
 				Description: "Enable system monitoring tools",
+				Tags:        []string{"infra"},
 				Commands: map[string]string{
 					"run": "echo 'Monitoring enabled: CPU, Memory, Disk' && ps aux | grep -E '(htop|top|iostat)' | head -3",
 				},
@@ -277,18 +1921,27 @@ func (c *CLI) initConfig() error {
 				Name:        "proxy",
 				Label:       "HTTP Proxy",
 				Description: "Route traffic through proxy server",
+				Tags:        []string{"network"},
 				Commands: map[string]string{
-					"run": "export http_proxy=${PROXY_URL} && export https_proxy=${PROXY_URL} && echo 'Proxy configured: ${PROXY_URL}'",
+					"run": "export http_proxy=\"$PROXY_URL\" https_proxy=\"$PROXY_URL\" no_proxy=\"$NO_PROXY\" && echo \"Proxy configured: $PROXY_URL\"",
 				},
 				Variables: map[string]string{
 					"PROXY_URL": "http://proxy.company.com:8080",
 					"NO_PROXY": "localhost,127.0.0.1,.local",
 				},
+				VariableMode: variableModeEnv,
 			},
 		},
 	}
 
-	if err := exampleConfig.save(); err != nil {
+	// init replaces the file as a whole, but still waits for writers
+	// that are in the middle of merging their changes into it.
+	unlock, err := lockFile(lockPath(configPath))
+	if err != nil {
+		return fmt.Errorf("failed to save configuration: %w", err)
+	}
+	defer unlock()
+	if err := exampleConfig.saveTo(configPath); err != nil {
 		return fmt.Errorf("failed to save configuration: %w", err)
 	}
 
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTestCLI returns a CLI on a configuration file with the given jobs
+// in a temporary directory, which is also the working directory so that
+// no project files are found.
+func newTestCLI(t *testing.T, jobs map[string]Context) (*CLI, string) {
+	t.Helper()
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	path := filepath.Join(dir, "config.json")
+	data, err := json.Marshal(Config{Contexts: jobs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	executor := NewExecutor(config, NewHistory(getHistoryDir(path)), NewStateStore(getStatePath(path)), NewServices(getServicesDir(path), path))
+	return NewCLI(executor, path), path
+}
+
+// loadTestJob reads a job back from the configuration file.
+func loadTestJob(t *testing.T, path, name string) Context {
+	t.Helper()
+	config, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config.Contexts[name]
+}
+
+func TestEditStopsAtFirstInvalidFlag(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{"empty label before timeout", []string{"-label", "", "-timeout", "5m"}, "label must not be empty"},
+		{"bad timeout before var-mode", []string{"-timeout", "soon", "-var-mode", "env"}, "invalid timeout"},
+		{"bad var-mode before timeout", []string{"-var-mode", "inline", "-timeout", "5m"}, "variable mode"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := Context{Name: "web", Label: "Web", Commands: map[string]string{"run": "true"}}
+			cli, path := newTestCLI(t, map[string]Context{"web": job})
+
+			err := cli.editContext("web", tt.args)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("edit %v = %v, want an error containing %q", tt.args, err, tt.wantErr)
+			}
+			if got := loadTestJob(t, path, "web"); !reflect.DeepEqual(got, job) {
+				t.Errorf("edit %v saved %+v despite the error", tt.args, got)
+			}
+		})
+	}
+}
+
+func TestEditAppliesSeveralFlags(t *testing.T) {
+	cli, path := newTestCLI(t, map[string]Context{
+		"web": {Name: "web", Label: "Web", Commands: map[string]string{"run": "true"}},
+	})
+	if err := cli.editContext("web", []string{"-label", "Website", "-timeout", "5m", "-var-mode", "env"}); err != nil {
+		t.Fatal(err)
+	}
+	job := loadTestJob(t, path, "web")
+	if job.Label != "Website" || job.Timeout != "5m" || job.VariableMode != variableModeEnv {
+		t.Errorf("edit saved label %q, timeout %q, var-mode %q", job.Label, job.Timeout, job.VariableMode)
+	}
+}
+
+// TestEditSavesNestedFields edits the maps, slices and pointers of a
+// job, which the loaded configuration must not share with the layer
+// file it compares against when saving.
+func TestEditSavesNestedFields(t *testing.T) {
+	tests := []struct {
+		name  string
+		args  []string
+		check func(job Context) bool
+	}{
+		{"add command", []string{"-cmd", "stop=pkill web"}, func(job Context) bool { return job.Commands["stop"] == "pkill web" }},
+		{"remove command", []string{"-rm-cmd", "status"}, func(job Context) bool { _, exists := job.Commands["status"]; return !exists }},
+		{"add variable", []string{"-var", "B=2"}, func(job Context) bool { return job.Variables["B"] == "2" && job.Variables["A"] == "1" }},
+		{"remove variable", []string{"-rm-var", "A"}, func(job Context) bool { _, exists := job.Variables["A"]; return !exists }},
+		{"add secret", []string{"-secret", "TOKEN=env:API_TOKEN"}, func(job Context) bool { return job.Secrets["TOKEN"] == Secret{Env: "API_TOKEN"} }},
+		{"remove secret", []string{"-rm-secret", "KEY"}, func(job Context) bool { return len(job.Secrets) == 0 }},
+		{"remove tag", []string{"-rm-tag", "web"}, func(job Context) bool { return len(job.Tags) == 1 && job.Tags[0] == "prod" }},
+		{"restart policy", []string{"-restart", restartAlways}, func(job Context) bool { return job.Service.Restart == restartAlways }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cli, path := newTestCLI(t, map[string]Context{
+				"web": {
+					Name: "web", Label: "Web", Tags: []string{"web", "prod"},
+					Commands:  map[string]string{"run": "true", "status": "true"},
+					Variables: map[string]string{"A": "1"},
+					Secrets:   map[string]Secret{"KEY": {File: "/tmp/key"}},
+					Service:   &Service{Restart: restartNo},
+				},
+			})
+			if err := cli.editContext("web", tt.args); err != nil {
+				t.Fatal(err)
+			}
+			if job := loadTestJob(t, path, "web"); !tt.check(job) {
+				t.Errorf("edit %v saved %+v", tt.args, job)
+			}
+		})
+	}
+}
+
+func TestRemoveUncoversShadowedJob(t *testing.T) {
+	cli, path := newTestCLI(t, map[string]Context{
+		"web": {Name: "web", Label: "User web", Commands: map[string]string{"run": "true"}},
+		"db":  {Name: "db", Label: "DB", Commands: map[string]string{"run": "true"}},
+	})
+	project := `{"contexts": {"web": {"name": "web", "label": "Project web", "commands": {"run": "true"}}}}`
+	projectPath := filepath.Join(filepath.Dir(path), projectConfigName+".json")
+	if err := os.WriteFile(projectPath, []byte(project), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli.executor.config = config
+
+	if err := cli.removeContext("web"); err != nil {
+		t.Fatal(err)
+	}
+	web, exists := config.Contexts["web"]
+	if !exists || web.Label != "User web" || config.source("web") != config.userLayer() {
+		t.Fatalf("after removing the project job, web = %+v from %s, want the user job", web, config.source("web").Path)
+	}
+
+	// The uncovered job is edited in its own file, and removing it again
+	// leaves no job behind.
+	if err := cli.editContext("web", []string{"-label", "Web"}); err != nil {
+		t.Fatal(err)
+	}
+	if job := loadTestJob(t, path, "web"); job.Label != "Web" {
+		t.Errorf("user web = %+v, want the edit saved", job)
+	}
+	if err := cli.removeContext("web"); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := config.Contexts["web"]; exists {
+		t.Error("web is still defined after removing it from both files")
+	}
+	if _, exists := config.Contexts["db"]; !exists {
+		t.Error("db was removed too")
+	}
+}
+
+func TestRunUsageErrorsExitInternal(t *testing.T) {
+	tests := []struct {
+		command string
+		args    []string
+	}{
+		{"run", []string{"-bogus", "web"}},
+		{"run", []string{"web", "-parallel", "many"}},
+		{"run", []string{"-parallel", "0", "web"}},
+		{"run-all", []string{"web"}},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(append([]string{tt.command}, tt.args...), " "), func(t *testing.T) {
+			cli, _ := newTestCLI(t, map[string]Context{
+				"web": {Name: "web", Label: "Web", Commands: map[string]string{"run": "true"}},
+			})
+			var exitErr *exitError
+			if err := cli.runCommand(tt.command, tt.args); !errors.As(err, &exitErr) || exitErr.code != exitInternal {
+				t.Errorf("error = %v, want exit status %d", err, exitInternal)
+			}
+		})
+	}
+}
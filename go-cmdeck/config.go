@@ -1,40 +1,232 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
 	"time"
 )
 
 type ExecutionResult struct {
-	Timestamp   time.Time `json:"timestamp"`
-	Success     bool      `json:"success"`
-	ExitCode    int       `json:"exit_code"`
-	Output      string    `json:"output,omitempty"`
+	RunID     string             `json:"run_id,omitempty"`
+	Job       string             `json:"job,omitempty"`
+	Action    string             `json:"action,omitempty"`
+	Timestamp time.Time          `json:"timestamp"`
+	EndTime   time.Time          `json:"end_time,omitempty"`
+	Duration  time.Duration      `json:"-"`
+	Success   bool               `json:"success"`
+	Status    string             `json:"status,omitempty"`
+	ExitCode  int                `json:"exit_code"`
+	Output    string             `json:"output,omitempty"`
+	Stdout    string             `json:"stdout,omitempty"`
+	Stderr    string             `json:"stderr,omitempty"`
+	Steps     []*ExecutionResult `json:"steps,omitempty"`
+	Trigger   string             `json:"trigger,omitempty"`
+	Attempts  []Attempt          `json:"attempts,omitempty"`
 }
 
+// resultJSON is ExecutionResult without its JSON methods.
+type resultJSON ExecutionResult
+
+// MarshalJSON writes the duration as whole milliseconds in duration_ms;
+// nanoseconds are hard to read and overflow the integers of JavaScript.
+func (r ExecutionResult) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		resultJSON
+		DurationMS int64 `json:"duration_ms,omitempty"`
+	}{resultJSON(r), r.Duration.Milliseconds()})
+}
+
+// UnmarshalJSON reads duration_ms, or the duration in nanoseconds of
+// results recorded by older versions.
+func (r *ExecutionResult) UnmarshalJSON(data []byte) error {
+	var decoded struct {
+		resultJSON
+		DurationMS *int64        `json:"duration_ms"`
+		Duration   time.Duration `json:"duration"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*r = ExecutionResult(decoded.resultJSON)
+	r.Duration = decoded.Duration
+	if decoded.DurationMS != nil {
+		r.Duration = time.Duration(*decoded.DurationMS) * time.Millisecond
+	}
+	return nil
+}
+
+const (
+	StatusSuccess   = "success"
+	StatusFailed    = "failed"
+	StatusTimeout   = "timeout"
+	StatusCancelled = "cancelled"
+)
+
+// status returns the run status. Results recorded before Status existed
+// fall back to Success.
+func (r *ExecutionResult) status() string {
+	if r.Status != "" {
+		return r.Status
+	}
+	if r.Success {
+		return StatusSuccess
+	}
+	return StatusFailed
+}
+
+// statusLabel returns a human-readable status with an icon.
+func (r *ExecutionResult) statusLabel() string {
+	switch r.status() {
+	case StatusSuccess:
+		return "✓ Success"
+	case StatusTimeout:
+		return "⏱ Timed out"
+	case StatusCancelled:
+		return "⊘ Cancelled"
+	}
+	return "✗ Failed"
+}
+
+// Context is a job. Commands maps action names (run, start, stop,
+// status, ...) to shell commands; "run" is the default action.
+// Tags group jobs, for example by project or environment.
+// Timeout (e.g. "30s", "5m") limits how long a single action may run.
+// DependsOn lists jobs whose run action has to succeed before this job's
+// run action starts.
+// Params are asked for when the job is run and override Variables.
+// VariableMode selects how Variables reach the commands: "splice"
+// (default) or "env". Secrets are variables read from a file, an
+// environment variable or a command when the job runs. Service makes
+// the run action a long-running service kept up by a supervisor, and
+// Check tells whether what the job sets up is still up. Schedule is a
+// cron expression at which the scheduler runs the job; Overlap and
+// CatchUp decide what happens to runs that come while the job is still
+// running or were missed while the scheduler was down. Retry runs
+// failed actions again, and Notify tells about finished runs in
+// addition to the global notifications.
+// LastResult is the most recent run of any action and ActionResults
+// keeps the most recent run of each action; both are kept in the state
+// file (see State), not in the configuration.
 type Context struct {
-	Name         string            `json:"name"`
-	Label        string            `json:"label"`
-	Description  string            `json:"description,omitempty"`
-	Commands     map[string]string `json:"commands"`
-	Variables    map[string]string `json:"variables,omitempty"`
-	LastResult   *ExecutionResult  `json:"last_result,omitempty"`
+	Name          string                      `json:"name"`
+	Label         string                      `json:"label"`
+	Description   string                      `json:"description,omitempty"`
+	Tags          []string                    `json:"tags,omitempty"`
+	Commands      map[string]string           `json:"commands"`
+	Variables     map[string]string           `json:"variables,omitempty"`
+	VariableMode  string                      `json:"variable_mode,omitempty"`
+	Secrets       map[string]Secret           `json:"secrets,omitempty"`
+	Timeout       string                      `json:"timeout,omitempty"`
+	DependsOn     []string                    `json:"depends_on,omitempty"`
+	Params        []Param                     `json:"params,omitempty"`
+	Service       *Service                    `json:"service,omitempty"`
+	Check         *HealthCheck                `json:"check,omitempty"`
+	Schedule      string                      `json:"schedule,omitempty"`
+	Overlap       string                      `json:"overlap,omitempty"`
+	CatchUp       string                      `json:"catch_up,omitempty"`
+	Retry         *Retry                      `json:"retry,omitempty"`
+	Notify        []Notification              `json:"notify,omitempty"`
+	LastResult    *ExecutionResult            `json:"last_result,omitempty"`
+	ActionResults map[string]*ExecutionResult `json:"action_results,omitempty"`
+}
+
+const defaultAction = "run"
+
+// clone returns a copy of the job that shares no maps, slices or
+// pointers with it, so that changing the copy leaves the layer file it
+// came from as it was read.
+func (c Context) clone() Context {
+	c.Tags = slices.Clone(c.Tags)
+	c.Commands = maps.Clone(c.Commands)
+	c.Variables = maps.Clone(c.Variables)
+	c.Secrets = maps.Clone(c.Secrets)
+	c.DependsOn = slices.Clone(c.DependsOn)
+	c.Params = slices.Clone(c.Params)
+	for i, p := range c.Params {
+		c.Params[i].Allowed = slices.Clone(p.Allowed)
+	}
+	c.Service = clonePointer(c.Service)
+	c.Check = clonePointer(c.Check)
+	if c.Retry != nil {
+		retry := *c.Retry
+		retry.ExitCodes = slices.Clone(retry.ExitCodes)
+		c.Retry = &retry
+	}
+	c.Notify = slices.Clone(c.Notify)
+	return c
+}
+
+func clonePointer[T any](p *T) *T {
+	if p == nil {
+		return nil
+	}
+	copied := *p
+	return &copied
+}
+
+// actions returns the job's action names with the default action first
+// and the rest in alphabetical order.
+func (c Context) actions() []string {
+	var names []string
+	for name := range c.Commands {
+		if name != defaultAction {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	if _, exists := c.Commands[defaultAction]; exists {
+		names = append([]string{defaultAction}, names...)
+	}
+	return names
 }
 
 type ColorTheme struct {
-	Title        string `json:"title"`
-	Selected     string `json:"selected"`
-	Border       string `json:"border"`
-	OutputTitle  string `json:"output_title"`
+	Title       string `json:"title,omitempty"`
+	Selected    string `json:"selected,omitempty"`
+	Border      string `json:"border,omitempty"`
+	OutputTitle string `json:"output_title,omitempty"`
+}
+
+// HistoryConfig is the retention policy for the execution history.
+// MaxRuns keeps at most that many runs per job (0 means the default,
+// a negative value keeps everything) and MaxAge drops runs older than
+// the given duration such as "72h" or "30d".
+type HistoryConfig struct {
+	MaxRuns int    `json:"max_runs,omitempty"`
+	MaxAge  string `json:"max_age,omitempty"`
 }
 
+// Config is the configuration, either as stored in one file or merged
+// from several files. DefaultTimeout applies to jobs that do not set
+// their own Timeout; an empty value means no timeout. MaxParallel limits
+// how many selected jobs the TUI runs at once. Notify tells about the
+// finished runs of all jobs. Schema is the JSON Schema reference of a
+// file, kept for editors.
 type Config struct {
-	Contexts map[string]Context `json:"contexts"`
-	Theme    ColorTheme         `json:"theme"`
+	Schema         string             `json:"$schema,omitempty"`
+	Contexts       map[string]Context `json:"contexts"`
+	Theme          ColorTheme         `json:"theme"`
+	History        HistoryConfig      `json:"history,omitempty"`
+	DefaultTimeout string             `json:"default_timeout,omitempty"`
+	MaxParallel    int                `json:"max_parallel,omitempty"`
+	Notify         []Notification     `json:"notify,omitempty"`
+
+	// layers are the files the configuration was merged from, lowest
+	// priority first, and sources maps each job to its layer.
+	layers  []*configLayer
+	sources map[string]*configLayer
 }
 
+const defaultHistoryMaxRuns = 100
+
 func getConfigPath() (string, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
@@ -43,60 +235,204 @@ func getConfigPath() (string, error) {
 	return filepath.Join(homeDir, ".config", "go-cmdeck", "config.json"), nil
 }
 
-func loadConfig() (*Config, error) {
-	configPath, err := getConfigPath()
-	if err != nil {
-		return nil, err
-	}
-
-	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		return &Config{
-			Contexts: make(map[string]Context),
-			Theme: ColorTheme{
-				Title:       "205",
-				Selected:    "199",
-				Border:      "168",
-				OutputTitle: "212",
-			},
-		}, nil
+// getDataDir returns the directory of the history, state, services and
+// scheduler PID file that belong to the configuration file at
+// configPath (the resolved user layer). A file named config.* keeps them
+// in its own directory, like ~/.config/go-cmdeck/config.json; any other
+// file gets a <name>.cmdeck.d directory next to it, so that configuration
+// files kept side by side do not share their results.
+func getDataDir(configPath string) string {
+	dir, name := filepath.Split(configPath)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	if name == "config" {
+		return filepath.Clean(dir)
 	}
+	return filepath.Join(dir, name+".cmdeck.d")
+}
 
-	data, err := os.ReadFile(configPath)
+// loadConfig merges the user configuration (see userConfigLayer) with
+// the project files found from the working directory upwards. Later
+// files take precedence.
+func loadConfig(flagPath string) (*Config, error) {
+	layers, err := configLayers(flagPath)
 	if err != nil {
 		return nil, err
 	}
 
-	var config Config
-	if err := json.Unmarshal(data, &config); err != nil {
-		return nil, err
-	}
-
-	if config.Theme.Title == "" {
-		config.Theme = ColorTheme{
+	config := &Config{
+		Contexts: make(map[string]Context),
+		Theme: ColorTheme{
 			Title:       "205",
 			Selected:    "199",
-			Border:      "168", 
+			Border:      "168",
 			OutputTitle: "212",
+		},
+		sources: make(map[string]*configLayer),
+	}
+	for _, layer := range layers {
+		if err := layer.read(); err != nil {
+			return nil, err
 		}
+		config.merge(layer)
 	}
 
-	return &config, nil
+	return config, nil
 }
 
+// save writes every changed layer back to its file. Each file is locked
+// and read again first: jobs and settings this process did not change
+// are taken from the file, so that changes other go-cmdeck processes
+// made meanwhile are kept, and show up in this configuration too. A job
+// changed both here and in the file is a conflict.
 func (c *Config) save() error {
-	configPath, err := getConfigPath()
+	for _, layer := range c.layers {
+		if err := c.saveLayer(layer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Config) saveLayer(layer *configLayer) error {
+	contents := c.layerContents(layer)
+	if sameJSON(withoutResults(layer.file), contents) {
+		return nil
+	}
+
+	unlock, err := lockFile(lockPath(layer.Path))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	current := &configLayer{Path: layer.Path, Kind: layer.Kind}
+	if err := current.read(); err != nil {
+		return err
+	}
+	merged, err := mergeChanges(withoutResults(layer.file), contents, withoutResults(current.file))
 	if err != nil {
+		return fmt.Errorf("%s: %w", layer.Path, err)
+	}
+	if err := merged.saveTo(layer.Path); err != nil {
 		return err
 	}
 
+	for name, job := range merged.Contexts {
+		if source, exists := c.sources[name]; !exists || c.priority(source) <= c.priority(layer) {
+			job = job.clone()
+			if old, exists := c.Contexts[name]; exists {
+				job.LastResult, job.ActionResults = old.LastResult, old.ActionResults
+			}
+			c.Contexts[name] = job
+			c.sources[name] = layer
+		}
+	}
+	for name, source := range c.sources {
+		if _, exists := merged.Contexts[name]; !exists && source == layer {
+			delete(c.Contexts, name)
+			delete(c.sources, name)
+		}
+	}
+	layer.file = merged
+	layer.Exists = true
+	return nil
+}
+
+// priority returns the position of the layer among the layers; later
+// layers take precedence.
+func (c *Config) priority(layer *configLayer) int {
+	for i, l := range c.layers {
+		if l == layer {
+			return i
+		}
+	}
+	return -1
+}
+
+// mergeChanges applies the changes from base to ours on top of current,
+// the file as it is now.
+func mergeChanges(base, ours, current *Config) (*Config, error) {
+	merged := *current
+	merged.Contexts = make(map[string]Context, len(current.Contexts))
+	for name, job := range current.Contexts {
+		merged.Contexts[name] = job
+	}
+
+	names := make(map[string]bool)
+	for name := range base.Contexts {
+		names[name] = true
+	}
+	for name := range ours.Contexts {
+		names[name] = true
+	}
+	for name := range names {
+		baseJob, inBase := base.Contexts[name]
+		ourJob, inOurs := ours.Contexts[name]
+		currentJob, inCurrent := current.Contexts[name]
+		if inBase == inOurs && sameJSON(baseJob, ourJob) {
+			continue
+		}
+		if inBase != inCurrent || !sameJSON(baseJob, currentJob) {
+			if inOurs == inCurrent && sameJSON(ourJob, currentJob) {
+				continue
+			}
+			return nil, fmt.Errorf("job '%s' was changed by another process meanwhile; run the command again", name)
+		}
+		if inOurs {
+			merged.Contexts[name] = ourJob
+		} else {
+			delete(merged.Contexts, name)
+		}
+	}
+
+	if !sameJSON(base.settings(), ours.settings()) {
+		merged.Schema = ours.Schema
+		merged.Theme = ours.Theme
+		merged.History = ours.History
+		merged.DefaultTimeout = ours.DefaultTimeout
+		merged.MaxParallel = ours.MaxParallel
+		merged.Notify = ours.Notify
+	}
+	return &merged, nil
+}
+
+// settings returns everything of a configuration file except its jobs.
+func (c *Config) settings() Config {
+	settings := *c
+	settings.Contexts = nil
+	return settings
+}
+
+// withoutResults returns a copy of the configuration without run
+// results, which belong to the state file.
+func withoutResults(c *Config) *Config {
+	stripped := *c
+	stripped.Contexts = make(map[string]Context, len(c.Contexts))
+	for name, job := range c.Contexts {
+		job.LastResult = nil
+		job.ActionResults = nil
+		stripped.Contexts[name] = job
+	}
+	return &stripped
+}
+
+func sameJSON(a, b any) bool {
+	dataA, errA := json.Marshal(a)
+	dataB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(dataA, dataB)
+}
+
+// saveTo writes the configuration to a single file, in the format given
+// by its extension.
+func (c *Config) saveTo(configPath string) error {
 	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
 		return err
 	}
 
-	data, err := json.MarshalIndent(c, "", "  ")
+	data, err := encodeConfig(c, configFormat(configPath))
 	if err != nil {
 		return err
 	}
 
-	return os.WriteFile(configPath, data, 0644)
-}
\ No newline at end of file
+	return writeFileAtomic(configPath, data, 0644)
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testConfig returns a configuration with a job per entry, labelled with
+// the value.
+func testConfig(labels map[string]string) *Config {
+	config := &Config{Contexts: make(map[string]Context)}
+	for name, label := range labels {
+		config.Contexts[name] = Context{Name: name, Label: label, Commands: map[string]string{"run": "true"}}
+	}
+	return config
+}
+
+func TestMergeChanges(t *testing.T) {
+	tests := []struct {
+		name                string
+		base, ours, current map[string]string
+		want                map[string]string
+		wantErr             string
+	}{
+		{
+			name: "unchanged",
+			base: map[string]string{"a": "A"}, ours: map[string]string{"a": "A"}, current: map[string]string{"a": "A"},
+			want: map[string]string{"a": "A"},
+		},
+		{
+			name: "changed only by us",
+			base: map[string]string{"a": "A", "b": "B"}, ours: map[string]string{"a": "ours", "b": "B"}, current: map[string]string{"a": "A", "b": "B"},
+			want: map[string]string{"a": "ours", "b": "B"},
+		},
+		{
+			name: "changed only by the other process",
+			base: map[string]string{"a": "A"}, ours: map[string]string{"a": "A"}, current: map[string]string{"a": "theirs"},
+			want: map[string]string{"a": "theirs"},
+		},
+		{
+			name: "different jobs changed by both",
+			base: map[string]string{"a": "A", "b": "B"}, ours: map[string]string{"a": "ours", "b": "B"}, current: map[string]string{"a": "A", "b": "theirs"},
+			want: map[string]string{"a": "ours", "b": "theirs"},
+		},
+		{
+			name: "changed identically by both",
+			base: map[string]string{"a": "A"}, ours: map[string]string{"a": "same"}, current: map[string]string{"a": "same"},
+			want: map[string]string{"a": "same"},
+		},
+		{
+			name: "conflicting change",
+			base: map[string]string{"a": "A"}, ours: map[string]string{"a": "ours"}, current: map[string]string{"a": "theirs"},
+			wantErr: "job 'a' was changed by another process",
+		},
+		{
+			name: "added by both under different names",
+			base: map[string]string{}, ours: map[string]string{"a": "A"}, current: map[string]string{"b": "B"},
+			want: map[string]string{"a": "A", "b": "B"},
+		},
+		{
+			name: "added by both under the same name",
+			base: map[string]string{}, ours: map[string]string{"a": "ours"}, current: map[string]string{"a": "theirs"},
+			wantErr: "job 'a' was changed by another process",
+		},
+		{
+			name: "deleted by us",
+			base: map[string]string{"a": "A", "b": "B"}, ours: map[string]string{"b": "B"}, current: map[string]string{"a": "A", "b": "B"},
+			want: map[string]string{"b": "B"},
+		},
+		{
+			name: "deleted by both",
+			base: map[string]string{"a": "A"}, ours: map[string]string{}, current: map[string]string{},
+			want: map[string]string{},
+		},
+		{
+			name: "deleted by us, changed by the other process",
+			base: map[string]string{"a": "A"}, ours: map[string]string{}, current: map[string]string{"a": "theirs"},
+			wantErr: "job 'a' was changed by another process",
+		},
+		{
+			name: "changed by us, deleted by the other process",
+			base: map[string]string{"a": "A"}, ours: map[string]string{"a": "ours"}, current: map[string]string{},
+			wantErr: "job 'a' was changed by another process",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, err := mergeChanges(testConfig(tt.base), testConfig(tt.ours), testConfig(tt.current))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := testConfig(tt.want); !reflect.DeepEqual(merged.Contexts, want.Contexts) {
+				t.Errorf("merged jobs = %v, want %v", merged.Contexts, want.Contexts)
+			}
+		})
+	}
+}
+
+func TestMergeChangesSettings(t *testing.T) {
+	base := testConfig(map[string]string{"a": "A"})
+	ours := testConfig(map[string]string{"a": "A"})
+	ours.MaxParallel = 4
+	current := testConfig(map[string]string{"a": "theirs"})
+	current.DefaultTimeout = "1m"
+
+	merged, err := mergeChanges(base, ours, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.MaxParallel != 4 || merged.Contexts["a"].Label != "theirs" {
+		t.Errorf("merged max_parallel %d and label %q, want our settings and their job", merged.MaxParallel, merged.Contexts["a"].Label)
+	}
+
+	unchanged, err := mergeChanges(base, testConfig(map[string]string{"a": "A"}), current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unchanged.DefaultTimeout != "1m" {
+		t.Errorf("merged default_timeout %q, want the other process's 1m", unchanged.DefaultTimeout)
+	}
+}
+
+func TestMergeChangesMapFields(t *testing.T) {
+	job := func(name, label string, vars map[string]string) Context {
+		return Context{Name: name, Label: label, Commands: map[string]string{"run": "true"}, Variables: vars}
+	}
+	config := func(jobs ...Context) *Config {
+		c := &Config{Contexts: make(map[string]Context)}
+		for _, j := range jobs {
+			c.Contexts[j.Name] = j
+		}
+		return c
+	}
+	base := config(job("a", "A", map[string]string{"X": "1"}), job("b", "B", nil))
+	tests := []struct {
+		name          string
+		ours, current *Config
+		want          *Config
+		wantErr       bool
+	}{
+		{
+			name:    "label and variable changed by us",
+			ours:    config(job("a", "ours", map[string]string{"X": "1", "Y": "2"}), job("b", "B", nil)),
+			current: base,
+			want:    config(job("a", "ours", map[string]string{"X": "1", "Y": "2"}), job("b", "B", nil)),
+		},
+		{
+			name:    "variable removed by us",
+			ours:    config(job("a", "A", map[string]string{}), job("b", "B", nil)),
+			current: base,
+			want:    config(job("a", "A", map[string]string{}), job("b", "B", nil)),
+		},
+		{
+			name:    "variable of another job changed meanwhile",
+			ours:    config(job("a", "ours", map[string]string{"X": "2"}), job("b", "B", nil)),
+			current: config(job("a", "A", map[string]string{"X": "1"}), job("b", "B", map[string]string{"Z": "theirs"})),
+			want:    config(job("a", "ours", map[string]string{"X": "2"}), job("b", "B", map[string]string{"Z": "theirs"})),
+		},
+		{
+			name:    "variable of the same job changed meanwhile",
+			ours:    config(job("a", "ours", map[string]string{"X": "2"}), job("b", "B", nil)),
+			current: config(job("a", "A", map[string]string{"X": "theirs"}), job("b", "B", nil)),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, err := mergeChanges(base, tt.ours, tt.current)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("merged %+v, want a conflict", merged.Contexts)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(merged.Contexts, tt.want.Contexts) {
+				t.Errorf("merged jobs = %+v, want %+v", merged.Contexts, tt.want.Contexts)
+			}
+		})
+	}
+}
+
+func TestEditMergesWithConcurrentWriter(t *testing.T) {
+	jobs := map[string]Context{
+		"web": {Name: "web", Label: "Web", Commands: map[string]string{"run": "true"}, Variables: map[string]string{"A": "1"}},
+		"db":  {Name: "db", Label: "DB", Commands: map[string]string{"run": "true"}, Variables: map[string]string{"A": "1"}},
+	}
+	tests := []struct {
+		name    string
+		other   string
+		wantErr bool
+	}{
+		{"no other writer", "", false},
+		{"another job changed meanwhile", "db", false},
+		{"the same job changed meanwhile", "web", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cli, path := newTestCLI(t, jobs)
+			if tt.other != "" {
+				// Another process changes a variable after this one has
+				// read the file.
+				other, err := loadConfig(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				job := other.Contexts[tt.other]
+				job.Variables["A"] = "theirs"
+				other.Contexts[tt.other] = job
+				if err := other.saveLayer(other.userLayer()); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := cli.editContext("web", []string{"-label", "Website", "-var", "B=2"})
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "changed by another process") {
+					t.Fatalf("edit = %v, want a conflict", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			web, db := loadTestJob(t, path, "web"), loadTestJob(t, path, "db")
+			if web.Label != "Website" || web.Variables["A"] != "1" || web.Variables["B"] != "2" {
+				t.Errorf("web = %+v, want the edit saved", web)
+			}
+			if want := map[string]string{"A": "1"}; tt.other == "db" {
+				want["A"] = "theirs"
+				if !reflect.DeepEqual(db.Variables, want) {
+					t.Errorf("db variables = %v, want the other process's %v", db.Variables, want)
+				}
+			}
+		})
+	}
+}
+
+func TestGetDataDir(t *testing.T) {
+	tests := []struct {
+		configPath string
+		want       string
+	}{
+		{"/home/me/.config/go-cmdeck/config.json", "/home/me/.config/go-cmdeck"},
+		{"/home/me/.config/go-cmdeck/config.yaml", "/home/me/.config/go-cmdeck"},
+		{"/srv/deck/config.toml", "/srv/deck"},
+		{"/srv/decks/work.json", "/srv/decks/work.cmdeck.d"},
+		{"/srv/decks/home.yaml", "/srv/decks/home.cmdeck.d"},
+		{"/srv/decks/deck", "/srv/decks/deck.cmdeck.d"},
+	}
+	for _, tt := range tests {
+		if got := getDataDir(tt.configPath); got != tt.want {
+			t.Errorf("getDataDir(%q) = %q, want %q", tt.configPath, got, tt.want)
+		}
+	}
+
+	// Every file of one deck is kept apart from those of another.
+	work, home := "/srv/decks/work.json", "/srv/decks/home.json"
+	for _, paths := range [][2]string{
+		{getHistoryDir(work), getHistoryDir(home)},
+		{getStatePath(work), getStatePath(home)},
+		{getServicesDir(work), getServicesDir(home)},
+		{getSchedulerPath(work), getSchedulerPath(home)},
+	} {
+		if paths[0] == paths[1] {
+			t.Errorf("both decks use %s", paths[0])
+		}
+	}
+}
@@ -0,0 +1,534 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Configuration file formats, chosen by the file extension.
+const (
+	formatJSON = "json"
+	formatYAML = "yaml"
+	formatTOML = "toml"
+)
+
+// configExtensions are the extensions looked for when a configuration
+// file is found by name, in order of preference.
+var configExtensions = []string{".json", ".yaml", ".yml", ".toml"}
+
+func configFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return formatYAML
+	case ".toml":
+		return formatTOML
+	}
+	return formatJSON
+}
+
+// configDocument is a configuration file in its generic form, with the
+// values as decoded from JSON whatever the file format. lines maps JSON
+// pointers such as /contexts/build/commands to the line of their key.
+type configDocument struct {
+	path       string
+	data       any
+	lines      map[string]int
+	duplicates []duplicateKey
+}
+
+// duplicateKey is a key that appears more than once in an object. The
+// last value wins.
+type duplicateKey struct {
+	pointer string
+	line    int
+	first   int
+}
+
+// parseConfigDocument parses a configuration file in the format given by
+// its extension. Syntax errors are returned as a *configProblem.
+func parseConfigDocument(path string, data []byte) (*configDocument, error) {
+	doc := &configDocument{path: path, lines: make(map[string]int)}
+
+	var err error
+	switch configFormat(path) {
+	case formatYAML:
+		err = doc.parseYAML(data)
+	case formatTOML:
+		err = doc.parseTOML(data)
+	default:
+		err = doc.parseJSON(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Normalize scalars to what encoding/json produces, so that all
+	// formats validate and decode the same way.
+	normalized, err := json.Marshal(doc.data)
+	if err != nil {
+		return nil, &configProblem{File: path, Message: err.Error()}
+	}
+	dec := json.NewDecoder(bytes.NewReader(normalized))
+	dec.UseNumber()
+	if err := dec.Decode(&doc.data); err != nil {
+		return nil, &configProblem{File: path, Message: err.Error()}
+	}
+	return doc, nil
+}
+
+// addKey records the line of an object key and reports it if the key
+// was already seen. Keys inside a duplicate are not reported again.
+func (d *configDocument) addKey(pointer string, line int) {
+	first, exists := d.lines[pointer]
+	if !exists {
+		d.lines[pointer] = line
+		return
+	}
+	for _, dup := range d.duplicates {
+		if strings.HasPrefix(pointer, dup.pointer+"/") {
+			return
+		}
+	}
+	d.duplicates = append(d.duplicates, duplicateKey{pointer: pointer, line: line, first: first})
+}
+
+// line returns the line of the value at pointer, or of its closest
+// parent with a known line. It is 0 if nothing is known.
+func (d *configDocument) line(pointer string) int {
+	for {
+		if line, exists := d.lines[pointer]; exists {
+			return line
+		}
+		i := strings.LastIndexByte(pointer, '/')
+		if i < 0 {
+			return 0
+		}
+		pointer = pointer[:i]
+	}
+}
+
+// configProblem is a mistake in a configuration file. Line is 0 when
+// the location is unknown.
+type configProblem struct {
+	File    string `json:"file"`
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
+func (p *configProblem) Error() string {
+	if p.Line == 0 {
+		return p.File + ": " + p.Message
+	}
+	return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Message)
+}
+
+// problemf returns a problem located at the value at pointer.
+func (d *configDocument) problemf(pointer string, format string, args ...any) *configProblem {
+	return &configProblem{File: d.path, Line: d.line(pointer), Message: fmt.Sprintf(format, args...)}
+}
+
+// asConfigProblem returns err as a problem of the file at path.
+func asConfigProblem(path string, err error) *configProblem {
+	var p *configProblem
+	if errors.As(err, &p) {
+		return p
+	}
+	return &configProblem{File: path, Message: err.Error()}
+}
+
+// jsonPointer builds a JSON pointer from its unescaped tokens.
+func jsonPointer(tokens ...string) string {
+	var b strings.Builder
+	for _, token := range tokens {
+		b.WriteByte('/')
+		b.WriteString(strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1"))
+	}
+	return b.String()
+}
+
+// decode converts the document to a Config. Type errors are returned as
+// a *configProblem.
+func (d *configDocument) decode() (*Config, error) {
+	data, err := json.Marshal(d.data)
+	if err != nil {
+		return nil, err
+	}
+
+	var file Config
+	if err := json.Unmarshal(data, &file); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			return nil, d.problemf(jsonPointer(strings.Split(typeErr.Field, ".")...),
+				"%s: got %s, want %s", typeErr.Field, typeErr.Value, jsonTypeName(typeErr.Type))
+		}
+		return nil, &configProblem{File: d.path, Message: err.Error()}
+	}
+	if file.Contexts == nil {
+		file.Contexts = make(map[string]Context)
+	}
+	return &file, nil
+}
+
+// jsonTypeName returns the JSON name of the values a Go type decodes
+// from.
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Struct, reflect.Map:
+		return "object"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.String:
+		return "string"
+	case reflect.Pointer:
+		return jsonTypeName(t.Elem())
+	}
+	return "number"
+}
+
+func (d *configDocument) parseJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	// lineAt returns the line of the first token at or after offset.
+	lineAt := func(offset int64) int {
+		for offset < int64(len(data)) && strings.IndexByte(" \t\r\n,", data[offset]) >= 0 {
+			offset++
+		}
+		return bytes.Count(data[:offset], []byte("\n")) + 1
+	}
+
+	var value func(pointer string) (any, error)
+	value = func(pointer string) (any, error) {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch token {
+		case json.Delim('{'):
+			obj := make(map[string]any)
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				child := pointer + jsonPointer(key.(string))
+				d.addKey(child, lineAt(dec.InputOffset()-1))
+				if obj[key.(string)], err = value(child); err != nil {
+					return nil, err
+				}
+			}
+			_, err := dec.Token()
+			return obj, err
+		case json.Delim('['):
+			arr := []any{}
+			for i := 0; dec.More(); i++ {
+				child := pointer + jsonPointer(strconv.Itoa(i))
+				d.lines[child] = lineAt(dec.InputOffset())
+				item, err := value(child)
+				if err != nil {
+					return nil, err
+				}
+				arr = append(arr, item)
+			}
+			_, err := dec.Token()
+			return arr, err
+		}
+		return token, nil
+	}
+
+	var err error
+	d.data, err = value("")
+	if err == nil && dec.More() {
+		err = fmt.Errorf("unexpected data after the top-level value")
+	}
+	if err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return &configProblem{File: d.path, Line: lineAt(syntaxErr.Offset - 1), Message: err.Error()}
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+			return &configProblem{File: d.path, Message: "unexpected end of JSON input"}
+		}
+		return &configProblem{File: d.path, Message: err.Error()}
+	}
+	return nil
+}
+
+var yamlErrorLine = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+func (d *configDocument) parseYAML(data []byte) error {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		if m := yamlErrorLine.FindStringSubmatch(err.Error()); m != nil {
+			line, _ := strconv.Atoi(m[1])
+			return &configProblem{File: d.path, Line: line, Message: m[2]}
+		}
+		return &configProblem{File: d.path, Message: err.Error()}
+	}
+	if len(root.Content) == 0 {
+		d.data = map[string]any{}
+		return nil
+	}
+
+	var err error
+	d.data, err = d.yamlValue(root.Content[0], "")
+	return err
+}
+
+func (d *configDocument) yamlValue(node *yaml.Node, pointer string) (any, error) {
+	switch node.Kind {
+	case yaml.AliasNode:
+		return d.yamlValue(node.Alias, pointer)
+	case yaml.MappingNode:
+		obj := make(map[string]any)
+		var merged []any
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, val := node.Content[i], node.Content[i+1]
+			child := pointer + jsonPointer(key.Value)
+			merge := key.Tag == "!!merge"
+			if !merge {
+				d.addKey(child, key.Line)
+			}
+			v, err := d.yamlValue(val, child)
+			if err != nil {
+				return nil, err
+			}
+			if merge {
+				merged = append(merged, v)
+				continue
+			}
+			obj[key.Value] = v
+		}
+		// Keys merged with << do not replace the mapping's own keys.
+		for _, m := range merged {
+			sources, ok := m.([]any)
+			if !ok {
+				sources = []any{m}
+			}
+			for _, source := range sources {
+				entries, ok := source.(map[string]any)
+				if !ok {
+					return nil, d.problemf(pointer, "<< needs a mapping or a list of mappings")
+				}
+				for k, v := range entries {
+					if _, exists := obj[k]; !exists {
+						obj[k] = v
+					}
+				}
+			}
+		}
+		return obj, nil
+	case yaml.SequenceNode:
+		arr := make([]any, 0, len(node.Content))
+		for i, item := range node.Content {
+			child := pointer + jsonPointer(strconv.Itoa(i))
+			d.lines[child] = item.Line
+			v, err := d.yamlValue(item, child)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		return arr, nil
+	}
+
+	var v any
+	if err := node.Decode(&v); err != nil {
+		return nil, &configProblem{File: d.path, Line: node.Line, Message: err.Error()}
+	}
+	return v, nil
+}
+
+func (d *configDocument) parseTOML(data []byte) error {
+	var v map[string]any
+	if _, err := toml.Decode(string(data), &v); err != nil {
+		var parseErr toml.ParseError
+		if errors.As(err, &parseErr) {
+			return &configProblem{File: d.path, Line: parseErr.Position.Line, Message: parseErr.Message}
+		}
+		return &configProblem{File: d.path, Message: err.Error()}
+	}
+	d.data = v
+	d.scanTOMLLines(data)
+	return nil
+}
+
+// scanTOMLLines records the lines of table headers and keys. The TOML
+// decoder does not report positions, and it already rejects duplicate
+// keys.
+func (d *configDocument) scanTOMLLines(data []byte) {
+	table := ""
+	arrays := make(map[string]int)
+	multiline := ""
+
+	setLines := func(prefix string, keys []string, line int) string {
+		pointer := prefix
+		for _, key := range keys {
+			pointer += jsonPointer(key)
+			if _, exists := d.lines[pointer]; !exists {
+				d.lines[pointer] = line
+			}
+		}
+		return pointer
+	}
+
+	for i, raw := range strings.Split(string(data), "\n") {
+		line := strings.TrimSpace(raw)
+		if multiline != "" {
+			if strings.Contains(line, multiline) {
+				multiline = ""
+			}
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "[["):
+			keys, _ := tomlKey(line[2:])
+			pointer := setLines("", keys, i+1)
+			index := arrays[pointer]
+			arrays[pointer]++
+			table = pointer + jsonPointer(strconv.Itoa(index))
+			d.lines[table] = i + 1
+		case strings.HasPrefix(line, "["):
+			keys, _ := tomlKey(line[1:])
+			table = setLines("", keys, i+1)
+		default:
+			keys, rest := tomlKey(line)
+			if len(keys) == 0 || !strings.HasPrefix(rest, "=") {
+				continue
+			}
+			setLines(table, keys, i+1)
+			value := strings.TrimSpace(rest[1:])
+			for _, delim := range []string{`"""`, `'''`} {
+				if strings.HasPrefix(value, delim) && !strings.Contains(value[3:], delim) {
+					multiline = delim
+				}
+			}
+		}
+	}
+}
+
+// tomlKey parses the dotted key at the start of s and returns its parts
+// and the rest of s.
+func tomlKey(s string) ([]string, string) {
+	var parts []string
+	for {
+		s = strings.TrimLeft(s, " \t")
+		var part string
+		switch {
+		case strings.HasPrefix(s, `"`):
+			end := 1
+			for end < len(s) && s[end] != '"' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(s) {
+				return nil, s
+			}
+			unquoted, err := strconv.Unquote(s[:end+1])
+			if err != nil {
+				unquoted = s[1:end]
+			}
+			part, s = unquoted, s[end+1:]
+		case strings.HasPrefix(s, "'"):
+			end := strings.IndexByte(s[1:], '\'')
+			if end < 0 {
+				return nil, s
+			}
+			part, s = s[1:end+1], s[end+2:]
+		default:
+			end := 0
+			for end < len(s) && (s[end] == '_' || s[end] == '-' ||
+				'a' <= s[end] && s[end] <= 'z' || 'A' <= s[end] && s[end] <= 'Z' || '0' <= s[end] && s[end] <= '9') {
+				end++
+			}
+			if end == 0 {
+				return nil, s
+			}
+			part, s = s[:end], s[end:]
+		}
+		parts = append(parts, part)
+
+		s = strings.TrimLeft(s, " \t")
+		if !strings.HasPrefix(s, ".") {
+			return parts, s
+		}
+		s = s[1:]
+	}
+}
+
+// encodeConfig encodes the configuration in the given format. YAML and
+// TOML are converted from the JSON encoding, so all formats use the
+// same field names.
+func encodeConfig(c *Config, format string) ([]byte, error) {
+	switch format {
+	case formatYAML:
+		var buf bytes.Buffer
+		if err := writeStructured(&buf, outputYAML, c); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case formatTOML:
+		data, err := json.Marshal(c)
+		if err != nil {
+			return nil, err
+		}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		var v any
+		if err := dec.Decode(&v); err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		enc := toml.NewEncoder(&buf)
+		enc.Indent = ""
+		if err := enc.Encode(tomlValue(v)); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return json.MarshalIndent(c, "", "  ")
+}
+
+// tomlValue prepares a decoded JSON value for the TOML encoder, which
+// has no null and needs Go numbers.
+func tomlValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		table := make(map[string]any, len(v))
+		for k, item := range v {
+			if item != nil {
+				table[k] = tomlValue(item)
+			}
+		}
+		return table
+	case []any:
+		arr := make([]any, len(v))
+		for i, item := range v {
+			arr[i] = tomlValue(item)
+		}
+		return arr
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return v
+}
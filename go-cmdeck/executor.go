@@ -2,152 +2,369 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"sort"
 	"strings"
 	"syscall"
+	"time"
 )
 
 type Executor struct {
-	config *Config
+	config   *Config
+	history  *History
+	state    *StateStore
+	services *Services
 }
 
-func NewExecutor(config *Config) *Executor {
-	return &Executor{config: config}
+func NewExecutor(config *Config, history *History, state *StateStore, services *Services) *Executor {
+	return &Executor{config: config, history: history, state: state, services: services}
 }
 
-func (e *Executor) executeCommand(command string, variables map[string]string) error {
-	expandedCommand := e.expandVariables(command, variables)
-	
-	cmd := exec.Command("sh", "-c", expandedCommand)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Stdin = os.Stdin
+// expandVariables resolves the ${VAR} placeholders of a command; see
+// varResolver for the supported syntax.
+func (e *Executor) expandVariables(command string, variables map[string]string) (string, error) {
+	return expandCommand(command, variables)
+}
+
+// Variable modes of a job: splice inserts the quoted values into the
+// command, env exports the variables to the command's environment.
+const (
+	variableModeSplice = "splice"
+	variableModeEnv    = "env"
+)
+
+// prepareCommand returns the command to run for a job action and the
+// variables to add to its environment, according to the job's variable
+// mode.
+func (e *Executor) prepareCommand(job Context, action string) (string, []string, error) {
+	command := job.Commands[action]
+	switch job.VariableMode {
+	case "", variableModeSplice:
+		expanded, err := e.expandVariables(command, job.Variables)
+		return expanded, nil, err
+	case variableModeEnv:
+		env, err := commandEnv(command, job.Variables)
+		return command, env, err
+	}
+	return "", nil, fmt.Errorf("unknown variable_mode %q (expected %s or %s)", job.VariableMode, variableModeSplice, variableModeEnv)
+}
 
-	return cmd.Run()
+// lineWriter buffers everything written to it and reports each complete
+// line to onLine as it arrives.
+type lineWriter struct {
+	buf     bytes.Buffer
+	stream  string
+	onLine  func(stream, line string)
+	pending []byte
 }
 
-func (e *Executor) expandVariables(command string, variables map[string]string) string {
-	result := command
-	for key, value := range variables {
-		placeholder := fmt.Sprintf("${%s}", key)
-		result = strings.ReplaceAll(result, placeholder, value)
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	if w.onLine == nil {
+		return len(p), nil
 	}
-	return result
+
+	w.pending = append(w.pending, p...)
+	for {
+		i := bytes.IndexByte(w.pending, '\n')
+		if i < 0 {
+			break
+		}
+		w.onLine(w.stream, strings.TrimSuffix(string(w.pending[:i]), "\r"))
+		w.pending = w.pending[i+1:]
+	}
+	return len(p), nil
 }
 
-func (e *Executor) executeCommandWithOutput(command string, variables map[string]string) (string, error) {
-	expandedCommand := e.expandVariables(command, variables)
-	
-	cmd := exec.Command("sh", "-c", expandedCommand)
-	
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-	
-	err := cmd.Run()
-	
-	// Get exit status code
-	exitCode := 0
-	if err != nil {
-		if exitError, ok := err.(*exec.ExitError); ok {
-			if status, ok := exitError.Sys().(syscall.WaitStatus); ok {
-				exitCode = status.ExitStatus()
+func (w *lineWriter) flush() {
+	if w.onLine != nil && len(w.pending) > 0 {
+		w.onLine(w.stream, string(w.pending))
+		w.pending = nil
+	}
+}
+
+func (w *lineWriter) String() string {
+	return w.buf.String()
+}
+
+// killGracePeriod is how long a cancelled job gets between SIGTERM and
+// SIGKILL.
+const killGracePeriod = 5 * time.Second
+
+// jobOutput is what executeJobWithOutput captured from a command: the
+// formatted report shown to users and the raw streams.
+type jobOutput struct {
+	Output   string
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Attempts []Attempt
+}
+
+// executeJobWithOutput runs the prepared command with env added to the
+// environment and returns its captured output. With retry, failed
+// attempts are run again; every attempt is recorded in Attempts and has
+// its section in the report, and the streams and the exit code are
+// those of the last attempt.
+// If onLine is non-nil it is called for every stdout/stderr line while the
+// command is running; it may be called from multiple goroutines. With
+// retries, every attempt is first announced on streamAttempt.
+// Cancelling ctx terminates the command's whole process group and stops
+// retrying.
+func (e *Executor) executeJobWithOutput(ctx context.Context, command string, env []string, retry *Retry, onLine func(stream, line string)) (*jobOutput, error) {
+	attempts := retry.attempts()
+	if attempts == 1 {
+		return e.runJobCommand(ctx, command, env, onLine)
+	}
+
+	var report strings.Builder
+	var recorded []Attempt
+	for n := 1; ; n++ {
+		label := fmt.Sprintf("attempt %d/%d", n, attempts)
+		if onLine != nil {
+			onLine(streamAttempt, label)
+		}
+		start := time.Now()
+		captured, err := e.runJobCommand(ctx, command, env, onLine)
+		recorded = append(recorded, Attempt{Attempt: n, Timestamp: start, Duration: time.Since(start), ExitCode: captured.ExitCode})
+		report.WriteString(fmt.Sprintf("=== %s ===\n%s\n", label, strings.TrimRight(captured.Output, "\n")))
+
+		var exitErr *exec.ExitError
+		if n < attempts && ctx.Err() == nil && errors.As(err, &exitErr) && retry.retries(captured.ExitCode) {
+			delay := retry.delay(n)
+			report.WriteString(fmt.Sprintf("Retrying in %s\n\n", delay.Round(time.Millisecond)))
+			select {
+			case <-ctx.Done():
+			case <-time.After(delay):
+				continue
 			}
 		}
+		captured.Output = strings.TrimRight(report.String(), "\n")
+		captured.Attempts = recorded
+		return captured, err
 	}
-	
-	// Build detailed output
+}
+
+// commandExitCode returns the exit code of a command that ended with
+// err. Commands killed by a signal, such as cancelled or timed out
+// jobs, get 128 plus the signal number like in a shell.
+func commandExitCode(err error) int {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return 0
+	}
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return exitErr.ExitCode()
+}
+
+// runJobCommand runs the prepared command once for executeJobWithOutput.
+func (e *Executor) runJobCommand(ctx context.Context, command string, env []string, onLine func(stream, line string)) (*jobOutput, error) {
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	reap := setProcessGroup(cmd)
+
+	stdout := &lineWriter{stream: "stdout", onLine: onLine}
+	stderr := &lineWriter{stream: "stderr", onLine: onLine}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+	reap()
+	stdout.flush()
+	stderr.flush()
+
+	stdoutStr, stderrStr := stdout.String(), stderr.String()
+	exitCode := commandExitCode(err)
+	return &jobOutput{
+		Output:   formatReport(command, exitCode, stdoutStr, stderrStr),
+		Stdout:   stdoutStr,
+		Stderr:   stderrStr,
+		ExitCode: exitCode,
+	}, err
+}
+
+// formatReport builds the report shown for a finished command: the
+// command and its exit code, followed by its stdout and stderr.
+func formatReport(command string, exitCode int, stdout, stderr string) string {
 	var result strings.Builder
-	result.WriteString(fmt.Sprintf("Command: %s\n", expandedCommand))
+	result.WriteString(fmt.Sprintf("Command: %s\n", command))
 	result.WriteString(fmt.Sprintf("Exit Code: %d\n", exitCode))
 	result.WriteString("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━\n")
-	
-	stdoutStr := stdout.String()
-	stderrStr := stderr.String()
-	
-	if stdoutStr != "" {
+
+	if stdout != "" {
 		result.WriteString("STDOUT:\n")
-		result.WriteString(stdoutStr)
-		if !strings.HasSuffix(stdoutStr, "\n") {
+		result.WriteString(stdout)
+		if !strings.HasSuffix(stdout, "\n") {
 			result.WriteString("\n")
 		}
 	}
-	
-	if stderrStr != "" {
-		if stdoutStr != "" {
+
+	if stderr != "" {
+		if stdout != "" {
 			result.WriteString("\n")
 		}
 		result.WriteString("STDERR:\n")
-		result.WriteString(stderrStr)
-		if !strings.HasSuffix(stderrStr, "\n") {
+		result.WriteString(stderr)
+		if !strings.HasSuffix(stderr, "\n") {
 			result.WriteString("\n")
 		}
 	}
-	
-	if stdoutStr == "" && stderrStr == "" {
+
+	if stdout == "" && stderr == "" {
 		result.WriteString("(no output)")
 	}
-	
-	return result.String(), err
+
+	return result.String()
 }
 
-func (e *Executor) executeJobWithOutput(command string, variables map[string]string) (string, int, error) {
-	expandedCommand := e.expandVariables(command, variables)
-	
-	cmd := exec.Command("sh", "-c", expandedCommand)
-	
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-	
-	err := cmd.Run()
-	
-	// Get exit status code
-	exitCode := 0
+// jobTimeout returns the timeout of a job, falling back to the global
+// default. Zero means no timeout.
+func (e *Executor) jobTimeout(job Context) (time.Duration, error) {
+	timeout := job.Timeout
+	if timeout == "" {
+		timeout = e.config.DefaultTimeout
+	}
+	if timeout == "" {
+		return 0, nil
+	}
+
+	d, err := parseDuration(timeout)
 	if err != nil {
-		if exitError, ok := err.(*exec.ExitError); ok {
-			if status, ok := exitError.Sys().(syscall.WaitStatus); ok {
-				exitCode = status.ExitStatus()
-			}
-		}
+		return 0, fmt.Errorf("job '%s': invalid timeout: %w", job.Name, err)
 	}
-	
-	// Build detailed output
-	var result strings.Builder
-	result.WriteString(fmt.Sprintf("Command: %s\n", expandedCommand))
-	result.WriteString(fmt.Sprintf("Exit Code: %d\n", exitCode))
-	result.WriteString("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━\n")
-	
-	stdoutStr := stdout.String()
-	stderrStr := stderr.String()
-	
-	if stdoutStr != "" {
-		result.WriteString("STDOUT:\n")
-		result.WriteString(stdoutStr)
-		if !strings.HasSuffix(stdoutStr, "\n") {
-			result.WriteString("\n")
+	return d, nil
+}
+
+// executeJob runs one action of a job and returns a result stamped with
+// a fresh run ID and the start/end time of the run. onLine is passed
+// through to executeJobWithOutput for live output. The run is stopped
+// when ctx is cancelled or the job's timeout expires, and the result is
+// marked accordingly. The run action is retried according to the job's
+// retry settings, within the same timeout. Secret values are masked in
+// the live output and in the result.
+func (e *Executor) executeJob(ctx context.Context, job Context, action string, onLine func(stream, line string)) (*ExecutionResult, error) {
+	if _, exists := job.Commands[action]; !exists {
+		return nil, fmt.Errorf("job '%s' has no %s command", job.Name, action)
+	}
+
+	timeout, err := e.jobTimeout(job)
+	if err != nil {
+		return nil, err
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	secrets, err := e.resolveSecrets(ctx, job)
+	if err != nil {
+		return nil, fmt.Errorf("job '%s': %w", job.Name, err)
+	}
+	masker := newSecretMasker(secrets)
+	if onLine != nil {
+		streamLine := onLine
+		onLine = func(stream, line string) {
+			streamLine(stream, masker.mask(line))
 		}
 	}
-	
-	if stderrStr != "" {
-		if stdoutStr != "" {
-			result.WriteString("\n")
+
+	command, env, err := e.prepareCommand(job.withValues(secrets), action)
+	if err != nil {
+		return nil, fmt.Errorf("job '%s': %w", job.Name, err)
+	}
+
+	var retry *Retry
+	if action == defaultAction {
+		retry = job.Retry
+	}
+
+	start := time.Now()
+	captured, err := e.executeJobWithOutput(ctx, command, env, retry, onLine)
+	end := time.Now()
+
+	output := captured.Output
+	status := StatusFailed
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		status = StatusTimeout
+		output += fmt.Sprintf("\n\nTimed out after %s", timeout)
+	case errors.Is(ctx.Err(), context.Canceled):
+		status = StatusCancelled
+		output += "\n\nCancelled"
+	case err == nil && captured.ExitCode == 0:
+		status = StatusSuccess
+	}
+
+	return &ExecutionResult{
+		RunID:     newRunID(start),
+		Job:       job.Name,
+		Action:    action,
+		Timestamp: start,
+		EndTime:   end,
+		Duration:  end.Sub(start),
+		Success:   status == StatusSuccess,
+		Status:    status,
+		ExitCode:  captured.ExitCode,
+		Output:    masker.mask(output),
+		Stdout:    masker.mask(captured.Stdout),
+		Stderr:    masker.mask(captured.Stderr),
+		Attempts:  captured.Attempts,
+	}, nil
+}
+
+// recordResult stores the result as the job's LastResult and as the last
+// result of its action in the state file, and appends it to the
+// execution history applying the retention policy. The jobs also get
+// the results other processes recorded meanwhile.
+// Workflow steps of other jobs update those jobs' last results but are
+// only recorded in the history as part of the workflow run.
+func (e *Executor) recordResult(result *ExecutionResult) error {
+	var results []*ExecutionResult
+	for _, step := range result.Steps {
+		if step.Job != result.Job {
+			results = append(results, step)
 		}
-		result.WriteString("STDERR:\n")
-		result.WriteString(stderrStr)
-		if !strings.HasSuffix(stderrStr, "\n") {
-			result.WriteString("\n")
+	}
+	results = append(results, result)
+	state, err := e.state.record(results...)
+	if err != nil {
+		// The results are still shown by this process.
+		for _, r := range results {
+			e.setLastResult(r)
 		}
+		return fmt.Errorf("failed to save result: %w", err)
 	}
-	
-	if stdoutStr == "" && stderrStr == "" {
-		result.WriteString("(no output)")
+	e.config.applyState(state)
+
+	if e.history == nil {
+		return nil
 	}
-	
-	return result.String(), exitCode, err
+	if err := e.history.record(result); err != nil {
+		return fmt.Errorf("failed to record history: %w", err)
+	}
+	return e.history.prune(result.Job, e.config.History)
+}
+
+func (e *Executor) setLastResult(result *ExecutionResult) {
+	job, exists := e.config.Contexts[result.Job]
+	if !exists {
+		return
+	}
+	job.LastResult = result
+	if job.ActionResults == nil {
+		job.ActionResults = make(map[string]*ExecutionResult)
+	}
+	job.ActionResults[result.Action] = result
+	e.config.Contexts[result.Job] = job
 }
 
 func (e *Executor) listContexts() []Context {
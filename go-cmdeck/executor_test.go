@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestFormatReport(t *testing.T) {
+	const header = "Command: make build\nExit Code: 2\n━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━\n"
+	tests := []struct {
+		name           string
+		stdout, stderr string
+		want           string
+	}{
+		{"no output", "", "", header + "(no output)"},
+		{"stdout", "ok\n", "", header + "STDOUT:\nok\n"},
+		{"stdout without newline", "ok", "", header + "STDOUT:\nok\n"},
+		{"stderr", "", "oops", header + "STDERR:\noops\n"},
+		{"both", "ok\n", "oops\n", header + "STDOUT:\nok\n\nSTDERR:\noops\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatReport("make build", 2, tt.stdout, tt.stderr); got != tt.want {
+				t.Errorf("formatReport = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunJobCommandReport(t *testing.T) {
+	e := NewExecutor(&Config{}, nil, nil, nil)
+	captured, err := e.runJobCommand(context.Background(), "echo out; echo err >&2; exit 3", nil, nil)
+	if err == nil {
+		t.Fatal("command exiting with 3 succeeded")
+	}
+	if captured.ExitCode != 3 || captured.Stdout != "out\n" || captured.Stderr != "err\n" {
+		t.Errorf("captured %+v, want exit code 3 and both streams", captured)
+	}
+	if want := formatReport("echo out; echo err >&2; exit 3", 3, "out\n", "err\n"); captured.Output != want {
+		t.Errorf("output = %q, want %q", captured.Output, want)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// lockTimeout is how long go-cmdeck waits for another process to release
+// the lock of a file it wants to update.
+const lockTimeout = 10 * time.Second
+
+// lockPath returns the lock file that guards updates of path. The file
+// itself cannot be locked because it is replaced on every write.
+func lockPath(path string) string {
+	return path + ".lock"
+}
+
+// writeFileAtomic replaces path with data by writing a temporary file in
+// the same directory and renaming it, so that readers never see a
+// partly written file. An existing file keeps its permissions, and a
+// symlink keeps pointing to the replaced file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	}
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/sahilm/fuzzy"
+)
+
+// Fields of a job the TUI filter matches against. Commands are matched
+// under the name of their action.
+const (
+	matchLabel       = "label"
+	matchName        = "name"
+	matchDescription = "description"
+)
+
+// jobMatch is a job that matches the filter of the TUI list: the field
+// with the best match and the byte offsets of the matched characters in
+// its text.
+type jobMatch struct {
+	job     int
+	field   string
+	text    string
+	indexes []int
+	score   int
+}
+
+// matchJobs fuzzy-matches the filter against the label, name,
+// description and commands of the jobs and returns the matching jobs,
+// best match first. job in the matches is the index in jobs.
+func matchJobs(jobs []Context, filter string) []jobMatch {
+	var matches []jobMatch
+	for i, job := range jobs {
+		fields := []string{matchLabel, matchName, matchDescription}
+		texts := []string{job.Label, job.Name, job.Description}
+		for _, action := range job.actions() {
+			fields = append(fields, action)
+			texts = append(texts, job.Commands[action])
+		}
+
+		found := fuzzy.FindNoSort(filter, texts)
+		if len(found) == 0 {
+			continue
+		}
+		best := found[0]
+		for _, match := range found[1:] {
+			if match.Score > best.Score {
+				best = match
+			}
+		}
+		matches = append(matches, jobMatch{
+			job:     i,
+			field:   fields[best.Index],
+			text:    best.Str,
+			indexes: best.MatchedIndexes,
+			score:   best.Score,
+		})
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].score > matches[j].score })
+	return matches
+}
+
+// snippetContext is how many bytes a snippet shows before the first
+// matched character.
+const snippetContext = 10
+
+// snippet returns the matched text on one line and the offsets of the
+// matched characters in it. Long texts such as commands start shortly
+// before the first match, so that it is not cut off in the list.
+func (jm jobMatch) snippet() (string, []int) {
+	text := strings.ReplaceAll(jm.text, "\n", " ")
+	if len(jm.indexes) == 0 || jm.indexes[0] <= snippetContext {
+		return text, jm.indexes
+	}
+	start := jm.indexes[0] - snippetContext
+	for !utf8.RuneStart(text[start]) {
+		start++
+	}
+	return "..." + text[start:], offsetMarks(jm.indexes, len("...")-start)
+}
+
+// highlighted renders text with style, and the characters starting at
+// the byte offsets in marks with highlight instead.
+func highlighted(text string, marks []int, style, highlight lipgloss.Style) string {
+	if len(marks) == 0 {
+		return style.Render(text)
+	}
+	marked := make(map[int]bool, len(marks))
+	for _, offset := range marks {
+		marked[offset] = true
+	}
+
+	var out, plain strings.Builder
+	for offset, r := range text {
+		if !marked[offset] {
+			plain.WriteRune(r)
+			continue
+		}
+		if plain.Len() > 0 {
+			out.WriteString(style.Render(plain.String()))
+			plain.Reset()
+		}
+		out.WriteString(highlight.Render(string(r)))
+	}
+	if plain.Len() > 0 {
+		out.WriteString(style.Render(plain.String()))
+	}
+	return out.String()
+}
+
+// offsetMarks shifts the matched byte offsets of a text to where the
+// text starts in a line.
+func offsetMarks(indexes []int, start int) []int {
+	marks := make([]int, len(indexes))
+	for i, index := range indexes {
+		marks[i] = start + index
+	}
+	return marks
+}
+
+// matchStyle is how matched characters are shown in a list line drawn
+// with style.
+func (m *model) matchStyle(style lipgloss.Style) lipgloss.Style {
+	return style.Foreground(lipgloss.Color(m.theme.Title)).Underline(true)
+}
+
+// filterLine is shown after the title while the list is filtered.
+func (m *model) filterLine() string {
+	line := "  /" + m.filter
+	if m.filtering {
+		line += "_"
+	}
+	return line + fmt.Sprintf("  (%d of %d jobs)", len(m.rows), len(m.contexts))
+}
+
+// setFilter filters the job list, keeping the cursor on the current
+// job if it is still listed.
+func (m *model) setFilter(filter string) {
+	name := ""
+	if i, ok := m.currentJob(); ok {
+		name = m.contexts[i].Name
+	}
+	m.filter = filter
+	m.buildRows()
+	m.cursor = 0
+	if filter == "" {
+		m.selectJob(name)
+	}
+}
+
+// filterRows lists the jobs that match the filter.
+func (m *model) filterRows() {
+	m.matches = make(map[int]jobMatch)
+	for _, match := range matchJobs(m.contexts, m.filter) {
+		m.matches[match.job] = match
+		m.rows = append(m.rows, listRow{job: match.job})
+	}
+}
+
+// updateFilter handles keys while the filter is typed. enter keeps the
+// filter and returns to the list keys; esc clears it.
+func (m *model) updateFilter(msg tea.KeyMsg) tea.Cmd {
+	switch msg.Type {
+	case tea.KeyCtrlC:
+		return m.quit()
+	case tea.KeyEsc:
+		m.filtering = false
+		m.setFilter("")
+	case tea.KeyEnter:
+		m.filtering = false
+	case tea.KeyUp, tea.KeyCtrlP:
+		m.moveCursor(-1)
+	case tea.KeyDown, tea.KeyCtrlN:
+		m.moveCursor(1)
+	case tea.KeyPgUp:
+		m.moveCursor(-m.listHeight())
+	case tea.KeyPgDown:
+		m.moveCursor(m.listHeight())
+	case tea.KeyBackspace:
+		if m.filter != "" {
+			runes := []rune(m.filter)
+			m.setFilter(string(runes[:len(runes)-1]))
+		}
+	case tea.KeyCtrlU:
+		m.setFilter("")
+	case tea.KeyRunes, tea.KeySpace:
+		m.setFilter(m.filter + string(msg.Runes))
+	}
+	return nil
+}
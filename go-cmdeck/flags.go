@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// keyValueFlag collects repeatable key=value flags such as
+// -cmd run='echo hi' or -var HOST=localhost.
+type keyValueFlag map[string]string
+
+func (f keyValueFlag) String() string {
+	pairs := make([]string, 0, len(f))
+	for _, k := range sortedKeys(f) {
+		pairs = append(pairs, k+"="+f[k])
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (f keyValueFlag) Set(value string) error {
+	key, val, err := parseKeyValue(value)
+	if err != nil {
+		return err
+	}
+	f[key] = val
+	return nil
+}
+
+// secretFlag collects repeatable NAME=file:PATH, NAME=env:VAR or
+// NAME=cmd:COMMAND flags.
+type secretFlag map[string]Secret
+
+func (f secretFlag) String() string {
+	pairs := make([]string, 0, len(f))
+	for _, name := range sortedSecretNames(f) {
+		pairs = append(pairs, name+"="+f[name].String())
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (f secretFlag) Set(value string) error {
+	name, spec, err := parseKeyValue(value)
+	if err != nil {
+		return err
+	}
+	secret, err := parseSecret(spec)
+	if err != nil {
+		return err
+	}
+	f[name] = secret
+	return nil
+}
+
+// stringListFlag collects a repeatable flag. Comma-separated values are
+// split into separate entries.
+type stringListFlag []string
+
+func (f *stringListFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringListFlag) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*f = append(*f, v)
+		}
+	}
+	return nil
+}
+
+// notifyFlag collects repeatable notification flags: desktop, bell,
+// http(s)://URL or cmd:COMMAND. An empty value adds nothing.
+type notifyFlag []Notification
+
+func (f *notifyFlag) String() string {
+	specs := make([]string, len(*f))
+	for i, n := range *f {
+		specs[i] = n.String()
+	}
+	return strings.Join(specs, ",")
+}
+
+func (f *notifyFlag) Set(value string) error {
+	if value == "" {
+		return nil
+	}
+	n, err := parseNotification(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, n)
+	return nil
+}
+
+// with returns the notifications filtered by the given policy; an empty
+// policy keeps them as they are.
+func (f notifyFlag) with(on string) ([]Notification, error) {
+	if on == "" {
+		return f, nil
+	}
+	if len(f) == 0 {
+		return nil, fmt.Errorf("-notify-on requires -notify")
+	}
+	if err := checkNotifyPolicy(on); err != nil {
+		return nil, err
+	}
+	notifications := make([]Notification, len(f))
+	for i, n := range f {
+		n.On = on
+		notifications[i] = n
+	}
+	return notifications, nil
+}
+
+// parseKeyValue splits "key=value" at the first "=".
+func parseKeyValue(s string) (string, string, error) {
+	key, value, found := strings.Cut(s, "=")
+	key = strings.TrimSpace(key)
+	if !found || key == "" {
+		return "", "", fmt.Errorf("expected KEY=VALUE, got %q", s)
+	}
+	if strings.ContainsAny(key, " \t") {
+		return "", "", fmt.Errorf("invalid key %q", key)
+	}
+	return key, value, nil
+}
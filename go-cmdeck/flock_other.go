@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+// lockFile does nothing on platforms without flock; writes are still
+// atomic but concurrent updates may overwrite each other.
+func lockFile(path string) (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// HealthCheck tells whether what a job sets up, such as a tunnel or a
+// VPN, is still up. Exactly one of the probes is set: Command is a shell
+// command that exits with 0 when up, TCP a host:port that accepts
+// connections, HTTP a URL that answers with a status below 400 and
+// Process a pattern matched against the command lines of the running
+// processes (pgrep -f). The probes may use the job's ${VAR} variables.
+// The check runs every Interval (30s by default) and fails after
+// Timeout (5s by default).
+type HealthCheck struct {
+	Command  string `json:"command,omitempty"`
+	TCP      string `json:"tcp,omitempty"`
+	HTTP     string `json:"http,omitempty"`
+	Process  string `json:"process,omitempty"`
+	Interval string `json:"interval,omitempty"`
+	Timeout  string `json:"timeout,omitempty"`
+}
+
+const (
+	defaultCheckInterval = 30 * time.Second
+	defaultCheckTimeout  = 5 * time.Second
+)
+
+// Health states of a job. unknown means the check has not run yet or
+// could not be run.
+const (
+	healthUp      = "up"
+	healthDown    = "down"
+	healthUnknown = "unknown"
+)
+
+// HealthStatus is the outcome of the last health check of a job: its
+// state, when it was checked, since when it is in that state and a
+// short detail such as "connection refused" or "HTTP 503".
+type HealthStatus struct {
+	Job     string    `json:"job"`
+	State   string    `json:"state"`
+	Checked time.Time `json:"checked"`
+	Changed time.Time `json:"changed"`
+	Message string    `json:"message,omitempty"`
+}
+
+// probe returns the kind and the target of the check.
+func (h *HealthCheck) probe() (string, string) {
+	switch {
+	case h.Command != "":
+		return "command", h.Command
+	case h.TCP != "":
+		return "tcp", h.TCP
+	case h.HTTP != "":
+		return "http", h.HTTP
+	case h.Process != "":
+		return "process", h.Process
+	}
+	return "", ""
+}
+
+func (h *HealthCheck) String() string {
+	kind, target := h.probe()
+	return kind + " " + target
+}
+
+// parseHealthCheck parses the probe of a check as given on the command
+// line: cmd:COMMAND, tcp:HOST:PORT, an http(s):// URL or
+// process:PATTERN.
+func parseHealthCheck(spec string) (*HealthCheck, error) {
+	kind, ref, _ := strings.Cut(spec, ":")
+	if ref == "" {
+		return nil, fmt.Errorf("expected cmd:COMMAND, tcp:HOST:PORT, http(s)://URL or process:PATTERN, got %q", spec)
+	}
+	switch kind {
+	case "cmd":
+		return &HealthCheck{Command: ref}, nil
+	case "tcp":
+		return &HealthCheck{TCP: ref}, nil
+	case "http", "https":
+		return &HealthCheck{HTTP: spec}, nil
+	case "process":
+		return &HealthCheck{Process: ref}, nil
+	}
+	return nil, fmt.Errorf("unknown check %q (expected cmd, tcp, http, https or process)", kind)
+}
+
+func (h *HealthCheck) check() error {
+	set := 0
+	for _, probe := range []string{h.Command, h.TCP, h.HTTP, h.Process} {
+		if probe != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("set exactly one of command, tcp, http and process")
+	}
+	if h.Interval != "" {
+		if d, err := parseDuration(h.Interval); err != nil || d <= 0 {
+			return fmt.Errorf("invalid interval %q", h.Interval)
+		}
+	}
+	if h.Timeout != "" {
+		if d, err := parseDuration(h.Timeout); err != nil || d <= 0 {
+			return fmt.Errorf("invalid timeout %q", h.Timeout)
+		}
+	}
+	return nil
+}
+
+// checkHealth reports a health check that cannot work.
+func (c Context) checkHealth() error {
+	if c.Check == nil {
+		return nil
+	}
+	if err := c.Check.check(); err != nil {
+		return fmt.Errorf("job '%s': check: %w", c.Name, err)
+	}
+	return nil
+}
+
+func (h *HealthCheck) interval() time.Duration {
+	if d, err := parseDuration(h.Interval); err == nil && d > 0 {
+		return d
+	}
+	return defaultCheckInterval
+}
+
+func (h *HealthCheck) timeout() time.Duration {
+	if d, err := parseDuration(h.Timeout); err == nil && d > 0 {
+		return d
+	}
+	return defaultCheckTimeout
+}
+
+// checkJob runs the health check of a job and records the outcome in
+// the state file. It only works on the given copy of the job, so it may
+// run in the background.
+func (e *Executor) checkJob(ctx context.Context, job Context) (HealthStatus, error) {
+	st := e.probe(ctx, job)
+	return e.recordHealth(st)
+}
+
+// probe runs the health check of a job. Checks use the job's variables
+// and the defaults of its parameters; secrets are not read for them.
+func (e *Executor) probe(ctx context.Context, job Context) HealthStatus {
+	st := HealthStatus{Job: job.Name, State: healthUnknown, Checked: time.Now()}
+	check := job.Check
+	if err := check.check(); err != nil {
+		st.Message = err.Error()
+		return st
+	}
+	overrides, err := job.resolveParams(nil)
+	if err != nil {
+		st.Message = err.Error()
+		return st
+	}
+	job = job.withValues(overrides)
+
+	ctx, cancel := context.WithTimeout(ctx, check.timeout())
+	defer cancel()
+
+	kind, target := check.probe()
+	if kind == "command" {
+		st.State, st.Message = e.probeCommand(ctx, job)
+		return st
+	}
+	if target, err = expandText(target, job.Variables); err != nil {
+		st.Message = err.Error()
+		return st
+	}
+	switch kind {
+	case "tcp":
+		st.State, st.Message = probeTCP(ctx, target)
+	case "http":
+		st.State, st.Message = probeHTTP(ctx, target)
+	case "process":
+		st.State, st.Message = probeProcess(ctx, target)
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		st.State, st.Message = healthDown, fmt.Sprintf("timed out after %s", check.timeout())
+	}
+	return st
+}
+
+// probeCommand runs the check command like an action of the job. The
+// first line of its output is the detail.
+func (e *Executor) probeCommand(ctx context.Context, job Context) (string, string) {
+	job.Commands = map[string]string{"check": job.Check.Command}
+	command, env, err := e.prepareCommand(job, "check")
+	if err != nil {
+		return healthUnknown, err.Error()
+	}
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	reap := setProcessGroup(cmd)
+	output, err := cmd.CombinedOutput()
+	reap()
+
+	detail, _, _ := strings.Cut(strings.TrimSpace(string(output)), "\n")
+	var exitErr *exec.ExitError
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return healthDown, fmt.Sprintf("timed out after %s", job.Check.timeout())
+	case errors.As(err, &exitErr):
+		if detail == "" {
+			detail = fmt.Sprintf("exit code %d", exitErr.ExitCode())
+		}
+		return healthDown, detail
+	case err != nil:
+		return healthUnknown, err.Error()
+	}
+	return healthUp, detail
+}
+
+func probeTCP(ctx context.Context, address string) (string, string) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		var addrErr *net.AddrError
+		if errors.As(err, &addrErr) {
+			return healthUnknown, err.Error()
+		}
+		return healthDown, err.Error()
+	}
+	conn.Close()
+	return healthUp, "connected to " + address
+}
+
+func probeHTTP(ctx context.Context, url string) (string, string) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return healthUnknown, err.Error()
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return healthDown, err.Error()
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return healthDown, resp.Status
+	}
+	return healthUp, resp.Status
+}
+
+func probeProcess(ctx context.Context, pattern string) (string, string) {
+	output, err := exec.CommandContext(ctx, "pgrep", "-f", pattern).Output()
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 1:
+		return healthDown, "no matching process"
+	case err != nil:
+		return healthUnknown, fmt.Sprintf("pgrep: %v", err)
+	}
+	pids := strings.Fields(string(output))
+	if len(pids) == 1 {
+		return healthUp, "pid " + pids[0]
+	}
+	return healthUp, fmt.Sprintf("%d processes", len(pids))
+}
+
+// recordHealth stores the outcome of a check in the state file and
+// returns it with the time of the last change of the state.
+func (e *Executor) recordHealth(st HealthStatus) (HealthStatus, error) {
+	_, err := e.state.update(func(state *State) bool {
+		last := state.Health[st.Job]
+		if last != nil && last.Checked.After(st.Checked) {
+			// Another process checked meanwhile.
+			st = *last
+			return false
+		}
+		st.Changed = st.Checked
+		if last != nil && last.State == st.State {
+			st.Changed = last.Changed
+		}
+		if state.Health == nil {
+			state.Health = make(map[string]*HealthStatus)
+		}
+		recorded := st
+		state.Health[st.Job] = &recorded
+		return true
+	})
+	if err != nil {
+		return st, fmt.Errorf("failed to save the health of '%s': %w", st.Job, err)
+	}
+	return st, nil
+}
+
+// serviceHealth derives the health of a service without a check from
+// its status: up while it runs.
+func serviceHealth(st serviceStatus) HealthStatus {
+	health := HealthStatus{Job: st.Name, State: healthDown, Checked: time.Now(), Changed: st.Since, Message: "service " + st.summary()}
+	if st.State == serviceRunning {
+		health.State = healthUp
+	}
+	return health
+}
+
+// healthIcons are the status icons of checked jobs in the TUI list.
+var healthIcons = map[string]string{
+	healthUp:      "▲",
+	healthDown:    "▼",
+	healthUnknown: "?",
+}
+
+// timeLabel describes a moment such as when a state began: the time
+// for today, the date and time otherwise.
+func timeLabel(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	if y, m, d := t.Date(); y == time.Now().Year() && m == time.Now().Month() && d == time.Now().Day() {
+		return t.Format("15:04:05")
+	}
+	return t.Format("2006-01-02 15:04")
+}
+
+// summary describes the health in a few words, such as "down since
+// 14:03:05".
+func (st HealthStatus) summary() string {
+	if st.Changed.IsZero() {
+		return st.State
+	}
+	return st.State + " since " + timeLabel(st.Changed)
+}
+
+type healthTickMsg struct{}
+
+// healthMsg delivers the outcome of a health check run by the TUI.
+type healthMsg struct {
+	status HealthStatus
+	err    error
+}
+
+// healthTick wakes the TUI up to run the checks that are due; every job
+// is checked at its own interval.
+func healthTick() tea.Cmd {
+	return tea.Tick(time.Second, func(time.Time) tea.Msg {
+		return healthTickMsg{}
+	})
+}
+
+// loadHealth shows the checks recorded by earlier runs until the jobs
+// are checked again.
+func (m *model) loadHealth(state *State) {
+	for name, st := range state.Health {
+		m.health[name] = *st
+	}
+}
+
+// dueChecks starts the checks of the jobs whose interval has passed
+// since their last check, each in the background.
+func (m *model) dueChecks() []tea.Cmd {
+	var cmds []tea.Cmd
+	for _, job := range m.contexts {
+		if job.Check == nil || m.checking[job.Name] || !m.autoCheck(job) {
+			continue
+		}
+		if last, exists := m.health[job.Name]; exists && time.Since(last.Checked) < job.Check.interval() {
+			continue
+		}
+		m.checking[job.Name] = true
+		executor := m.executor
+		cmds = append(cmds, func() tea.Msg {
+			st, err := executor.checkJob(context.Background(), job)
+			return healthMsg{status: st, err: err}
+		})
+	}
+	return cmds
+}
+
+// autoCheck reports whether the TUI runs the check of a job by itself.
+// Command checks of jobs from project files only run with `status`, so
+// that opening the TUI in a checked-out repository runs none of its
+// commands.
+func (m *model) autoCheck(job Context) bool {
+	if job.Check.Command == "" {
+		return true
+	}
+	return m.executor.config.source(job.Name).Kind != layerProject
+}
+
+// jobHealth returns the health of a job with a check. ok is false for
+// other jobs.
+func (m *model) jobHealth(job Context) (HealthStatus, bool) {
+	if job.Check == nil {
+		return HealthStatus{}, false
+	}
+	st, exists := m.health[job.Name]
+	if !exists {
+		st = HealthStatus{Job: job.Name, State: healthUnknown}
+		if !m.autoCheck(job) {
+			st.Message = "command check from a project file; run `go-cmdeck status` to check"
+		}
+	}
+	return st, true
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseHealthCheck(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    HealthCheck
+		wantErr string
+	}{
+		{spec: "cmd:pg_isready -h db", want: HealthCheck{Command: "pg_isready -h db"}},
+		{spec: "cmd:echo a:b", want: HealthCheck{Command: "echo a:b"}},
+		{spec: "tcp:localhost:5432", want: HealthCheck{TCP: "localhost:5432"}},
+		{spec: "http://localhost:8080/health", want: HealthCheck{HTTP: "http://localhost:8080/health"}},
+		{spec: "https://example.com", want: HealthCheck{HTTP: "https://example.com"}},
+		{spec: "process:ssh -N", want: HealthCheck{Process: "ssh -N"}},
+		{spec: "", wantErr: "expected cmd:COMMAND"},
+		{spec: "cmd:", wantErr: "expected cmd:COMMAND"},
+		{spec: "localhost:5432", wantErr: "unknown check"},
+		{spec: "ping:localhost", wantErr: "unknown check"},
+		{spec: "TCP:localhost:5432", wantErr: "unknown check"},
+	}
+	for _, tt := range tests {
+		got, err := parseHealthCheck(tt.spec)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseHealthCheck(%q) = %+v, %v; want an error containing %q", tt.spec, got, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || *got != tt.want {
+			t.Errorf("parseHealthCheck(%q) = %+v, %v; want %+v", tt.spec, got, err, tt.want)
+		}
+		if err := got.check(); err != nil {
+			t.Errorf("parseHealthCheck(%q) returned an invalid check: %v", tt.spec, err)
+		}
+	}
+}
+
+func TestHealthCheckCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		check   HealthCheck
+		wantErr bool
+	}{
+		{"one probe", HealthCheck{TCP: "localhost:1"}, false},
+		{"interval and timeout", HealthCheck{TCP: "localhost:1", Interval: "1m", Timeout: "2s"}, false},
+		{"no probe", HealthCheck{Interval: "1m"}, true},
+		{"two probes", HealthCheck{TCP: "localhost:1", HTTP: "http://localhost"}, true},
+		{"bad interval", HealthCheck{TCP: "localhost:1", Interval: "often"}, true},
+		{"zero interval", HealthCheck{TCP: "localhost:1", Interval: "0s"}, true},
+		{"negative timeout", HealthCheck{TCP: "localhost:1", Timeout: "-1s"}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.check.check(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: check() = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestProbe(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := closed.Addr().String()
+	closed.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/down" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name        string
+		check       HealthCheck
+		wantState   string
+		wantMessage string
+	}{
+		{"tcp up", HealthCheck{TCP: listener.Addr().String()}, healthUp, "connected to"},
+		{"tcp with a variable", HealthCheck{TCP: "${ADDR}"}, healthUp, "connected to " + listener.Addr().String()},
+		{"tcp refused", HealthCheck{TCP: closedAddr}, healthDown, "refused"},
+		{"tcp without port", HealthCheck{TCP: "127.0.0.1"}, healthUnknown, "missing port"},
+		{"http up", HealthCheck{HTTP: server.URL + "/up"}, healthUp, "200"},
+		{"http 503", HealthCheck{HTTP: server.URL + "/down"}, healthDown, "503"},
+		{"command up", HealthCheck{Command: "echo ready; echo more"}, healthUp, "ready"},
+		{"command down", HealthCheck{Command: "exit 2"}, healthDown, "exit code 2"},
+		{"command down with output", HealthCheck{Command: "echo no route >&2; exit 1"}, healthDown, "no route"},
+		{"command timeout", HealthCheck{Command: "sleep 5", Timeout: "100ms"}, healthDown, "timed out after 100ms"},
+		{"undefined variable", HealthCheck{TCP: "${NOPE}"}, healthUnknown, "NOPE"},
+		{"no probe", HealthCheck{}, healthUnknown, "set exactly one"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := Context{Name: "tunnel", Variables: map[string]string{"ADDR": listener.Addr().String()}, Check: &tt.check}
+			e := NewExecutor(&Config{}, nil, nil, nil)
+			start := time.Now()
+			st := e.probe(context.Background(), job)
+			if st.State != tt.wantState || !strings.Contains(st.Message, tt.wantMessage) {
+				t.Errorf("probe = %s %q, want %s with %q", st.State, st.Message, tt.wantState, tt.wantMessage)
+			}
+			if elapsed := time.Since(start); elapsed > 3*time.Second {
+				t.Errorf("probe took %s", elapsed)
+			}
+		})
+	}
+}
+
+func TestRecordHealthKeepsChangeTime(t *testing.T) {
+	cli, _ := newTestCLI(t, nil)
+	e := cli.executor
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	record := func(state string, at time.Duration) HealthStatus {
+		t.Helper()
+		st, err := e.recordHealth(HealthStatus{Job: "tunnel", State: state, Checked: start.Add(at)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return st
+	}
+
+	if st := record(healthUp, 0); !st.Changed.Equal(start) {
+		t.Errorf("first check changed at %s, want %s", st.Changed, start)
+	}
+	if st := record(healthUp, time.Minute); !st.Changed.Equal(start) {
+		t.Errorf("same state changed at %s, want %s", st.Changed, start)
+	}
+	if st := record(healthDown, 2*time.Minute); !st.Changed.Equal(start.Add(2 * time.Minute)) {
+		t.Errorf("new state changed at %s, want the time of the check", st.Changed)
+	}
+	// An older outcome, such as one of a slower process, does not win.
+	if st := record(healthUp, 90*time.Second); st.State != healthDown {
+		t.Errorf("older check recorded %s, want the newer down", st.State)
+	}
+}
+
+func TestTUIDoesNotRunCommandChecksOfProjectFiles(t *testing.T) {
+	// newTestCLI works in a temporary directory, which becomes the
+	// project directory.
+	cli, _ := newTestCLI(t, map[string]Context{
+		"user-cmd": {Name: "user-cmd", Label: "User", Commands: map[string]string{"run": "true"}, Check: &HealthCheck{Command: "true"}},
+	})
+	project := `{"contexts": {
+		"project-cmd": {"name": "project-cmd", "label": "Project", "commands": {"run": "true"}, "check": {"command": "touch ran"}},
+		"project-tcp": {"name": "project-tcp", "label": "Project", "commands": {"run": "true"}, "check": {"tcp": "localhost:1"}}
+	}}`
+	if err := os.WriteFile(filepath.Join(".", projectConfigName+".json"), []byte(project), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := loadConfig(cli.configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	executor := NewExecutor(config, nil, nil, nil)
+	m := &model{executor: executor, contexts: executor.listContexts(), health: make(map[string]HealthStatus), checking: make(map[string]bool)}
+
+	if cmds := m.dueChecks(); len(cmds) != 2 {
+		t.Errorf("dueChecks started %d checks, want 2", len(cmds))
+	}
+	var checking []string
+	for name := range m.checking {
+		checking = append(checking, name)
+	}
+	sort.Strings(checking)
+	if len(checking) != 2 || checking[0] != "project-tcp" || checking[1] != "user-cmd" {
+		t.Errorf("checking %v, want project-tcp and user-cmd", checking)
+	}
+	if st, _ := m.jobHealth(config.Contexts["project-cmd"]); st.State != healthUnknown || st.Message == "" {
+		t.Errorf("project-cmd health = %+v, want unknown with a hint", st)
+	}
+}
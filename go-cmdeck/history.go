@@ -0,0 +1,202 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// History stores every execution result as a JSON file under
+// <dir>/<job>/<run-id>.json, separate from the configuration file. Job
+// names are escaped so that they stay one directory below dir.
+type History struct {
+	dir string
+}
+
+// getHistoryDir returns the history directory of the configuration file
+// at configPath (see getDataDir).
+func getHistoryDir(configPath string) string {
+	return filepath.Join(getDataDir(configPath), "history")
+}
+
+func NewHistory(dir string) *History {
+	return &History{dir: dir}
+}
+
+func newRunID(t time.Time) string {
+	b := make([]byte, 3)
+	rand.Read(b)
+	return t.Format("20060102-150405") + "-" + hex.EncodeToString(b)
+}
+
+// parseDuration is time.ParseDuration with an additional "d" (day) unit.
+func parseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n * float64(24*time.Hour)), nil
+	}
+	return time.ParseDuration(s)
+}
+
+func (h *History) record(result *ExecutionResult) error {
+	if result.RunID == "" || result.Job == "" {
+		return fmt.Errorf("execution result has no run id or job")
+	}
+
+	jobDir := h.jobDir(result.Job)
+	if err := os.MkdirAll(jobDir, 0755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(jobDir, result.RunID+".json"), data, 0644)
+}
+
+// jobDir returns the directory of the runs of a job.
+func (h *History) jobDir(job string) string {
+	name := url.PathEscape(job)
+	if name == "." || name == ".." {
+		name = strings.ReplaceAll(name, ".", "%2E")
+	}
+	return filepath.Join(h.dir, name)
+}
+
+// runFiles returns the files of the recorded runs of a job. Runs that
+// older versions stored under the unescaped name are included.
+func (h *History) runFiles(job string) ([]string, error) {
+	dirs := []string{h.jobDir(job)}
+	if legacy := filepath.Join(h.dir, job); legacy != dirs[0] && filepath.Dir(legacy) == h.dir && !strings.ContainsAny(job, `/\*?[`) {
+		dirs = append(dirs, legacy)
+	}
+	var files []string
+	for _, dir := range dirs {
+		matches, err := filepath.Glob(filepath.Join(dir, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// list returns the recorded runs of a job, newest first.
+func (h *History) list(job string) ([]*ExecutionResult, error) {
+	runs, err := h.runs(job)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*ExecutionResult, len(runs))
+	for i, run := range runs {
+		results[i] = run.result
+	}
+	return results, nil
+}
+
+// historyRun is a recorded run and the file it is stored in.
+type historyRun struct {
+	path   string
+	result *ExecutionResult
+}
+
+// runs returns the recorded runs of a job with their files, newest
+// first.
+func (h *History) runs(job string) ([]historyRun, error) {
+	files, err := h.runFiles(job)
+	if err != nil {
+		return nil, err
+	}
+
+	runs := make([]historyRun, 0, len(files))
+	for _, file := range files {
+		result, err := readResult(file)
+		if err != nil {
+			continue
+		}
+		runs = append(runs, historyRun{path: file, result: result})
+	}
+
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].result.Timestamp.After(runs[j].result.Timestamp)
+	})
+
+	return runs, nil
+}
+
+func (h *History) find(runID string) (*ExecutionResult, error) {
+	if runID == "" || strings.ContainsAny(runID, `/\*?[`) {
+		return nil, fmt.Errorf("invalid run id '%s'", runID)
+	}
+
+	files, err := filepath.Glob(filepath.Join(h.dir, "*", runID+".json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("run '%s' not found", runID)
+	}
+
+	return readResult(files[0])
+}
+
+// prune applies the retention policy to the runs of a job.
+func (h *History) prune(job string, policy HistoryConfig) error {
+	maxRuns := policy.MaxRuns
+	if maxRuns == 0 {
+		maxRuns = defaultHistoryMaxRuns
+	}
+
+	var maxAge time.Duration
+	if policy.MaxAge != "" {
+		age, err := parseDuration(policy.MaxAge)
+		if err != nil {
+			return fmt.Errorf("history.max_age: %w", err)
+		}
+		maxAge = age
+	}
+
+	runs, err := h.runs(job)
+	if err != nil {
+		return err
+	}
+
+	for i, run := range runs {
+		expired := maxAge > 0 && time.Since(run.result.Timestamp) > maxAge
+		overflow := maxRuns > 0 && i >= maxRuns
+		if !expired && !overflow {
+			continue
+		}
+		if err := os.Remove(run.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readResult(path string) (*ExecutionResult, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var result ExecutionResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistoryKeepsRunsInsideItsDirectory(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "config", "history")
+	h := NewHistory(dir)
+
+	for _, job := range []string{"../../escape", "..", ".", `a\b`, "a/b", "with space"} {
+		result := &ExecutionResult{RunID: newRunID(time.Now()), Job: job, Timestamp: time.Now()}
+		if err := h.record(result); err != nil {
+			t.Fatalf("record %q: %v", job, err)
+		}
+		runs, err := h.list(job)
+		if err != nil || len(runs) != 1 || runs[0].RunID != result.RunID {
+			t.Fatalf("list %q = %v, %v; want the recorded run", job, runs, err)
+		}
+		if err := h.prune(job, HistoryConfig{MaxAge: "1ns"}); err != nil {
+			t.Fatalf("prune %q: %v", job, err)
+		}
+		if runs, _ := h.list(job); len(runs) != 0 {
+			t.Fatalf("prune %q left %d runs", job, len(runs))
+		}
+	}
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err == nil && path != root && !strings.HasPrefix(path, dir) && !strings.HasPrefix(dir, path) {
+			t.Errorf("history wrote %s outside %s", path, dir)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResultDurationJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want time.Duration
+	}{
+		{"milliseconds", `{"duration_ms":1500,"attempts":[{"attempt":1,"duration_ms":1500}]}`, 1500 * time.Millisecond},
+		{"nanoseconds of older versions", `{"duration":1500000000,"attempts":[{"attempt":1,"duration":1500000000}]}`, 1500 * time.Millisecond},
+		{"missing", `{"attempts":[{"attempt":1}]}`, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var result ExecutionResult
+			if err := json.Unmarshal([]byte(tt.json), &result); err != nil {
+				t.Fatal(err)
+			}
+			if result.Duration != tt.want || result.Attempts[0].Duration != tt.want {
+				t.Errorf("durations = %s, %s; want %s", result.Duration, result.Attempts[0].Duration, tt.want)
+			}
+		})
+	}
+
+	data, err := json.Marshal(&ExecutionResult{Duration: 1500 * time.Millisecond, Attempts: []Attempt{{Attempt: 1, Duration: 2 * time.Second}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data); !strings.Contains(got, `"duration_ms":1500`) || !strings.Contains(got, `"duration_ms":2000`) || strings.Contains(got, `"duration"`) {
+		t.Errorf("marshalled %s, want durations in duration_ms only", got)
+	}
+}
+
+func TestHistoryReadsUnescapedLegacyDirectories(t *testing.T) {
+	dir := t.TempDir()
+	h := NewHistory(dir)
+	result := &ExecutionResult{RunID: newRunID(time.Now()), Job: "with space", Timestamp: time.Now()}
+
+	if err := os.MkdirAll(filepath.Join(dir, "with space"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "with space", result.RunID+".json"), []byte(`{"run_id":"`+result.RunID+`","job":"with space"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runs, err := h.list("with space")
+	if err != nil || len(runs) != 1 {
+		t.Fatalf("list = %v, %v; want the legacy run", runs, err)
+	}
+	if _, err := h.find(result.RunID); err != nil {
+		t.Fatalf("find: %v", err)
+	}
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/cursor"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+)
+
+// What a job form does.
+const (
+	jobFormNew = iota
+	jobFormEdit
+	jobFormDuplicate
+)
+
+// Kinds of the fields of a job form.
+const (
+	fieldName = iota
+	fieldLabel
+	fieldDescription
+	fieldTags
+	fieldCommand
+	fieldVariable
+)
+
+var fieldTitles = map[int]string{
+	fieldName:        "Name",
+	fieldLabel:       "Label",
+	fieldDescription: "Description",
+	fieldTags:        "Tags",
+}
+
+// formField is a line of a job form. A command with line breaks cannot
+// be edited on one line; it is shown locked and kept as it is.
+type formField struct {
+	kind   int
+	input  textinput.Model
+	locked string
+}
+
+// jobForm creates, edits or duplicates a job in the TUI. Commands and
+// variables are entered as action=command and KEY=VALUE, one per field;
+// the last field of each is empty to add another one, and emptying a
+// field removes its entry. Fields of the job the form does not show,
+// such as secrets and dependencies, are kept.
+type jobForm struct {
+	mode     int
+	original string
+	base     Context
+	fields   []formField
+	cursor   int
+	err      string
+}
+
+// newJobForm returns a form for base: an empty job for jobFormNew, or
+// the job to edit or duplicate.
+func newJobForm(mode int, base Context, names map[string]Context) *jobForm {
+	form := &jobForm{mode: mode, original: base.Name, base: base}
+	switch mode {
+	case jobFormNew:
+		form.base.Commands = map[string]string{defaultAction: ""}
+	case jobFormDuplicate:
+		form.base.Name = copyName(base.Name, names)
+		form.base.Label = base.Label + " (copy)"
+		form.base.LastResult = nil
+		form.base.ActionResults = nil
+	}
+
+	if mode != jobFormEdit {
+		form.add(fieldName, form.base.Name)
+	}
+	form.add(fieldLabel, form.base.Label)
+	form.add(fieldDescription, form.base.Description)
+	form.add(fieldTags, strings.Join(form.base.Tags, ", "))
+	for _, action := range form.base.actions() {
+		command := form.base.Commands[action]
+		form.add(fieldCommand, action+"="+command)
+		if strings.Contains(command, "\n") {
+			form.fields[len(form.fields)-1].locked = command
+		}
+	}
+	form.add(fieldCommand, "")
+	for _, key := range sortedKeys(form.base.Variables) {
+		form.add(fieldVariable, key+"="+form.base.Variables[key])
+	}
+	form.add(fieldVariable, "")
+
+	if mode == jobFormNew && len(form.fields) > 0 {
+		form.fields[0].input.Focus()
+	} else {
+		// The label is the field usually changed first.
+		for i, field := range form.fields {
+			if field.kind == fieldLabel {
+				form.cursor = i
+				form.fields[i].input.Focus()
+			}
+		}
+	}
+	return form
+}
+
+// copyName returns a name for a copy of the job that no job has yet.
+func copyName(name string, names map[string]Context) string {
+	candidate := name + "-copy"
+	for i := 2; ; i++ {
+		if _, exists := names[candidate]; !exists {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s-copy%d", name, i)
+	}
+}
+
+func (f *jobForm) add(kind int, value string) {
+	input := textinput.New()
+	input.Prompt = ""
+	input.Cursor.SetMode(cursor.CursorStatic)
+	input.SetValue(strings.ReplaceAll(value, "\n", " "))
+	f.fields = append(f.fields, formField{kind: kind, input: input})
+}
+
+// insert adds an empty field of kind after field i.
+func (f *jobForm) insert(i, kind int) {
+	f.add(kind, "")
+	field := f.fields[len(f.fields)-1]
+	copy(f.fields[i+2:], f.fields[i+1:len(f.fields)-1])
+	f.fields[i+1] = field
+}
+
+// move puts the cursor on another field.
+func (f *jobForm) move(delta int) {
+	next := f.cursor + delta
+	if next < 0 || next >= len(f.fields) {
+		return
+	}
+	f.fields[f.cursor].input.Blur()
+	f.cursor = next
+	f.fields[f.cursor].input.Focus()
+}
+
+// job returns the job as entered in the form.
+func (f *jobForm) job() (Context, error) {
+	job := f.base
+	job.Tags = nil
+	job.Commands = make(map[string]string)
+	job.Variables = make(map[string]string)
+	for _, field := range f.fields {
+		value := strings.TrimSpace(field.input.Value())
+		switch field.kind {
+		case fieldName:
+			job.Name = value
+		case fieldLabel:
+			job.Label = value
+		case fieldDescription:
+			job.Description = value
+		case fieldTags:
+			for _, tag := range strings.Split(value, ",") {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					job.Tags = append(job.Tags, tag)
+				}
+			}
+		case fieldCommand, fieldVariable:
+			if value == "" {
+				continue
+			}
+			key, entry, found := strings.Cut(value, "=")
+			key = strings.TrimSpace(key)
+			entries, what, format := job.Commands, "action", "action=command"
+			if field.kind == fieldVariable {
+				entries, what, format = job.Variables, "variable", "KEY=VALUE"
+			}
+			if !found || key == "" {
+				return job, fmt.Errorf("invalid entry %q (expected %s)", value, format)
+			}
+			if _, exists := entries[key]; exists {
+				return job, fmt.Errorf("duplicate %s '%s'", what, key)
+			}
+			entries[key] = strings.TrimSpace(entry)
+			if field.locked != "" {
+				entries[key] = field.locked
+			}
+		}
+	}
+
+	if job.Name == "" || job.Label == "" {
+		return job, fmt.Errorf("name and label are required")
+	}
+	if err := job.checkTags(); err != nil {
+		return job, err
+	}
+	results := make(map[string]*ExecutionResult)
+	for action, result := range job.ActionResults {
+		if _, exists := job.Commands[action]; exists {
+			results[action] = result
+		}
+	}
+	job.ActionResults = results
+	return job, nil
+}
+
+// openJobForm opens a form to create a job, or to edit or duplicate the
+// job under the cursor.
+func (m *model) openJobForm(mode int) {
+	var base Context
+	if mode != jobFormNew {
+		i, ok := m.currentJob()
+		if !ok {
+			return
+		}
+		base = m.executor.config.Contexts[m.contexts[i].Name]
+	}
+	m.jobForm = newJobForm(mode, base, m.executor.config.Contexts)
+}
+
+// saveJobForm stores the job of the form. New jobs go to the user
+// configuration, copies to the file of the job they were copied from.
+// If the configuration cannot be saved, it is left as it was.
+func (m *model) saveJobForm() {
+	form := m.jobForm
+	job, err := form.job()
+	if err != nil {
+		form.err = err.Error()
+		return
+	}
+	config := m.executor.config
+	old, existed := config.Contexts[job.Name]
+	if existed && form.mode != jobFormEdit {
+		form.err = fmt.Sprintf("job '%s' already exists", job.Name)
+		return
+	}
+
+	config.Contexts[job.Name] = job
+	switch form.mode {
+	case jobFormNew:
+		config.setSource(job.Name, config.userLayer())
+	case jobFormDuplicate:
+		config.setSource(job.Name, config.source(form.original))
+	}
+	if err := config.save(); err != nil {
+		if existed {
+			config.Contexts[job.Name] = old
+		} else {
+			delete(config.Contexts, job.Name)
+			delete(config.sources, job.Name)
+		}
+		form.err = fmt.Sprintf("Error: %v", err)
+		return
+	}
+
+	m.jobForm = nil
+	if form.mode == jobFormEdit {
+		m.statusMessage = fmt.Sprintf("Updated job: %s", job.Name)
+	} else {
+		m.statusMessage = fmt.Sprintf("Added job: %s (%s)", job.Name, config.source(job.Name).Path)
+	}
+	m.refreshContexts()
+	m.selectJob(job.Name)
+}
+
+// updateJobForm handles keys while a job form is open. Other keys than
+// those of the form go to the text field under the cursor.
+func (m *model) updateJobForm(msg tea.KeyMsg) tea.Cmd {
+	form := m.jobForm
+	form.err = ""
+	switch msg.String() {
+	case "ctrl+c":
+		return m.quit()
+	case "esc":
+		m.jobForm = nil
+	case "up", "shift+tab":
+		form.move(-1)
+	case "down", "tab":
+		form.move(1)
+	case "enter", "ctrl+s":
+		m.saveJobForm()
+	default:
+		field := &form.fields[form.cursor]
+		if field.locked != "" {
+			return nil
+		}
+		var cmd tea.Cmd
+		field.input, cmd = field.input.Update(msg)
+		// Typing into the empty last command or variable adds the next
+		// empty one.
+		kind := field.kind
+		last := form.cursor == len(form.fields)-1 || form.fields[form.cursor+1].kind != kind
+		if (kind == fieldCommand || kind == fieldVariable) && last && field.input.Value() != "" {
+			form.insert(form.cursor, kind)
+		}
+		return cmd
+	}
+	return nil
+}
+
+// jobFormView renders the job form full-screen.
+func (m *model) jobFormView() string {
+	form := m.jobForm
+	titleStyle, selectedStyle, _, _, outputTitleStyle := getStyles(m.theme, m.width, m.height)
+
+	var title string
+	switch form.mode {
+	case jobFormNew:
+		title = fmt.Sprintf("New job (%s)", m.executor.config.userLayer().Path)
+	case jobFormEdit:
+		title = fmt.Sprintf("Edit job %s (%s)", form.original, m.executor.config.source(form.original).Path)
+	case jobFormDuplicate:
+		title = fmt.Sprintf("Duplicate job %s (%s)", form.original, m.executor.config.source(form.original).Path)
+	}
+
+	const labelWidth = 14
+	var lines []string
+	cursorLine := 0
+	for i, field := range form.fields {
+		if i == 0 || field.kind != form.fields[i-1].kind {
+			switch field.kind {
+			case fieldCommand:
+				lines = append(lines, "", outputTitleStyle.Render("Commands")+" (action=command; run is the default action)")
+			case fieldVariable:
+				lines = append(lines, "", outputTitleStyle.Render("Variables")+" (KEY=VALUE)")
+			}
+		}
+
+		name := fieldTitles[field.kind]
+		if name != "" {
+			name += ":"
+		}
+		cursor := " "
+		if i == form.cursor {
+			cursor = ">"
+			cursorLine = len(lines)
+		}
+		prefix := fmt.Sprintf("%s %-*s", cursor, labelWidth, name)
+		input := field.input
+		input.Width = max(m.width-len(prefix)-2, 10)
+		value := input.View()
+		switch {
+		case field.locked != "":
+			value = field.input.Value() + "  (multi-line; edit the configuration file)"
+		case field.kind == fieldTags && i != form.cursor && input.Value() == "":
+			value = "(comma-separated)"
+		case (field.kind == fieldCommand || field.kind == fieldVariable) && i != form.cursor && input.Value() == "":
+			value = "(add)"
+		}
+		line := prefix + value
+		if i == form.cursor {
+			line = selectedStyle.Render(prefix) + value
+		}
+		lines = append(lines, ansi.Truncate(line, m.width, "..."))
+	}
+
+	// Keep the field under the cursor on the screen.
+	height := max(m.height-5, 1)
+	start := 0
+	if len(lines) > height {
+		start = min(max(cursorLine-height/2, 0), len(lines)-height)
+		lines = lines[start : start+height]
+	}
+
+	footer := "enter: save • tab/↑/↓: field • esc: cancel"
+	if form.err != "" {
+		footer = form.err + "\n" + footer
+	}
+	return lipgloss.JoinVertical(lipgloss.Left,
+		titleStyle.Render(title),
+		"",
+		strings.Join(lines, "\n"),
+		"",
+		footer)
+}
+
+// requestDelete asks to confirm deleting the job under the cursor.
+func (m *model) requestDelete() {
+	i, ok := m.currentJob()
+	if !ok {
+		return
+	}
+	name := m.contexts[i].Name
+	if _, isRunning := m.runningJob(name); isRunning {
+		m.statusMessage = fmt.Sprintf("Error: job '%s' is running", name)
+		return
+	}
+
+	var dependents []string
+	for _, job := range m.contexts {
+		for _, dep := range job.DependsOn {
+			if dep == name {
+				dependents = append(dependents, job.Name)
+			}
+		}
+	}
+	sort.Strings(dependents)
+
+	m.deleting = name
+	m.statusMessage = fmt.Sprintf("Delete job '%s' from %s? (y/n)", name, m.executor.config.source(name).Path)
+	if len(dependents) > 0 {
+		m.statusMessage = fmt.Sprintf("Delete job '%s' from %s? %s depend on it (y/n)",
+			name, m.executor.config.source(name).Path, strings.Join(dependents, ", "))
+	}
+}
+
+// updateDelete handles the answer to the delete confirmation; any key
+// other than y keeps the job.
+func (m *model) updateDelete(msg tea.KeyMsg) tea.Cmd {
+	name := m.deleting
+	m.deleting = ""
+	switch msg.String() {
+	case "ctrl+c":
+		return m.quit()
+	case "y", "Y":
+	default:
+		m.statusMessage = ""
+		return nil
+	}
+
+	config := m.executor.config
+	source := config.source(name)
+	shadowed := config.shadowed(name)
+	if err := config.remove(name); err != nil {
+		m.statusMessage = fmt.Sprintf("Error: %v", err)
+		return nil
+	}
+
+	m.statusMessage = fmt.Sprintf("Removed job: %s (%s)", name, source.Path)
+	if len(shadowed) > 0 {
+		m.statusMessage += fmt.Sprintf("; it is still defined in %s", shadowed[len(shadowed)-1].Path)
+	}
+	m.refreshContexts()
+	return nil
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// projectConfigName is the per-project configuration file that is
+// looked up from the working directory upwards, with any of the
+// configExtensions.
+const projectConfigName = ".cmdeck"
+
+// configEnvVar selects the user configuration file instead of
+// ~/.config/go-cmdeck/config.json.
+const configEnvVar = "CMDECK_CONFIG"
+
+// Kinds of configuration layers.
+const (
+	layerUser    = "user"
+	layerEnv     = "env"
+	layerFlag    = "flag"
+	layerProject = "project"
+)
+
+// configLayer is one configuration file that is part of the merged
+// configuration. file holds the contents as last read or written and
+// doc the document it was read from.
+type configLayer struct {
+	Path   string `json:"path"`
+	Kind   string `json:"kind"`
+	Exists bool   `json:"exists"`
+	file   *Config
+	doc    *configDocument
+}
+
+// extractConfigFlag removes the global --config option from the command
+// line and returns its value.
+func extractConfigFlag(args []string) (string, []string, error) {
+	path := ""
+	rest := make([]string, 0, len(args))
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--config":
+			if i+1 >= len(args) {
+				return "", nil, fmt.Errorf("%s requires a file", arg)
+			}
+			path = args[i+1]
+			i++
+		case strings.HasPrefix(arg, "--config="):
+			path = strings.TrimPrefix(arg, "--config=")
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	return path, rest, nil
+}
+
+// userConfigLayer returns the base layer: the --config file, the file
+// named by CMDECK_CONFIG or ~/.config/go-cmdeck/config.json. When
+// config.json does not exist, config.yaml, config.yml or config.toml is
+// used instead.
+func userConfigLayer(flagPath string) (*configLayer, error) {
+	if flagPath != "" {
+		path, err := filepath.Abs(flagPath)
+		return &configLayer{Path: path, Kind: layerFlag}, err
+	}
+	if envPath := os.Getenv(configEnvVar); envPath != "" {
+		path, err := filepath.Abs(envPath)
+		return &configLayer{Path: path, Kind: layerEnv}, err
+	}
+	path, err := getConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	if found := findConfigFile(strings.TrimSuffix(path, filepath.Ext(path))); found != "" {
+		path = found
+	}
+	return &configLayer{Path: path, Kind: layerUser}, nil
+}
+
+// findConfigFile returns the first existing file named base plus one of
+// the configExtensions, or "" if there is none.
+func findConfigFile(base string) string {
+	for _, ext := range configExtensions {
+		if info, err := os.Stat(base + ext); err == nil && !info.IsDir() {
+			return base + ext
+		}
+	}
+	return ""
+}
+
+// configLayers returns the layers of the configuration, lowest priority
+// first: the user layer (see userConfigLayer) and the project files found
+// from the working directory upwards. The files are not read yet.
+func configLayers(flagPath string) ([]*configLayer, error) {
+	user, err := userConfigLayer(flagPath)
+	if err != nil {
+		return nil, err
+	}
+	layers := []*configLayer{user}
+
+	if dir, err := os.Getwd(); err == nil {
+		for _, path := range findProjectConfigs(dir) {
+			if path != user.Path {
+				layers = append(layers, &configLayer{Path: path, Kind: layerProject})
+			}
+		}
+	}
+	return layers, nil
+}
+
+// findProjectConfigs returns the project files in dir and its parents,
+// outermost first. Each directory contributes at most one file.
+func findProjectConfigs(dir string) []string {
+	var paths []string
+	for {
+		if found := findConfigFile(filepath.Join(dir, projectConfigName)); found != "" {
+			paths = append([]string{found}, paths...)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return paths
+		}
+		dir = parent
+	}
+}
+
+// read loads the layer's file. A missing file is an empty layer. Jobs
+// without a name are named after their key.
+func (l *configLayer) read() error {
+	data, err := os.ReadFile(l.Path)
+	if os.IsNotExist(err) {
+		l.file = &Config{Contexts: make(map[string]Context)}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	l.doc, err = parseConfigDocument(l.Path, data)
+	if err != nil {
+		return err
+	}
+	file, err := l.doc.decode()
+	if err != nil {
+		return err
+	}
+	for name, job := range file.Contexts {
+		if job.Name == "" {
+			job.Name = name
+			file.Contexts[name] = job
+		}
+	}
+	l.Exists = true
+	l.file = file
+	return nil
+}
+
+// merge applies a layer on top of the configuration. Jobs replace jobs
+// of the same name as a whole; settings replace only the fields the
+// layer sets.
+func (c *Config) merge(layer *configLayer) {
+	c.layers = append(c.layers, layer)
+	for name, job := range layer.file.Contexts {
+		c.Contexts[name] = job.clone()
+		c.sources[name] = layer
+	}
+
+	theme := layer.file.Theme
+	for _, field := range []struct {
+		dst *string
+		src string
+	}{
+		{&c.Theme.Title, theme.Title},
+		{&c.Theme.Selected, theme.Selected},
+		{&c.Theme.Border, theme.Border},
+		{&c.Theme.OutputTitle, theme.OutputTitle},
+	} {
+		if field.src != "" {
+			*field.dst = field.src
+		}
+	}
+	if layer.file.History.MaxRuns != 0 {
+		c.History.MaxRuns = layer.file.History.MaxRuns
+	}
+	if layer.file.History.MaxAge != "" {
+		c.History.MaxAge = layer.file.History.MaxAge
+	}
+	if layer.file.DefaultTimeout != "" {
+		c.DefaultTimeout = layer.file.DefaultTimeout
+	}
+	if layer.file.MaxParallel != 0 {
+		c.MaxParallel = layer.file.MaxParallel
+	}
+	if layer.file.Notify != nil {
+		// An empty list turns off the notifications of lower layers.
+		c.Notify = layer.file.Notify
+	}
+}
+
+// userLayer returns the base layer, where new jobs are stored.
+func (c *Config) userLayer() *configLayer {
+	return c.layers[0]
+}
+
+// projectLayer returns the innermost project layer, creating
+// .cmdeck.json in the working directory if there is none.
+func (c *Config) projectLayer() (*configLayer, error) {
+	for i := len(c.layers) - 1; i > 0; i-- {
+		if c.layers[i].Kind == layerProject {
+			return c.layers[i], nil
+		}
+	}
+
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	layer := &configLayer{
+		Path: filepath.Join(dir, projectConfigName+".json"),
+		Kind: layerProject,
+		file: &Config{Contexts: make(map[string]Context)},
+	}
+	c.layers = append(c.layers, layer)
+	return layer, nil
+}
+
+// setSource records the layer a new job is saved to.
+func (c *Config) setSource(name string, layer *configLayer) {
+	c.sources[name] = layer
+}
+
+// source returns the layer a job is defined in.
+func (c *Config) source(name string) *configLayer {
+	if layer, exists := c.sources[name]; exists {
+		return layer
+	}
+	return c.userLayer()
+}
+
+// shadowed returns the lower-priority layers that also define the job.
+func (c *Config) shadowed(name string) []*configLayer {
+	winner := c.source(name)
+	var layers []*configLayer
+	for _, layer := range c.layers {
+		if _, exists := layer.file.Contexts[name]; exists && layer != winner {
+			layers = append(layers, layer)
+		}
+	}
+	return layers
+}
+
+// remove deletes the job from the file it comes from and saves the
+// configuration. A job of the same name from a lower-priority file
+// takes its place, as it would when the files are loaded again.
+func (c *Config) remove(name string) error {
+	shadowed := c.shadowed(name)
+	old := c.Contexts[name]
+	delete(c.Contexts, name)
+	if err := c.save(); err != nil {
+		c.Contexts[name] = old
+		return err
+	}
+
+	if _, exists := c.Contexts[name]; !exists && len(shadowed) > 0 {
+		lower := shadowed[len(shadowed)-1]
+		job := lower.file.Contexts[name].clone()
+		job.LastResult, job.ActionResults = old.LastResult, old.ActionResults
+		c.Contexts[name] = job
+		c.sources[name] = lower
+	}
+	return nil
+}
+
+// layerContents returns what the layer's file should contain: its own
+// settings and, for every job it defines, the current definition. Run
+// results belong to the state file and are left out.
+func (c *Config) layerContents(layer *configLayer) *Config {
+	contents := withoutResults(layer.file)
+
+	for name := range c.Contexts {
+		if _, exists := c.sources[name]; !exists {
+			c.sources[name] = c.userLayer()
+		}
+	}
+	for name, source := range c.sources {
+		if source != layer {
+			continue
+		}
+		job, exists := c.Contexts[name]
+		if !exists {
+			delete(contents.Contexts, name)
+			continue
+		}
+		job.LastResult = nil
+		job.ActionResults = nil
+		contents.Contexts[name] = job
+	}
+	return contents
+}
@@ -1,21 +1,63 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 )
 
 func main() {
-	config, err := loadConfig()
+	configPath, args, err := extractConfigFlag(os.Args)
 	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// validate reports load errors itself, together with the other
+	// problems of the files.
+	_, command, _ := extractOutputFlag(args)
+	validating := len(command) > 1 && command[1] == "validate"
+
+	config, err := loadConfig(configPath)
+	if err != nil && !validating {
 		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Run 'go-cmdeck validate' to see all problems.\n")
 		os.Exit(1)
 	}
+	if !validating {
+		if problems := config.validate(); len(problems) > 0 {
+			fmt.Fprintf(os.Stderr, "Warning: %v", problems[0])
+			if len(problems) > 1 {
+				fmt.Fprintf(os.Stderr, " (and %d more problems)", len(problems)-1)
+			}
+			fmt.Fprintf(os.Stderr, "; run 'go-cmdeck validate' for details\n")
+		}
+	}
 
-	executor := NewExecutor(config)
-	cli := NewCLI(executor)
+	// Results, state and services belong to the configuration file, so
+	// that decks selected with --config or CMDECK_CONFIG keep their own.
+	user, err := userConfigLayer(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error locating config: %v\n", err)
+		os.Exit(1)
+	}
+
+	executor := NewExecutor(config, NewHistory(getHistoryDir(user.Path)), NewStateStore(getStatePath(user.Path)), NewServices(getServicesDir(user.Path), configPath))
+	if config != nil {
+		if err := executor.loadState(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}
+	cli := NewCLI(executor, configPath)
 
-	if err := cli.Run(os.Args); err != nil {
+	if err := cli.Run(args); err != nil {
+		var exitErr *exitError
+		if errors.As(err, &exitErr) {
+			if exitErr.err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", exitErr.err)
+			}
+			os.Exit(exitErr.code)
+		}
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
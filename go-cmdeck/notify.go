@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Notification tells about a finished run. Exactly one of the channels
+// is set: Desktop shows a desktop notification with notify-send, Bell
+// rings the terminal bell, Webhook POSTs the ExecutionResult as JSON to
+// a URL and Command runs a shell command with the result in CMDECK_*
+// environment variables and as JSON on its standard input. On selects
+// the runs: failure, success or always (the default).
+type Notification struct {
+	Desktop bool   `json:"desktop,omitempty"`
+	Bell    bool   `json:"bell,omitempty"`
+	Webhook string `json:"webhook,omitempty"`
+	Command string `json:"command,omitempty"`
+	On      string `json:"on,omitempty"`
+}
+
+// Runs a notification is sent for.
+const (
+	notifyAlways  = "always"
+	notifyFailure = "failure"
+	notifySuccess = "success"
+)
+
+// notifyTimeout limits webhooks and hook commands.
+var notifyTimeout = 10 * time.Second
+
+// channel returns the kind and the target of the notification.
+func (n Notification) channel() (string, string) {
+	switch {
+	case n.Desktop:
+		return "desktop", ""
+	case n.Bell:
+		return "bell", ""
+	case n.Webhook != "":
+		return "webhook", n.Webhook
+	case n.Command != "":
+		return "command", n.Command
+	}
+	return "", ""
+}
+
+func (n Notification) String() string {
+	kind, target := n.channel()
+	if target != "" {
+		kind += " " + target
+	}
+	return kind + " (on " + n.on() + ")"
+}
+
+// parseNotification parses a notification as given on the command line:
+// desktop, bell, an http(s):// URL or cmd:COMMAND.
+func parseNotification(spec string) (Notification, error) {
+	kind, ref, _ := strings.Cut(spec, ":")
+	switch {
+	case spec == "desktop":
+		return Notification{Desktop: true}, nil
+	case spec == "bell":
+		return Notification{Bell: true}, nil
+	case (kind == "http" || kind == "https") && ref != "":
+		return Notification{Webhook: spec}, nil
+	case kind == "cmd" && ref != "":
+		return Notification{Command: ref}, nil
+	}
+	return Notification{}, fmt.Errorf("expected desktop, bell, http(s)://URL or cmd:COMMAND, got %q", spec)
+}
+
+func (n Notification) check() error {
+	set := 0
+	for _, channel := range []bool{n.Desktop, n.Bell, n.Webhook != "", n.Command != ""} {
+		if channel {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("set exactly one of desktop, bell, webhook and command")
+	}
+	if err := checkNotifyPolicy(n.On); err != nil {
+		return err
+	}
+	if n.Webhook != "" && !strings.HasPrefix(n.Webhook, "http://") && !strings.HasPrefix(n.Webhook, "https://") {
+		return fmt.Errorf("invalid webhook %q (expected an http or https URL)", n.Webhook)
+	}
+	return nil
+}
+
+func checkNotifyPolicy(on string) error {
+	switch on {
+	case "", notifyAlways, notifyFailure, notifySuccess:
+		return nil
+	}
+	return fmt.Errorf("unknown notify policy %q (expected %s, %s or %s)", on, notifyFailure, notifySuccess, notifyAlways)
+}
+
+// checkNotifications reports notifications that cannot work.
+func checkNotifications(notifications []Notification) error {
+	for i, n := range notifications {
+		if err := n.check(); err != nil {
+			return fmt.Errorf("notification %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// checkNotify reports notifications of a job that cannot work.
+func (c Context) checkNotify() error {
+	if err := checkNotifications(c.Notify); err != nil {
+		return fmt.Errorf("job '%s': notify: %w", c.Name, err)
+	}
+	return nil
+}
+
+func (n Notification) on() string {
+	if n.On == "" {
+		return notifyAlways
+	}
+	return n.On
+}
+
+// matches reports whether the notification is sent for the result.
+// Timed out and cancelled runs count as failures.
+func (n Notification) matches(result *ExecutionResult) bool {
+	switch n.on() {
+	case notifyFailure:
+		return !result.Success
+	case notifySuccess:
+		return result.Success
+	}
+	return true
+}
+
+// notify sends the global notifications and those of the job about a
+// finished run. It waits until they are sent; failed notifications are
+// reported together.
+func (e *Executor) notify(result *ExecutionResult) error {
+	notifications, label := e.notifications(result)
+	return sendNotifications(notifications, label, result)
+}
+
+// notifications returns the global notifications and those of the job
+// of a result, with the label of the job.
+func (e *Executor) notifications(result *ExecutionResult) ([]Notification, string) {
+	job := e.config.Contexts[result.Job]
+	label := job.Label
+	if label == "" {
+		label = result.Job
+	}
+	return append(append([]Notification{}, e.config.Notify...), job.Notify...), label
+}
+
+// sendNotifications sends those of the notifications that match the
+// result. It does not use the configuration, so it may run in the
+// background.
+func sendNotifications(notifications []Notification, label string, result *ExecutionResult) error {
+	var errs []error
+	for _, n := range notifications {
+		if !n.matches(result) {
+			continue
+		}
+		kind, _ := n.channel()
+		err := n.check()
+		if err == nil {
+			switch kind {
+			case "desktop":
+				err = notifyDesktop(label, result)
+			case "bell":
+				_, err = os.Stderr.WriteString("\a")
+			case "webhook":
+				err = postWebhook(n.Webhook, result)
+			case "command":
+				err = runNotifyCommand(n.Command, label, result)
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s notification of '%s' failed: %w", kind, result.Job, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func notifyDesktop(label string, result *ExecutionResult) error {
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+
+	args := []string{"--app-name", "go-cmdeck"}
+	if !result.Success {
+		args = append(args, "--urgency", "critical")
+	}
+	summary := fmt.Sprintf("%s: %s", label, result.statusLabel())
+	body := fmt.Sprintf("%s finished in %s with exit code %d", result.Action, result.Duration.Round(time.Millisecond), result.ExitCode)
+	output, err := exec.CommandContext(ctx, "notify-send", append(args, summary, body)...).CombinedOutput()
+	if err != nil && len(output) > 0 {
+		return fmt.Errorf("notify-send: %s", strings.TrimSpace(string(output)))
+	}
+	return err
+}
+
+// postWebhook sends the result as JSON and expects a 2xx answer.
+func postWebhook(url string, result *ExecutionResult) error {
+	payload, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "go-cmdeck")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s answered %s", url, resp.Status)
+	}
+	return nil
+}
+
+// runNotifyCommand runs a hook command with the result in its
+// environment and as JSON on its standard input.
+func runNotifyCommand(command, label string, result *ExecutionResult) error {
+	payload, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = append(os.Environ(), resultEnv(label, result)...)
+	cmd.Stdin = bytes.NewReader(payload)
+	reap := setProcessGroup(cmd)
+	output, err := cmd.CombinedOutput()
+	reap()
+
+	detail, _, _ := strings.Cut(strings.TrimSpace(string(output)), "\n")
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return fmt.Errorf("timed out after %s", notifyTimeout)
+	case err != nil && detail != "":
+		return fmt.Errorf("%w: %s", err, detail)
+	}
+	return err
+}
+
+// resultEnv describes a result in CMDECK_* environment variables.
+func resultEnv(label string, result *ExecutionResult) []string {
+	attempts := max(len(result.Attempts), 1)
+	return []string{
+		"CMDECK_JOB=" + result.Job,
+		"CMDECK_LABEL=" + label,
+		"CMDECK_ACTION=" + result.Action,
+		"CMDECK_RUN_ID=" + result.RunID,
+		"CMDECK_STATUS=" + result.status(),
+		"CMDECK_SUCCESS=" + strconv.FormatBool(result.Success),
+		"CMDECK_EXIT_CODE=" + strconv.Itoa(result.ExitCode),
+		"CMDECK_DURATION=" + result.Duration.Round(time.Millisecond).String(),
+		"CMDECK_TRIGGER=" + result.Trigger,
+		"CMDECK_ATTEMPTS=" + strconv.Itoa(attempts),
+	}
+}
+
+// notifyMsg delivers the outcome of notifications sent by the TUI.
+type notifyMsg struct {
+	err error
+}
+
+// notify sends the notifications about a run finished in the TUI in the
+// background.
+func (m *model) notify(result *ExecutionResult) tea.Cmd {
+	notifications, label := m.executor.notifications(result)
+	if len(notifications) == 0 {
+		return nil
+	}
+	return func() tea.Msg {
+		return notifyMsg{err: sendNotifications(notifications, label, result)}
+	}
+}
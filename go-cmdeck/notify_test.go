@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPostWebhook(t *testing.T) {
+	result := &ExecutionResult{
+		RunID:     "20260101-000000-abcdef",
+		Job:       "backup",
+		Action:    "run",
+		Timestamp: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		Duration:  1500 * time.Millisecond,
+		Status:    StatusFailed,
+		ExitCode:  2,
+		Stderr:    "disk full",
+		Attempts:  []Attempt{{Attempt: 1, ExitCode: 2}},
+	}
+
+	var method, contentType string
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, contentType = r.Method, r.Header.Get("Content-Type")
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	if err := postWebhook(server.URL, result); err != nil {
+		t.Fatal(err)
+	}
+	if method != http.MethodPost || contentType != "application/json" {
+		t.Errorf("got %s with Content-Type %q, want POST with application/json", method, contentType)
+	}
+	var received ExecutionResult
+	if err := json.Unmarshal(body, &received); err != nil {
+		t.Fatalf("body %s: %v", body, err)
+	}
+	if !reflect.DeepEqual(&received, result) {
+		t.Errorf("received %+v, want %+v", received, *result)
+	}
+	if !strings.Contains(string(body), `"duration_ms":1500`) {
+		t.Errorf("body %s lacks duration_ms", body)
+	}
+}
+
+func TestPostWebhookFailures(t *testing.T) {
+	for _, status := range []int{http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		}))
+		err := postWebhook(server.URL, &ExecutionResult{Job: "backup"})
+		server.Close()
+		if err == nil || !strings.Contains(err.Error(), http.StatusText(status)) {
+			t.Errorf("status %d: error = %v, want one naming the status", status, err)
+		}
+	}
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	if err := postWebhook(server.URL, &ExecutionResult{Job: "backup"}); err == nil {
+		t.Error("posting to a closed server succeeded")
+	}
+}
+
+func TestRunNotifyCommand(t *testing.T) {
+	dir := t.TempDir()
+	result := &ExecutionResult{RunID: "20260101-000000-abcdef", Job: "backup", Action: "run", Status: StatusFailed, ExitCode: 2, Duration: 1500 * time.Millisecond}
+
+	command := `echo "$CMDECK_JOB $CMDECK_LABEL $CMDECK_STATUS $CMDECK_EXIT_CODE $CMDECK_DURATION $CMDECK_ATTEMPTS" > env; cat > payload`
+	cmd := "cd '" + dir + "' && " + command
+	if err := runNotifyCommand(cmd, "Nightly backup", result); err != nil {
+		t.Fatal(err)
+	}
+	env, err := os.ReadFile(filepath.Join(dir, "env"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "backup Nightly backup failed 2 1.5s 1\n"; string(env) != want {
+		t.Errorf("environment %q, want %q", env, want)
+	}
+	payload, err := os.ReadFile(filepath.Join(dir, "payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var received ExecutionResult
+	if err := json.Unmarshal(payload, &received); err != nil || received.RunID != result.RunID {
+		t.Errorf("standard input %s, want the result as JSON", payload)
+	}
+
+	if err := runNotifyCommand("echo unauthorized >&2; echo more >&2; exit 3", "Backup", result); err == nil || !strings.Contains(err.Error(), "exit status 3: unauthorized") {
+		t.Errorf("failing command: error = %v, want its exit status and first output line", err)
+	}
+}
+
+func TestRunNotifyCommandTimeout(t *testing.T) {
+	timeout := notifyTimeout
+	notifyTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { notifyTimeout = timeout })
+
+	start := time.Now()
+	err := runNotifyCommand("sleep 5", "Backup", &ExecutionResult{Job: "backup"})
+	if err == nil || !strings.Contains(err.Error(), "timed out after 100ms") {
+		t.Errorf("error = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("returned after %s, want soon after the timeout", elapsed)
+	}
+}
+
+func TestSendNotifications(t *testing.T) {
+	dir := t.TempDir()
+	touch := func(name string) Notification {
+		return Notification{Command: "touch '" + filepath.Join(dir, name) + "'"}
+	}
+	onSuccess := touch("on-success")
+	onSuccess.On = notifySuccess
+	failing := Notification{Command: "exit 1", On: notifyFailure}
+	notifications := []Notification{touch("always"), onSuccess, failing, {Webhook: "ftp://example.com/"}}
+
+	err := sendNotifications(notifications, "Backup", &ExecutionResult{Job: "backup", Status: StatusFailed, ExitCode: 1})
+	if err == nil || !strings.Contains(err.Error(), "command notification of 'backup' failed") || !strings.Contains(err.Error(), "webhook notification of 'backup' failed") {
+		t.Errorf("error = %v, want the failures of the command and the webhook", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "always")); err != nil {
+		t.Error("the notification for every run was not sent")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "on-success")); err == nil {
+		t.Error("the notification for successful runs was sent for a failure")
+	}
+}
+
+func TestNotifyFlagWith(t *testing.T) {
+	var f notifyFlag
+	if _, err := f.with(notifyFailure); err == nil || !strings.Contains(err.Error(), "requires -notify") {
+		t.Errorf("-notify-on without -notify: error = %v", err)
+	}
+	if err := f.Set("desktop"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Set("cmd:notify.sh"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.with("never"); err == nil || !strings.Contains(err.Error(), "unknown notify policy") {
+		t.Errorf("-notify-on never: error = %v", err)
+	}
+	notifications, err := f.with(notifyFailure)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range notifications {
+		if n.On != notifyFailure {
+			t.Errorf("notification %+v, want on %s", n, notifyFailure)
+		}
+	}
+	if len(notifications) != 2 || f[0].On != "" {
+		t.Errorf("with returned %+v and changed the flag to %+v", notifications, f)
+	}
+}
+
+func TestNotificationMatches(t *testing.T) {
+	results := map[string]*ExecutionResult{
+		StatusSuccess:    {Success: true, Status: StatusSuccess},
+		StatusFailed:     {Status: StatusFailed, ExitCode: 1},
+		StatusTimeout:    {Status: StatusTimeout, ExitCode: 143},
+		StatusCancelled:  {Status: StatusCancelled, ExitCode: 143},
+		"legacy failure": {ExitCode: 1},
+	}
+	tests := []struct {
+		on   string
+		want map[string]bool
+	}{
+		{"", map[string]bool{StatusSuccess: true, StatusFailed: true, StatusTimeout: true, StatusCancelled: true, "legacy failure": true}},
+		{notifyAlways, map[string]bool{StatusSuccess: true, StatusFailed: true, StatusTimeout: true, StatusCancelled: true, "legacy failure": true}},
+		{notifyFailure, map[string]bool{StatusSuccess: false, StatusFailed: true, StatusTimeout: true, StatusCancelled: true, "legacy failure": true}},
+		{notifySuccess, map[string]bool{StatusSuccess: true, StatusFailed: false, StatusTimeout: false, StatusCancelled: false, "legacy failure": false}},
+	}
+	for _, tt := range tests {
+		n := Notification{Bell: true, On: tt.on}
+		for status, result := range results {
+			if got := n.matches(result); got != tt.want[status] {
+				t.Errorf("on %q, %s run: matches = %v, want %v", tt.on, status, got, tt.want[status])
+			}
+		}
+	}
+}
+
+func TestParseNotification(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    Notification
+		wantErr bool
+	}{
+		{spec: "desktop", want: Notification{Desktop: true}},
+		{spec: "bell", want: Notification{Bell: true}},
+		{spec: "https://hooks.example.com/cmdeck?token=a:b", want: Notification{Webhook: "https://hooks.example.com/cmdeck?token=a:b"}},
+		{spec: "http://localhost:8080/", want: Notification{Webhook: "http://localhost:8080/"}},
+		{spec: "cmd:notify.sh --job $CMDECK_JOB", want: Notification{Command: "notify.sh --job $CMDECK_JOB"}},
+		{spec: "cmd:echo a:b", want: Notification{Command: "echo a:b"}},
+		{spec: "", wantErr: true},
+		{spec: "Desktop", wantErr: true},
+		{spec: "cmd:", wantErr: true},
+		{spec: "https:", wantErr: true},
+		{spec: "ftp://example.com/", wantErr: true},
+		{spec: "hooks.example.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseNotification(tt.spec)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseNotification(%q) = %+v, want an error", tt.spec, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseNotification(%q) = %+v, %v; want %+v", tt.spec, got, err, tt.want)
+		}
+		if err := got.check(); err != nil {
+			t.Errorf("parseNotification(%q) returned an invalid notification: %v", tt.spec, err)
+		}
+	}
+}
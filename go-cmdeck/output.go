@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+// extractOutputFlag removes the global --output/-o option from the
+// command line and returns the requested format. Arguments after "--"
+// belong to the subcommand and are left as they are, "--" included.
+func extractOutputFlag(args []string) (string, []string, error) {
+	format := outputTable
+	rest := make([]string, 0, len(args))
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			rest = append(rest, args[i:]...)
+			i = len(args)
+		case arg == "--output" || arg == "-o":
+			if i+1 >= len(args) {
+				return "", nil, fmt.Errorf("%s requires a value (json, yaml or table)", arg)
+			}
+			format = args[i+1]
+			i++
+		case strings.HasPrefix(arg, "--output="):
+			format = strings.TrimPrefix(arg, "--output=")
+		default:
+			rest = append(rest, arg)
+		}
+	}
+
+	switch format {
+	case outputTable, outputJSON, outputYAML:
+		return format, rest, nil
+	}
+	return "", nil, fmt.Errorf("unknown output format '%s' (use json, yaml or table)", format)
+}
+
+// writeStructured writes v as JSON or YAML. YAML uses the same field
+// names as JSON because it is converted from the JSON encoding.
+func writeStructured(w io.Writer, format string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if format == outputJSON {
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return err
+	}
+
+	// JSON is valid YAML, so decoding it into a node keeps the field
+	// order; only the quoting style needs to be reset.
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return err
+	}
+	resetYAMLStyle(&node)
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(&node); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+func resetYAMLStyle(node *yaml.Node) {
+	node.Style = 0
+	if node.Kind == yaml.ScalarNode && strings.Contains(node.Value, "\n") {
+		node.Style = yaml.LiteralStyle
+	}
+	for _, child := range node.Content {
+		resetYAMLStyle(child)
+	}
+}
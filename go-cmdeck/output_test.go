@@ -0,0 +1,37 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestExtractOutputFlag(t *testing.T) {
+	tests := []struct {
+		args     []string
+		want     string
+		wantRest []string
+		wantErr  bool
+	}{
+		{[]string{"list"}, outputTable, []string{"list"}, false},
+		{[]string{"--output", "json", "run", "build"}, outputJSON, []string{"run", "build"}, false},
+		{[]string{"list", "-o", "yaml"}, outputYAML, []string{"list"}, false},
+		{[]string{"list", "--output=json"}, outputJSON, []string{"list"}, false},
+		{[]string{"run", "build", "-v", "ARGS=-o"}, outputTable, []string{"run", "build", "-v", "ARGS=-o"}, false},
+		{[]string{"run", "build", "--", "-o", "json"}, outputTable, []string{"run", "build", "--", "-o", "json"}, false},
+		{[]string{"-o", "json", "run", "build", "--", "--output=yaml"}, outputJSON, []string{"run", "build", "--", "--output=yaml"}, false},
+		{[]string{"list", "-o"}, "", nil, true},
+		{[]string{"list", "-o", "xml"}, "", nil, true},
+	}
+	for _, tt := range tests {
+		format, rest, err := extractOutputFlag(tt.args)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("extractOutputFlag(%q) = %q, %q; want an error", tt.args, format, rest)
+			}
+			continue
+		}
+		if err != nil || format != tt.want || !slices.Equal(rest, tt.wantRest) {
+			t.Errorf("extractOutputFlag(%q) = %q, %q, %v; want %q, %q", tt.args, format, rest, err, tt.want, tt.wantRest)
+		}
+	}
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aymanbagabas/go-osc52/v2"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+)
+
+// escapePattern matches the escape sequences a command may print. Only
+// colors and text attributes (SGR, ending in "m") are shown by the
+// pager; cursor movement and the like would break the screen.
+var escapePattern = regexp.MustCompile(`\x1b(\[[0-?]*[ -/]*[@-~]|\][^\x07\x1b]*(\x07|\x1b\\)|[@-Z\\-_])`)
+
+// tabWidth is how many spaces a tab in the output is shown as.
+const tabWidth = 4
+
+// outputPager shows the output of a job full-screen: the live output
+// while the job runs, its last result otherwise.
+type outputPager struct {
+	job       string
+	output    string
+	view      viewport.Model
+	wrap      bool
+	follow    bool
+	searching bool
+	input     string
+	search    string
+	matches   []int
+	match     int
+	message   string
+	// stale is set when the job printed output that is not laid out
+	// yet; the pager catches up on the next spinner tick.
+	stale bool
+}
+
+type clipboardMsg struct {
+	size int
+	err  error
+}
+
+// openPager shows the output of the job under the cursor in the pager,
+// or the log of a service.
+func (m *model) openPager() {
+	i, ok := m.currentJob()
+	if !ok {
+		return
+	}
+	m.pager = &outputPager{job: m.contexts[i].Name, wrap: true}
+	m.pager.view = viewport.New(m.width, m.pagerHeight())
+	m.pager.view.SetHorizontalStep(8)
+	_, running := m.runningJob(m.pager.job)
+	st, isService := m.service(m.contexts[i])
+	m.pager.follow = running || isService && st.State != serviceStopped
+	m.refreshPager()
+}
+
+// pagerHeight is the height of the pager's viewport: the screen without
+// its header and footer.
+func (m *model) pagerHeight() int {
+	return max(m.height-2, 1)
+}
+
+// pagerJob returns the job shown in the pager.
+func (m *model) pagerJob() (Context, bool) {
+	for _, job := range m.contexts {
+		if job.Name == m.pager.job {
+			return job, true
+		}
+	}
+	return Context{}, false
+}
+
+// refreshPager loads the current output of the pager's job and lays it
+// out again, for example after new output or a resize.
+func (m *model) refreshPager() {
+	p := m.pager
+	p.stale = false
+	p.output = ""
+	job, exists := m.pagerJob()
+	if run, isRunning := m.runningJob(p.job); isRunning {
+		p.output = strings.Join(run.lines, "\n")
+	} else if exists && job.Service != nil {
+		p.output, _, _ = m.executor.services.tailLog(p.job, 0)
+	} else if exists && job.LastResult != nil {
+		p.output = job.LastResult.Output
+	}
+	p.view.Width = m.width
+	p.view.Height = m.pagerHeight()
+	p.layout(m.matchStyle(lipgloss.NewStyle()), lipgloss.NewStyle().Reverse(true))
+}
+
+// layout splits the output into screen lines, wrapped to the width of
+// the viewport by display width, and highlights the search matches.
+func (p *outputPager) layout(current, match lipgloss.Style) {
+	var lines []string
+	active := ""
+	for _, line := range strings.Split(strings.TrimSuffix(p.output, "\n"), "\n") {
+		line = cleanOutputLine(line)
+		wrapped := []string{line}
+		if p.wrap && p.view.Width > 0 {
+			wrapped = strings.Split(ansi.Hardwrap(line, p.view.Width, true), "\n")
+		}
+		// Colors can span lines; each line starts with the attributes
+		// still active and resets them at its end.
+		for _, part := range wrapped {
+			lines = append(lines, active+part)
+			active = activeStyle(active, part)
+		}
+	}
+
+	p.matches = nil
+	if p.search != "" {
+		pattern := regexp.MustCompile("(?i)" + regexp.QuoteMeta(p.search))
+		for i, line := range lines {
+			found := pattern.FindAllStringIndex(ansi.Strip(line), -1)
+			if len(found) == 0 {
+				continue
+			}
+			style := match
+			if len(p.matches) == p.match {
+				style = current.Reverse(true)
+			}
+			p.matches = append(p.matches, i)
+			lines[i] = highlight(line, found, style)
+		}
+		if p.match >= len(p.matches) {
+			p.match = 0
+		}
+	}
+	for i, line := range lines {
+		if strings.Contains(line, "\x1b") {
+			lines[i] = line + ansi.ResetStyle
+		}
+	}
+
+	p.view.SetContent(strings.Join(lines, "\n"))
+	if p.follow {
+		p.view.GotoBottom()
+	}
+}
+
+// highlight renders the ranges of a line in style. The ranges are byte
+// offsets into the text without escape sequences; the line keeps its own
+// colors around them, and they are restored after every range.
+func highlight(line string, ranges [][]int, style lipgloss.Style) string {
+	start, end, _ := strings.Cut(style.Render("x"), "x")
+	escapes := escapePattern.FindAllStringIndex(line, -1)
+	var b strings.Builder
+	active := ""
+	inside := false
+	for i, text := 0, 0; i < len(line); {
+		if len(escapes) > 0 && escapes[0][0] == i {
+			seq := line[i:escapes[0][1]]
+			active = activeStyle(active, seq)
+			// Inside a range the highlight wins; the sequence takes
+			// effect when the range ends.
+			if !inside {
+				b.WriteString(seq)
+			}
+			i, escapes = escapes[0][1], escapes[1:]
+			continue
+		}
+		if len(ranges) > 0 && text == ranges[0][0] {
+			b.WriteString(start)
+			inside = true
+		}
+		b.WriteByte(line[i])
+		i++
+		text++
+		if inside && text == ranges[0][1] {
+			b.WriteString(end + active)
+			inside = false
+			ranges = ranges[1:]
+		}
+	}
+	return b.String()
+}
+
+// cleanOutputLine prepares a line of output for the screen: only the
+// text after the last carriage return is kept, as a terminal would show
+// it, tabs become spaces and escape sequences other than colors are
+// removed.
+func cleanOutputLine(line string) string {
+	line = strings.TrimSuffix(line, "\r")
+	if i := strings.LastIndex(line, "\r"); i >= 0 {
+		line = line[i+1:]
+	}
+	line = strings.ReplaceAll(line, "\t", strings.Repeat(" ", tabWidth))
+	return escapePattern.ReplaceAllStringFunc(line, func(seq string) string {
+		if strings.HasPrefix(seq, "\x1b[") && strings.HasSuffix(seq, "m") {
+			return seq
+		}
+		return ""
+	})
+}
+
+// activeStyle returns the color sequences still in effect after line,
+// given those active before it.
+func activeStyle(active, line string) string {
+	for _, seq := range escapePattern.FindAllString(line, -1) {
+		if seq == "\x1b[0m" || seq == "\x1b[m" {
+			active = ""
+		} else {
+			active += seq
+		}
+	}
+	return active
+}
+
+// findMatch moves to the next (delta 1) or previous (delta -1) search
+// match and scrolls it into view.
+func (m *model) findMatch(delta int) {
+	p := m.pager
+	if len(p.matches) == 0 {
+		return
+	}
+	p.match = (p.match + delta + len(p.matches)) % len(p.matches)
+	p.follow = false
+	m.refreshPager()
+	line := p.matches[p.match]
+	if line < p.view.YOffset || line >= p.view.YOffset+p.view.Height {
+		p.view.SetYOffset(line - p.view.Height/2)
+	}
+}
+
+// copyOutput copies the output without colors to the clipboard with an
+// OSC 52 escape sequence, which also works over SSH.
+func copyOutput(output string) tea.Cmd {
+	return func() tea.Msg {
+		text := ansi.Strip(output)
+		seq := osc52.New(text)
+		if os.Getenv("TMUX") != "" {
+			seq = seq.Tmux()
+		} else if strings.HasPrefix(os.Getenv("TERM"), "screen") {
+			seq = seq.Screen()
+		}
+		_, err := seq.WriteTo(os.Stdout)
+		return clipboardMsg{size: len(text), err: err}
+	}
+}
+
+// updatePager handles keys while the pager is open.
+func (m *model) updatePager(msg tea.KeyMsg) tea.Cmd {
+	p := m.pager
+	if p.searching {
+		switch msg.Type {
+		case tea.KeyCtrlC:
+			return m.quit()
+		case tea.KeyEsc:
+			p.searching = false
+		case tea.KeyEnter:
+			p.searching = false
+			p.search = p.input
+			p.match = 0
+			m.refreshPager()
+			m.findMatch(0)
+			if p.search != "" && len(p.matches) == 0 {
+				p.message = fmt.Sprintf("Pattern not found: %s", p.search)
+			}
+		case tea.KeyBackspace:
+			if p.input != "" {
+				runes := []rune(p.input)
+				p.input = string(runes[:len(runes)-1])
+			}
+		case tea.KeyRunes, tea.KeySpace:
+			p.input += string(msg.Runes)
+		}
+		return nil
+	}
+
+	p.message = ""
+	switch msg.String() {
+	case "ctrl+c":
+		return m.quit()
+	case "q":
+		m.pager = nil
+	case "esc":
+		if p.search != "" {
+			p.search = ""
+			m.refreshPager()
+		} else {
+			m.pager = nil
+		}
+	case "/":
+		p.searching = true
+		p.input = ""
+	case "n":
+		m.findMatch(1)
+	case "N":
+		m.findMatch(-1)
+	case "g", "home":
+		p.follow = false
+		p.view.GotoTop()
+	case "G", "end":
+		p.follow = true
+		p.view.GotoBottom()
+	case "F":
+		p.follow = !p.follow
+		if p.follow {
+			p.view.GotoBottom()
+		}
+	case "w":
+		p.wrap = !p.wrap
+		p.view.SetXOffset(0)
+		m.refreshPager()
+	case "c":
+		return copyOutput(p.output)
+	default:
+		var cmd tea.Cmd
+		p.view, cmd = p.view.Update(msg)
+		p.follow = p.follow && p.view.AtBottom()
+		return cmd
+	}
+	return nil
+}
+
+// pagerView renders the pager full-screen.
+func (m *model) pagerView() string {
+	p := m.pager
+	_, _, _, _, outputTitleStyle := getStyles(m.theme, m.width, m.height)
+
+	title := p.job
+	status := "no output"
+	if job, exists := m.pagerJob(); exists {
+		title = job.Label
+		service, isService := m.service(job)
+		if run, isRunning := m.runningJob(p.job); isRunning {
+			status = fmt.Sprintf("running %s for %s", run.action, time.Since(run.started).Truncate(time.Second))
+			if run.attempt != "" {
+				status += ", " + run.attempt
+			}
+		} else if isService {
+			status = "service " + service.summary() + ", " + service.Log
+		} else if job.LastResult != nil {
+			status = fmt.Sprintf("%s, exit code %d, %s", job.LastResult.status(), job.LastResult.ExitCode, job.LastResult.Timestamp.Format("2006-01-02 15:04:05"))
+			if job.LastResult.Action != "" {
+				status = job.LastResult.Action + ": " + status
+			}
+		}
+	}
+	header := outputTitleStyle.Render(title) + " (" + status + ")"
+
+	var footer string
+	switch {
+	case p.searching:
+		footer = "/" + p.input + "_"
+	case p.message != "":
+		footer = p.message
+	default:
+		lines := p.view.TotalLineCount()
+		footer = fmt.Sprintf("%d-%d/%d lines", min(p.view.YOffset+1, lines), min(p.view.YOffset+p.view.Height, lines), lines)
+		if p.follow {
+			footer += " • following"
+		}
+		if p.search != "" {
+			footer += fmt.Sprintf(" • %q %d/%d", p.search, min(p.match+1, len(p.matches)), len(p.matches))
+		}
+		footer += " • /: search • n/N: match • F: follow • w: wrap • c: copy • q: close"
+	}
+
+	return lipgloss.JoinVertical(lipgloss.Left,
+		ansi.Truncate(header, m.width, "..."),
+		p.view.View(),
+		ansi.Truncate(footer, m.width, "..."))
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
+)
+
+func TestHighlightKeepsColors(t *testing.T) {
+	profile := lipgloss.ColorProfile()
+	lipgloss.SetColorProfile(termenv.ANSI)
+	t.Cleanup(func() { lipgloss.SetColorProfile(profile) })
+	style := lipgloss.NewStyle().Reverse(true)
+	const (
+		red   = "\x1b[31m"
+		bold  = "\x1b[1m"
+		reset = "\x1b[0m"
+		on    = "\x1b[7m"
+	)
+
+	tests := []struct {
+		name   string
+		line   string
+		ranges [][]int
+		want   string
+	}{
+		{"plain", "an error here", [][]int{{3, 8}}, "an " + on + "error" + reset + " here"},
+		{"inside a color", red + "an error here" + reset, [][]int{{3, 8}}, red + "an " + on + "error" + reset + red + " here" + reset},
+		{"several ranges", red + "err err", [][]int{{0, 3}, {4, 7}}, red + on + "err" + reset + red + " " + on + "err" + reset + red},
+		{"color changing inside the range", "er" + bold + "ror done", [][]int{{0, 5}}, on + "error" + reset + bold + " done"},
+		{"reset inside the range", red + "er" + reset + "ror", [][]int{{0, 5}}, red + on + "error" + reset},
+		{"multi-byte text", red + "日本語 error", [][]int{{10, 15}}, red + "日本語 " + on + "error" + reset + red},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := highlight(tt.line, tt.ranges, style); got != tt.want {
+				t.Errorf("highlight(%q) = %q, want %q", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLayoutHighlightsMatchesInColoredLines(t *testing.T) {
+	p := &outputPager{output: "\x1b[32mok\x1b[0m\n\x1b[31mbuild error\x1b[0m\nerror again\n", search: "ERROR"}
+	p.layout(lipgloss.NewStyle(), lipgloss.NewStyle())
+	if len(p.matches) != 2 || p.matches[0] != 1 || p.matches[1] != 2 {
+		t.Errorf("matches = %v, want lines 1 and 2", p.matches)
+	}
+}
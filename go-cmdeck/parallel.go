@@ -0,0 +1,147 @@
+package main
+
+// defaultMaxParallel is how many jobs the TUI runs at once when
+// max_parallel is not configured.
+const defaultMaxParallel = 4
+
+// maxParallel returns how many jobs the TUI runs at once.
+func (c *Config) maxParallel() int {
+	if c.MaxParallel > 0 {
+		return c.MaxParallel
+	}
+	return defaultMaxParallel
+}
+
+// States of the jobs of a batch run.
+const (
+	batchPending = iota
+	batchRunning
+	batchDone
+	batchSkipped
+)
+
+// batchRun schedules the jobs of a batch run, as returned by planBatch,
+// with at most limit of them running at once. A job becomes ready once
+// all its dependencies in the batch succeeded and is skipped as soon as
+// one of them did not. The jobs are started in their planned order, so
+// a limit of 1 runs them one by one in dependency order.
+type batchRun struct {
+	jobs    []Context
+	limit   int
+	states  []int
+	results []*ExecutionResult
+	index   map[string]int
+}
+
+func newBatchRun(jobs []Context, limit int) *batchRun {
+	b := &batchRun{
+		jobs:    jobs,
+		limit:   limit,
+		states:  make([]int, len(jobs)),
+		results: make([]*ExecutionResult, len(jobs)),
+		index:   make(map[string]int, len(jobs)),
+	}
+	for i, job := range jobs {
+		b.index[job.Name] = i
+	}
+	return b
+}
+
+// next marks the jobs that can start now as running and returns their
+// indexes. Pending jobs with a failed or skipped dependency are marked
+// as skipped.
+func (b *batchRun) next() []int {
+	var started []int
+	running := b.running()
+	for i, job := range b.jobs {
+		if b.states[i] != batchPending {
+			continue
+		}
+		ready := true
+		for _, dep := range job.DependsOn {
+			j, exists := b.index[dep]
+			if !exists {
+				continue
+			}
+			switch {
+			case b.states[j] == batchSkipped || b.states[j] == batchDone && !b.results[j].Success:
+				b.states[i] = batchSkipped
+			case b.states[j] != batchDone:
+				ready = false
+			}
+		}
+		if b.states[i] == batchSkipped || !ready || running >= b.limit {
+			continue
+		}
+		b.states[i] = batchRunning
+		running++
+		started = append(started, i)
+	}
+	return started
+}
+
+// finish records the result of a running job.
+func (b *batchRun) finish(i int, result *ExecutionResult) {
+	b.states[i] = batchDone
+	b.results[i] = result
+}
+
+// skip marks a job that could not be run as skipped.
+func (b *batchRun) skip(i int) {
+	b.states[i] = batchSkipped
+}
+
+// stop skips every job that has not started yet.
+func (b *batchRun) stop() {
+	for i, state := range b.states {
+		if state == batchPending {
+			b.states[i] = batchSkipped
+		}
+	}
+}
+
+func (b *batchRun) running() int {
+	n := 0
+	for _, state := range b.states {
+		if state == batchRunning {
+			n++
+		}
+	}
+	return n
+}
+
+// finished reports whether every job is done or skipped.
+func (b *batchRun) finished() bool {
+	for _, state := range b.states {
+		if state == batchPending || state == batchRunning {
+			return false
+		}
+	}
+	return true
+}
+
+// failed reports whether a job did not succeed or was skipped.
+func (b *batchRun) failed() bool {
+	for i, state := range b.states {
+		if state == batchSkipped || state == batchDone && !b.results[i].Success {
+			return true
+		}
+	}
+	return false
+}
+
+// counts returns how many jobs succeeded, did not succeed and were
+// skipped.
+func (b *batchRun) counts() (succeeded, failed, skipped int) {
+	for i, state := range b.states {
+		switch {
+		case state == batchSkipped:
+			skipped++
+		case state == batchDone && b.results[i].Success:
+			succeeded++
+		case state == batchDone:
+			failed++
+		}
+	}
+	return
+}
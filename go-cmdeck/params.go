@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Param is a job parameter that is filled in when the job is run, from
+// `run -v NAME=VALUE`, a CLI prompt or the TUI form. Its value is
+// available to the commands as ${NAME}. Allowed optionally restricts
+// the value to a fixed list.
+type Param struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Default     string   `json:"default,omitempty"`
+	Required    bool     `json:"required,omitempty"`
+	Allowed     []string `json:"allowed,omitempty"`
+}
+
+// prompt returns the question shown for the parameter.
+func (p Param) prompt() string {
+	question := p.Name
+	if p.Description != "" {
+		question += " - " + p.Description
+	}
+	if len(p.Allowed) > 0 {
+		question += " (" + strings.Join(p.Allowed, ", ") + ")"
+	}
+	return question
+}
+
+// check validates a parameter value.
+func (p Param) check(value string) error {
+	if value == "" {
+		if p.Required {
+			return fmt.Errorf("parameter %s is required", p.Name)
+		}
+		return nil
+	}
+	if len(p.Allowed) > 0 && !slices.Contains(p.Allowed, value) {
+		return fmt.Errorf("invalid value %q for parameter %s (allowed: %s)", value, p.Name, strings.Join(p.Allowed, ", "))
+	}
+	return nil
+}
+
+// resolveParams returns the variable overrides of a run: the given values
+// plus the value of every declared parameter. Parameters without a given
+// value use their default, or the job variable of the same name; a
+// required parameter without any of them is an error and an optional one
+// is empty.
+func (c Context) resolveParams(values map[string]string) (map[string]string, error) {
+	resolved := make(map[string]string, len(values)+len(c.Params))
+	for k, v := range values {
+		resolved[k] = v
+	}
+
+	var missing []string
+	for _, p := range c.Params {
+		value, given := values[p.Name]
+		if !given {
+			value = p.Default
+			if value == "" {
+				value = c.Variables[p.Name]
+			}
+		}
+		if value == "" && p.Required {
+			missing = append(missing, p.Name)
+			continue
+		}
+		if err := p.check(value); err != nil {
+			return nil, err
+		}
+		resolved[p.Name] = value
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing required parameters: %s (set them with -v NAME=VALUE)", strings.Join(missing, ", "))
+	}
+	return resolved, nil
+}
+
+// withValues returns a copy of the job whose variables are overridden by
+// values.
+func (c Context) withValues(values map[string]string) Context {
+	if len(values) == 0 {
+		return c
+	}
+	vars := make(map[string]string, len(c.Variables)+len(values))
+	for k, v := range c.Variables {
+		vars[k] = v
+	}
+	for k, v := range values {
+		vars[k] = v
+	}
+	c.Variables = vars
+	return c
+}
+
+// checkParams reports parameter definitions that cannot work.
+func (c Context) checkParams() error {
+	seen := make(map[string]bool)
+	for _, p := range c.Params {
+		if !variableNamePattern.MatchString(p.Name) {
+			return fmt.Errorf("job '%s': invalid parameter name %q", c.Name, p.Name)
+		}
+		if seen[p.Name] {
+			return fmt.Errorf("job '%s': duplicate parameter %s", c.Name, p.Name)
+		}
+		seen[p.Name] = true
+		if p.Default != "" {
+			if err := p.check(p.Default); err != nil {
+				return fmt.Errorf("job '%s': default: %w", c.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// promptParams asks for the parameters of the job that have neither a
+// given value nor a default, and adds the answers to values.
+func promptParams(p *prompter, job Context, values map[string]string) error {
+	for _, param := range job.Params {
+		if _, given := values[param.Name]; given || param.Default != "" || job.Variables[param.Name] != "" {
+			continue
+		}
+		for {
+			answer, err := p.ask(param.prompt(), "")
+			if err != nil {
+				return err
+			}
+			if err := param.check(answer); err != nil {
+				fmt.Fprintf(p.out, "  %v\n", err)
+				continue
+			}
+			values[param.Name] = answer
+			break
+		}
+	}
+	return nil
+}
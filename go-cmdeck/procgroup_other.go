@@ -0,0 +1,12 @@
+//go:build !unix
+
+package main
+
+import "os/exec"
+
+// setProcessGroup falls back to killing only the shell process on
+// platforms without process groups.
+func setProcessGroup(cmd *exec.Cmd) func() {
+	cmd.WaitDelay = killGracePeriod
+	return func() {}
+}
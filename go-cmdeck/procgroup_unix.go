@@ -0,0 +1,43 @@
+//go:build unix
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// setProcessGroup starts the command in its own process group so that
+// cancellation reaches the children of "sh -c" too: the group gets
+// SIGTERM first and SIGKILL if it is still alive after killGracePeriod.
+// The returned function must be called after the command has been
+// waited for; it blocks until a terminated group is gone.
+func setProcessGroup(cmd *exec.Cmd) func() {
+	var terminatedAt time.Time
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		terminatedAt = time.Now()
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+	}
+	// Children that keep stdout/stderr open would otherwise block Wait
+	// until they exit.
+	cmd.WaitDelay = killGracePeriod
+
+	return func() {
+		if terminatedAt.IsZero() {
+			return
+		}
+
+		pgid := -cmd.Process.Pid
+		deadline := terminatedAt.Add(killGracePeriod)
+		for time.Now().Before(deadline) {
+			if err := syscall.Kill(pgid, 0); err != nil {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		syscall.Kill(pgid, syscall.SIGKILL)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mattn/go-isatty"
+)
+
+// prompter asks questions on a terminal for the interactive modes of
+// the CLI.
+type prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+func newPrompter() *prompter {
+	return &prompter{in: bufio.NewReader(os.Stdin), out: os.Stdout}
+}
+
+func isTerminal(f *os.File) bool {
+	return isatty.IsTerminal(f.Fd()) || isatty.IsCygwinTerminal(f.Fd())
+}
+
+// ask prints the question and returns the answer, or def if the answer
+// is empty.
+func (p *prompter) ask(question, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+
+	line, err := p.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+
+	answer := strings.TrimSpace(line)
+	if answer == "" {
+		return def, nil
+	}
+	return answer, nil
+}
+
+// askRequired repeats the question until a non-empty answer is given.
+func (p *prompter) askRequired(question, def string) (string, error) {
+	for {
+		answer, err := p.ask(question, def)
+		if err != nil {
+			return "", err
+		}
+		if answer != "" {
+			return answer, nil
+		}
+		fmt.Fprintln(p.out, "  a value is required")
+	}
+}
+
+// askPairs reads KEY=VALUE answers until an empty line is entered.
+func (p *prompter) askPairs(question string) (map[string]string, error) {
+	pairs := make(map[string]string)
+	for {
+		answer, err := p.ask(question, "")
+		if err != nil {
+			return nil, err
+		}
+		if answer == "" {
+			return pairs, nil
+		}
+
+		key, value, err := parseKeyValue(answer)
+		if err != nil {
+			fmt.Fprintf(p.out, "  %v\n", err)
+			continue
+		}
+		pairs[key] = value
+	}
+}
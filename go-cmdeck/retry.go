@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Retry runs a failed action again, up to Attempts times in all. The
+// delay between attempts is Delay (1s by default), doubled after every
+// attempt up to MaxDelay (1m by default) unless Backoff is fixed, and
+// varied randomly by up to the Jitter fraction of it. With ExitCodes,
+// only attempts that exit with one of the codes are retried. Timed out
+// and cancelled attempts are not retried.
+type Retry struct {
+	Attempts  int     `json:"attempts"`
+	Backoff   string  `json:"backoff,omitempty"`
+	Delay     string  `json:"delay,omitempty"`
+	MaxDelay  string  `json:"max_delay,omitempty"`
+	Jitter    float64 `json:"jitter,omitempty"`
+	ExitCodes []int   `json:"exit_codes,omitempty"`
+}
+
+// Backoff strategies of retries.
+const (
+	backoffFixed       = "fixed"
+	backoffExponential = "exponential"
+)
+
+const (
+	defaultRetryDelay    = time.Second
+	defaultMaxRetryDelay = time.Minute
+)
+
+// streamAttempt is the stream on which executeJobWithOutput announces
+// every attempt of a job with retries, such as "attempt 2/5".
+const streamAttempt = "attempt"
+
+// Attempt is one attempt of a run with retries.
+type Attempt struct {
+	Attempt   int           `json:"attempt"`
+	Timestamp time.Time     `json:"timestamp"`
+	Duration  time.Duration `json:"-"`
+	ExitCode  int           `json:"exit_code"`
+}
+
+// attemptJSON is Attempt without its JSON methods.
+type attemptJSON Attempt
+
+// MarshalJSON writes the duration as whole milliseconds in duration_ms,
+// like ExecutionResult.
+func (a Attempt) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		attemptJSON
+		DurationMS int64 `json:"duration_ms"`
+	}{attemptJSON(a), a.Duration.Milliseconds()})
+}
+
+// UnmarshalJSON reads duration_ms, or the duration in nanoseconds of
+// attempts recorded by older versions.
+func (a *Attempt) UnmarshalJSON(data []byte) error {
+	var decoded struct {
+		attemptJSON
+		DurationMS *int64        `json:"duration_ms"`
+		Duration   time.Duration `json:"duration"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*a = Attempt(decoded.attemptJSON)
+	a.Duration = decoded.Duration
+	if decoded.DurationMS != nil {
+		a.Duration = time.Duration(*decoded.DurationMS) * time.Millisecond
+	}
+	return nil
+}
+
+func (r *Retry) check() error {
+	if r.Attempts < 1 {
+		return fmt.Errorf("attempts must be at least 1")
+	}
+	switch r.Backoff {
+	case "", backoffFixed, backoffExponential:
+	default:
+		return fmt.Errorf("unknown backoff %q (expected %s or %s)", r.Backoff, backoffFixed, backoffExponential)
+	}
+	for _, delay := range []string{r.Delay, r.MaxDelay} {
+		if delay == "" {
+			continue
+		}
+		if d, err := parseDuration(delay); err != nil || d < 0 {
+			return fmt.Errorf("invalid delay %q", delay)
+		}
+	}
+	if r.Jitter < 0 || r.Jitter > 1 {
+		return fmt.Errorf("jitter must be between 0 and 1")
+	}
+	for _, code := range r.ExitCodes {
+		if code < 1 || code > 255 {
+			return fmt.Errorf("invalid exit code %d (expected 1-255)", code)
+		}
+	}
+	return nil
+}
+
+func (r *Retry) String() string {
+	delay, backoff := r.Delay, r.Backoff
+	if delay == "" {
+		delay = defaultRetryDelay.String()
+	}
+	if backoff == "" {
+		backoff = backoffExponential
+	}
+	s := fmt.Sprintf("%d attempts, %s %s backoff", r.Attempts, delay, backoff)
+	if len(r.ExitCodes) > 0 {
+		codes := make([]string, len(r.ExitCodes))
+		for i, code := range r.ExitCodes {
+			codes[i] = strconv.Itoa(code)
+		}
+		s += ", on exit codes " + strings.Join(codes, ", ")
+	}
+	return s
+}
+
+// checkRetry reports retry settings that cannot work.
+func (c Context) checkRetry() error {
+	if c.Retry == nil {
+		return nil
+	}
+	if err := c.Retry.check(); err != nil {
+		return fmt.Errorf("job '%s': retry: %w", c.Name, err)
+	}
+	return nil
+}
+
+// attempts returns how many times an action runs at most; a nil Retry
+// runs it once.
+func (r *Retry) attempts() int {
+	if r == nil {
+		return 1
+	}
+	return max(r.Attempts, 1)
+}
+
+// retries reports whether an attempt that exited with exitCode is run
+// again.
+func (r *Retry) retries(exitCode int) bool {
+	if exitCode == 0 {
+		return false
+	}
+	return len(r.ExitCodes) == 0 || slices.Contains(r.ExitCodes, exitCode)
+}
+
+// delay returns how long to wait after the given attempt.
+func (r *Retry) delay(attempt int) time.Duration {
+	delay := defaultRetryDelay
+	if d, err := parseDuration(r.Delay); err == nil {
+		delay = d
+	}
+	if r.Backoff != backoffFixed {
+		maxDelay := defaultMaxRetryDelay
+		if d, err := parseDuration(r.MaxDelay); err == nil {
+			maxDelay = d
+		}
+		for i := 1; i < attempt && delay < maxDelay; i++ {
+			delay *= 2
+		}
+		delay = min(delay, maxDelay)
+	}
+	if r.Jitter > 0 {
+		delay += time.Duration(float64(delay) * r.Jitter * (2*rand.Float64() - 1))
+	}
+	return delay
+}
+
+// parseExitCodes parses a comma-separated list of exit codes as given
+// on the command line.
+func parseExitCodes(list string) ([]int, error) {
+	var codes []int
+	for _, field := range strings.Split(list, ",") {
+		code, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, fmt.Errorf("invalid exit code %q", field)
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// attemptsLabel describes the attempts of a run, such as "3 (exit codes
+// 1, 1, 0)".
+func attemptsLabel(attempts []Attempt) string {
+	codes := make([]string, len(attempts))
+	for i, attempt := range attempts {
+		codes[i] = strconv.Itoa(attempt.ExitCode)
+	}
+	return fmt.Sprintf("%d (exit codes %s)", len(attempts), strings.Join(codes, ", "))
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		retry   Retry
+		attempt int
+		want    time.Duration
+	}{
+		{"default first", Retry{}, 1, time.Second},
+		{"default doubles", Retry{}, 3, 4 * time.Second},
+		{"default max_delay", Retry{}, 10, time.Minute},
+		{"exponential first", Retry{Delay: "100ms"}, 1, 100 * time.Millisecond},
+		{"exponential second", Retry{Delay: "100ms"}, 2, 200 * time.Millisecond},
+		{"exponential fourth", Retry{Backoff: backoffExponential, Delay: "100ms"}, 4, 800 * time.Millisecond},
+		{"capped at max_delay", Retry{Delay: "1s", MaxDelay: "5s"}, 4, 5 * time.Second},
+		{"capped without overflow", Retry{Delay: "1s", MaxDelay: "5s"}, 200, 5 * time.Second},
+		{"delay above max_delay", Retry{Delay: "10s", MaxDelay: "5s"}, 1, 5 * time.Second},
+		{"max_delay in days", Retry{Delay: "1h", MaxDelay: "1d"}, 10, 24 * time.Hour},
+		{"fixed", Retry{Backoff: backoffFixed, Delay: "2s"}, 5, 2 * time.Second},
+		{"fixed ignores max_delay", Retry{Backoff: backoffFixed, Delay: "2m", MaxDelay: "1m"}, 3, 2 * time.Minute},
+		{"fixed default", Retry{Backoff: backoffFixed}, 7, time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.retry.delay(tt.attempt); got != tt.want {
+				t.Errorf("delay(%d) = %s, want %s", tt.attempt, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryDelayJitter(t *testing.T) {
+	tests := []struct {
+		retry Retry
+		base  time.Duration
+	}{
+		{Retry{Backoff: backoffFixed, Delay: "1s", Jitter: 0.5}, time.Second},
+		{Retry{Delay: "1s", Jitter: 0.1}, 4 * time.Second},
+		{Retry{Delay: "1s", Jitter: 1}, 4 * time.Second},
+	}
+	for _, tt := range tests {
+		spread := time.Duration(float64(tt.base) * tt.retry.Jitter)
+		seen := make(map[time.Duration]bool)
+		for range 1000 {
+			got := tt.retry.delay(3)
+			if got < tt.base-spread || got > tt.base+spread {
+				t.Fatalf("%+v: delay(3) = %s, want %s ± %s", tt.retry, got, tt.base, spread)
+			}
+			seen[got] = true
+		}
+		if len(seen) < 2 {
+			t.Errorf("%+v: delay(3) was always the same", tt.retry)
+		}
+	}
+}
+
+func TestRetryRetries(t *testing.T) {
+	tests := []struct {
+		exitCodes []int
+		exitCode  int
+		want      bool
+	}{
+		{nil, 0, false},
+		{nil, 1, true},
+		{nil, 137, true},
+		{[]int{2, 75}, 0, false},
+		{[]int{2, 75}, 1, false},
+		{[]int{2, 75}, 2, true},
+		{[]int{2, 75}, 75, true},
+	}
+	for _, tt := range tests {
+		retry := Retry{Attempts: 3, ExitCodes: tt.exitCodes}
+		if got := retry.retries(tt.exitCode); got != tt.want {
+			t.Errorf("retries(%d) with exit_codes %v = %v, want %v", tt.exitCode, tt.exitCodes, got, tt.want)
+		}
+	}
+}
+
+func TestRetryCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		retry   Retry
+		wantErr string
+	}{
+		{"attempts only", Retry{Attempts: 3}, ""},
+		{"all set", Retry{Attempts: 3, Backoff: backoffFixed, Delay: "0s", MaxDelay: "1m", Jitter: 1, ExitCodes: []int{1, 255}}, ""},
+		{"no attempts", Retry{}, "attempts must be at least 1"},
+		{"negative attempts", Retry{Attempts: -1}, "attempts must be at least 1"},
+		{"unknown backoff", Retry{Attempts: 2, Backoff: "linear"}, "unknown backoff"},
+		{"bad delay", Retry{Attempts: 2, Delay: "soon"}, "invalid delay"},
+		{"negative delay", Retry{Attempts: 2, Delay: "-1s"}, "invalid delay"},
+		{"bad max_delay", Retry{Attempts: 2, MaxDelay: "1 hour"}, "invalid delay"},
+		{"negative jitter", Retry{Attempts: 2, Jitter: -0.1}, "jitter"},
+		{"jitter above 1", Retry{Attempts: 2, Jitter: 1.5}, "jitter"},
+		{"exit code 0", Retry{Attempts: 2, ExitCodes: []int{0}}, "invalid exit code 0"},
+		{"exit code 256", Retry{Attempts: 2, ExitCodes: []int{1, 256}}, "invalid exit code 256"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.retry.check()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("check = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseExitCodes(t *testing.T) {
+	tests := []struct {
+		list    string
+		want    []int
+		wantErr bool
+	}{
+		{"1", []int{1}, false},
+		{"1,75, 137", []int{1, 75, 137}, false},
+		{"", nil, true},
+		{"1,,2", nil, true},
+		{"1,x", nil, true},
+		{"1-3", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseExitCodes(tt.list)
+		if (err != nil) != tt.wantErr || !slices.Equal(got, tt.want) {
+			t.Errorf("parseExitCodes(%q) = %v, %v; want %v", tt.list, got, err, tt.want)
+		}
+	}
+}
+
+func TestExecuteJobWithOutputStopsAtDeadline(t *testing.T) {
+	// A job timeout is a deadline on the context; neither a timed out
+	// attempt nor the delay before the next one is followed by another
+	// attempt.
+	tests := []struct {
+		name    string
+		command string
+		retry   Retry
+	}{
+		{"attempt timed out", "sleep 5", Retry{Attempts: 3, Delay: "1ms"}},
+		{"timed out while waiting to retry", "exit 1", Retry{Attempts: 3, Delay: "10s"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cli, _ := newTestCLI(t, nil)
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+
+			start := time.Now()
+			captured, err := cli.executor.executeJobWithOutput(ctx, tt.command, nil, &tt.retry, nil)
+			if err == nil {
+				t.Fatal("timed out job succeeded")
+			}
+			if len(captured.Attempts) != 1 {
+				t.Errorf("made %d attempts, want 1", len(captured.Attempts))
+			}
+			if elapsed := time.Since(start); elapsed > 3*time.Second {
+				t.Errorf("returned after %s, want soon after the deadline", elapsed)
+			}
+		})
+	}
+}
+
+func TestExecuteJobWithOutputRetries(t *testing.T) {
+	// The command counts its attempts in a file in the working
+	// directory and succeeds on the third.
+	const thirdTime = `n=$(cat attempts 2>/dev/null || echo 0); n=$((n+1)); echo $n > attempts; echo "try $n"; [ $n -ge 3 ]`
+	tests := []struct {
+		name      string
+		command   string
+		retry     Retry
+		wantCodes []int
+	}{
+		{"fails twice, then succeeds", thirdTime, Retry{Attempts: 5, Delay: "1ms"}, []int{1, 1, 0}},
+		{"runs out of attempts", "exit 3", Retry{Attempts: 3, Delay: "1ms"}, []int{3, 3, 3}},
+		{"retried exit code", "exit 2", Retry{Attempts: 2, Delay: "1ms", ExitCodes: []int{2}}, []int{2, 2}},
+		{"exit code outside exit_codes", "exit 1", Retry{Attempts: 3, Delay: "1ms", ExitCodes: []int{2}}, []int{1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cli, _ := newTestCLI(t, nil)
+			var announced []string
+			onLine := func(stream, line string) {
+				if stream == streamAttempt {
+					announced = append(announced, line)
+				}
+			}
+
+			captured, err := cli.executor.executeJobWithOutput(context.Background(), tt.command, nil, &tt.retry, onLine)
+			codes := make([]int, len(captured.Attempts))
+			for i, attempt := range captured.Attempts {
+				codes[i] = attempt.ExitCode
+				if attempt.Attempt != i+1 {
+					t.Errorf("attempt %d is numbered %d", i+1, attempt.Attempt)
+				}
+			}
+			if !slices.Equal(codes, tt.wantCodes) {
+				t.Errorf("attempts exited with %v, want %v", codes, tt.wantCodes)
+			}
+			last := tt.wantCodes[len(tt.wantCodes)-1]
+			if captured.ExitCode != last || (err == nil) != (last == 0) {
+				t.Errorf("result exit code %d, error %v; want those of the last attempt", captured.ExitCode, err)
+			}
+			if len(announced) != len(tt.wantCodes) {
+				t.Errorf("announced %v, want one line per attempt", announced)
+			}
+			for _, line := range announced {
+				if header := "=== " + line + " ==="; !strings.Contains(captured.Output, header) {
+					t.Errorf("output lacks %q:\n%s", header, captured.Output)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Overlap policies: what the scheduler does when a job is due while its
+// previous run is still going.
+const (
+	overlapSkip  = "skip"
+	overlapQueue = "queue"
+	overlapAllow = "allow"
+)
+
+// Catch-up policies: what the scheduler does with the runs it missed
+// while it was not running or the machine was asleep.
+const (
+	catchUpNone = "none"
+	catchUpOnce = "once"
+	catchUpAll  = "all"
+)
+
+// Triggers of the runs started by the scheduler, recorded in
+// ExecutionResult.Trigger.
+const (
+	triggerSchedule = "schedule"
+	triggerCatchUp  = "catch-up"
+)
+
+const (
+	// misfireGrace is how late a run may come and still count as on
+	// time rather than missed.
+	misfireGrace = time.Minute
+	// maxCatchUp limits the missed runs caught up with catch_up all.
+	maxCatchUp = 100
+	// schedulerReload is how often the scheduler reads the
+	// configuration again at the latest.
+	schedulerReload = time.Minute
+)
+
+// cronParser accepts the five standard cron fields and descriptors such
+// as @daily and @every 5m.
+var cronParser = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// checkSchedule reports a schedule that cannot work.
+func (c Context) checkSchedule() error {
+	if c.Schedule == "" {
+		if c.Overlap != "" || c.CatchUp != "" {
+			return fmt.Errorf("job '%s': overlap and catch_up need a schedule", c.Name)
+		}
+		return nil
+	}
+	schedule, err := cronParser.Parse(c.Schedule)
+	if err != nil {
+		return fmt.Errorf("job '%s': invalid schedule %q: %w", c.Name, c.Schedule, err)
+	}
+	if schedule.Next(time.Now()).IsZero() {
+		return fmt.Errorf("job '%s': schedule %q never fires", c.Name, c.Schedule)
+	}
+	switch c.Overlap {
+	case "", overlapSkip, overlapQueue, overlapAllow:
+	default:
+		return fmt.Errorf("job '%s': unknown overlap %q (expected %s, %s or %s)", c.Name, c.Overlap, overlapSkip, overlapQueue, overlapAllow)
+	}
+	switch c.CatchUp {
+	case "", catchUpNone, catchUpOnce, catchUpAll:
+	default:
+		return fmt.Errorf("job '%s': unknown catch_up %q (expected %s, %s or %s)", c.Name, c.CatchUp, catchUpNone, catchUpOnce, catchUpAll)
+	}
+	return nil
+}
+
+func (c Context) overlap() string {
+	return firstNonEmpty(c.Overlap, overlapSkip)
+}
+
+func (c Context) catchUp() string {
+	return firstNonEmpty(c.CatchUp, catchUpNone)
+}
+
+// nextRun returns when the schedule of the job fires next after t. ok is
+// false for jobs without a valid schedule.
+func (c Context) nextRun(t time.Time) (time.Time, bool) {
+	if c.Schedule == "" {
+		return time.Time{}, false
+	}
+	schedule, err := cronParser.Parse(c.Schedule)
+	if err != nil {
+		return time.Time{}, false
+	}
+	next := schedule.Next(t)
+	return next, !next.IsZero()
+}
+
+// getSchedulerPath returns the PID file of the scheduler of the
+// configuration file at configPath (see getDataDir), which is locked
+// while that scheduler runs.
+func getSchedulerPath(configPath string) string {
+	return filepath.Join(getDataDir(configPath), "scheduler.pid")
+}
+
+// schedulerRunning reports whether a scheduler is running for the
+// configuration file at configPath.
+func schedulerRunning(configPath string) bool {
+	return pidFileHeld(getSchedulerPath(configPath))
+}
+
+// Scheduler runs the jobs that have a schedule at their times and logs
+// what it does to out. The runs are recorded like those of go-cmdeck
+// run. Everything happens on the goroutine of Run except the runs
+// themselves, which report back on done.
+type Scheduler struct {
+	executor   *Executor
+	configPath string
+	out        io.Writer
+
+	jobs    map[string]*scheduledJob
+	invalid map[string]string
+	running map[string]int
+	queued  map[string][]string
+	loadErr string
+	done    chan scheduledRun
+}
+
+// scheduledJob is a job with a schedule and the last scheduled time the
+// scheduler handled for it.
+type scheduledJob struct {
+	job      Context
+	schedule cron.Schedule
+	last     time.Time
+}
+
+// scheduledRun is the outcome of a run started by the scheduler.
+type scheduledRun struct {
+	name    string
+	trigger string
+	result  *ExecutionResult
+	err     error
+}
+
+// NewScheduler returns a scheduler for the jobs of the executor.
+// configPath is the --config file, if any; the configuration is read
+// again from there while the scheduler runs.
+func NewScheduler(executor *Executor, configPath string, out io.Writer) *Scheduler {
+	return &Scheduler{
+		executor:   executor,
+		configPath: configPath,
+		out:        out,
+		jobs:       make(map[string]*scheduledJob),
+		invalid:    make(map[string]string),
+		running:    make(map[string]int),
+		queued:     make(map[string][]string),
+		done:       make(chan scheduledRun),
+	}
+}
+
+func (s *Scheduler) logf(format string, args ...any) {
+	fmt.Fprintf(s.out, "%s %s\n", time.Now().Format("2006-01-02 15:04:05"), fmt.Sprintf(format, args...))
+}
+
+// Run schedules the jobs until ctx is cancelled, which also cancels the
+// running jobs. Only one scheduler runs at a time. The configuration is
+// read again whenever the scheduler wakes up, at least once a minute,
+// so that jobs changed meanwhile are picked up.
+func (s *Scheduler) Run(ctx context.Context) error {
+	path := getSchedulerPath(s.executor.config.userLayer().Path)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	release, err := holdPIDFile(path)
+	if err != nil {
+		return fmt.Errorf("scheduler: %w", err)
+	}
+	defer release()
+
+	state, err := s.executor.state.load()
+	if err != nil {
+		return fmt.Errorf("failed to load state: %w", err)
+	}
+	s.update(state.Scheduled, time.Now())
+	if len(s.jobs) == 0 {
+		s.logf("no jobs with a schedule; waiting for the configuration to change")
+	}
+
+	for {
+		s.fireDue(ctx, time.Now())
+		timer := time.NewTimer(time.Until(s.nextWake(time.Now())))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			s.stop(ctx)
+			return nil
+		case run := <-s.done:
+			timer.Stop()
+			s.finish(ctx, run)
+		case <-timer.C:
+			s.reload()
+		}
+	}
+}
+
+// reload reads the configuration again. A configuration that cannot be
+// read is reported once and the previous one is kept. Runs already
+// started keep the jobs they were started with.
+func (s *Scheduler) reload() {
+	config, err := loadConfig(s.configPath)
+	if err != nil {
+		if err.Error() != s.loadErr {
+			s.logf("failed to read the configuration, keeping the previous one: %v", err)
+			s.loadErr = err.Error()
+		}
+		return
+	}
+	if s.loadErr != "" {
+		s.logf("read the configuration again")
+		s.loadErr = ""
+	}
+	s.executor = NewExecutor(config, s.executor.history, s.executor.state, s.executor.services)
+	s.update(nil, time.Now())
+}
+
+// update takes the scheduled jobs from the configuration. A job new to
+// the scheduler continues from the last scheduled time stored in the
+// state file, so that the runs missed meanwhile are caught up, or from
+// now.
+func (s *Scheduler) update(stored map[string]time.Time, now time.Time) {
+	jobs := make(map[string]*scheduledJob)
+	invalid := make(map[string]string)
+	start := make(map[string]time.Time)
+	for _, job := range s.executor.listContexts() {
+		if job.Schedule == "" {
+			continue
+		}
+		if err := job.checkSchedule(); err != nil {
+			if s.invalid[job.Name] != err.Error() {
+				s.logf("%v; not scheduled", err)
+			}
+			invalid[job.Name] = err.Error()
+			continue
+		}
+		schedule, _ := cronParser.Parse(job.Schedule)
+		scheduled := &scheduledJob{job: job, schedule: schedule}
+		if previous, exists := s.jobs[job.Name]; exists {
+			scheduled.last = previous.last
+		} else {
+			scheduled.last = stored[job.Name]
+			if scheduled.last.IsZero() {
+				scheduled.last = now
+				start[job.Name] = now
+			}
+		}
+		if previous, exists := s.jobs[job.Name]; !exists || previous.job.Schedule != job.Schedule {
+			s.logf("%s: scheduled %q, next run at %s", job.Name, job.Schedule, timeLabel(schedule.Next(now)))
+		}
+		jobs[job.Name] = scheduled
+	}
+
+	// Jobs with an invalid schedule keep their state until it is fixed.
+	var forget []string
+	for name := range s.jobs {
+		if _, exists := jobs[name]; !exists {
+			if _, exists := invalid[name]; !exists {
+				s.logf("%s: no longer scheduled", name)
+				forget = append(forget, name)
+			}
+		}
+	}
+	for name := range stored {
+		if _, exists := jobs[name]; !exists && invalid[name] == "" {
+			forget = append(forget, name)
+		}
+	}
+	s.jobs = jobs
+	s.invalid = invalid
+	s.remember(start, forget)
+}
+
+// remember stores the last handled scheduled time of the jobs in the
+// state file, where the next scheduler finds the missed runs from, and
+// drops the times of jobs that are no longer scheduled.
+func (s *Scheduler) remember(times map[string]time.Time, forget []string) {
+	if len(times) == 0 && len(forget) == 0 {
+		return
+	}
+	_, err := s.executor.state.update(func(state *State) bool {
+		if state.Scheduled == nil {
+			state.Scheduled = make(map[string]time.Time)
+		}
+		for _, name := range forget {
+			delete(state.Scheduled, name)
+		}
+		for name, t := range times {
+			state.Scheduled[name] = t
+		}
+		return true
+	})
+	if err != nil {
+		s.logf("failed to save the schedule state: %v", err)
+	}
+}
+
+// fireDue starts the runs that are due. The latest due time of a job
+// runs if it is less than misfireGrace late; earlier ones were missed,
+// and are caught up according to the catch_up policy of the job. With
+// catch_up all, the missed runs of a job that skips overlapping runs
+// are queued instead, one after another.
+func (s *Scheduler) fireDue(ctx context.Context, now time.Time) {
+	handled := make(map[string]time.Time)
+	names := make([]string, 0, len(s.jobs))
+	for name := range s.jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		scheduled := s.jobs[name]
+		var due []time.Time
+		count := 0
+		for t := scheduled.schedule.Next(scheduled.last); !t.IsZero() && !t.After(now); t = scheduled.schedule.Next(t) {
+			count++
+			due = append(due, t)
+			if len(due) > maxCatchUp+1 {
+				due = due[1:]
+			}
+		}
+		if count == 0 {
+			continue
+		}
+		latest := due[len(due)-1]
+		scheduled.last, handled[name] = latest, latest
+
+		onTime := now.Sub(latest) < misfireGrace
+		missed := due
+		if onTime {
+			missed, count = due[:len(due)-1], count-1
+		}
+		missed = missed[max(len(missed)-maxCatchUp, 0):]
+		if count > 0 {
+			switch scheduled.job.catchUp() {
+			case catchUpNone:
+				s.logf("%s: skipped %s since %s (catch_up: none)", name, missedRuns(count), timeLabel(missed[0]))
+			case catchUpOnce:
+				s.logf("%s: catching up %s with one run", name, missedRuns(count))
+				s.fire(ctx, scheduled.job, triggerCatchUp, missed[len(missed)-1])
+			case catchUpAll:
+				if count > len(missed) {
+					s.logf("%s: catching up the last %d of %s", name, len(missed), missedRuns(count))
+				} else {
+					s.logf("%s: catching up %s", name, missedRuns(count))
+				}
+				job := scheduled.job
+				if job.overlap() == overlapSkip {
+					job.Overlap = overlapQueue
+				}
+				for _, t := range missed {
+					s.fire(ctx, job, triggerCatchUp, t)
+				}
+			}
+		}
+		if onTime {
+			s.fire(ctx, scheduled.job, triggerSchedule, latest)
+		}
+	}
+	s.remember(handled, nil)
+}
+
+func missedRuns(count int) string {
+	if count == 1 {
+		return "1 missed run"
+	}
+	return fmt.Sprintf("%d missed runs", count)
+}
+
+// fire starts the run of a job due at the given time, unless the job is
+// still running and its overlap policy skips or queues the run.
+func (s *Scheduler) fire(ctx context.Context, job Context, trigger string, at time.Time) {
+	if s.running[job.Name] > 0 {
+		switch job.overlap() {
+		case overlapSkip:
+			s.logf("%s: still running, skipped the run of %s", job.Name, timeLabel(at))
+			return
+		case overlapQueue:
+			s.queued[job.Name] = append(s.queued[job.Name], trigger)
+			s.logf("%s: still running, queued the run of %s", job.Name, timeLabel(at))
+			return
+		}
+	}
+	s.start(ctx, job.Name, trigger)
+}
+
+// start runs the run action of a job with its dependencies in the
+// background, like go-cmdeck run.
+func (s *Scheduler) start(ctx context.Context, name, trigger string) {
+	plan, err := s.executor.planWorkflow(name, defaultAction, nil)
+	if err != nil {
+		s.logf("%s: %v", name, err)
+		return
+	}
+	started := "started"
+	if trigger == triggerCatchUp {
+		started += " (catch-up)"
+	}
+	if len(plan.steps) > 0 {
+		started += ": " + strings.Join(plan.chain(), " → ")
+	}
+	s.logf("%s: %s", name, started)
+
+	s.running[name]++
+	executor := s.executor
+	go func() {
+		result, err := executor.executeWorkflow(ctx, plan, nil)
+		s.done <- scheduledRun{name: name, trigger: trigger, result: result, err: err}
+	}()
+}
+
+// finish records the result of a run and starts the next queued run of
+// the job.
+func (s *Scheduler) finish(ctx context.Context, run scheduledRun) {
+	s.running[run.name]--
+	if run.err != nil {
+		s.logf("%s: %v", run.name, run.err)
+	} else {
+		run.result.Trigger = run.trigger
+		recordErr := s.executor.recordResult(run.result)
+		s.logf("%s: %s in %s, exit code %d (run %s)", run.name, run.result.statusLabel(),
+			run.result.Duration.Round(time.Millisecond), run.result.ExitCode, run.result.RunID)
+		if recordErr != nil {
+			s.logf("%s: %v", run.name, recordErr)
+		}
+		if err := s.executor.notify(run.result); err != nil {
+			s.logf("%s: %v", run.name, err)
+		}
+	}
+
+	if queued := s.queued[run.name]; len(queued) > 0 && ctx.Err() == nil {
+		s.queued[run.name] = queued[1:]
+		s.start(ctx, run.name, queued[0])
+	}
+}
+
+// stop waits for the running jobs, which were cancelled together with
+// the scheduler, and records their results. Queued runs are dropped.
+func (s *Scheduler) stop(ctx context.Context) {
+	running := 0
+	for _, n := range s.running {
+		running += n
+	}
+	if running > 0 {
+		s.logf("stopping, waiting for %d running jobs", running)
+	}
+	for ; running > 0; running-- {
+		s.finish(ctx, <-s.done)
+	}
+	s.logf("stopped")
+}
+
+// nextWake returns when the next job is due, or when to read the
+// configuration again if that comes first.
+func (s *Scheduler) nextWake(now time.Time) time.Time {
+	wake := now.Add(schedulerReload)
+	for _, scheduled := range s.jobs {
+		if next := scheduled.schedule.Next(scheduled.last); !next.IsZero() && next.Before(wake) {
+			wake = next
+		}
+	}
+	return wake
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// schedulerEpoch is the last scheduled time the test jobs were handled
+// at; they are scheduled every hour on the hour.
+var schedulerEpoch = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// newTestScheduler returns a scheduler for an hourly job last handled at
+// schedulerEpoch, and the buffer it logs to.
+func newTestScheduler(t *testing.T, job Context) (*Scheduler, *bytes.Buffer) {
+	t.Helper()
+	job.Name, job.Label, job.Schedule = "job", "Job", "0 * * * *"
+	job.Commands = map[string]string{"run": "true"}
+	cli, path := newTestCLI(t, map[string]Context{"job": job})
+	var log bytes.Buffer
+	s := NewScheduler(cli.executor, path, &log)
+	s.update(map[string]time.Time{"job": schedulerEpoch}, schedulerEpoch)
+	return s, &log
+}
+
+// runs returns the triggers of the runs the scheduler started, after
+// waiting for them, and of the runs it queued, and forgets them. active
+// runs were pretended to be running already.
+func runs(s *Scheduler, active int) []string {
+	var triggers []string
+	for range s.running["job"] - active {
+		triggers = append(triggers, (<-s.done).trigger)
+	}
+	triggers = append(triggers, s.queued["job"]...)
+	s.running["job"], s.queued["job"] = active, nil
+	return triggers
+}
+
+func TestSchedulerFireDueCatchUp(t *testing.T) {
+	catchUps := func(n int) []string { return slices.Repeat([]string{triggerCatchUp}, n) }
+	tests := []struct {
+		name    string
+		catchUp string
+		now     time.Duration
+		want    []string
+		wantLog string
+	}{
+		{"none, nothing due", catchUpNone, 30 * time.Minute, nil, ""},
+		{"none, on time", catchUpNone, time.Hour + 30*time.Second, []string{triggerSchedule}, "started"},
+		{"none, 1 missed", catchUpNone, time.Hour + 30*time.Minute, nil, "skipped 1 missed run since"},
+		{"none, 150 missed", catchUpNone, 150*time.Hour + 30*time.Minute, nil, "skipped 150 missed runs"},
+		{"once, nothing due", catchUpOnce, 30 * time.Minute, nil, ""},
+		{"once, 1 missed", catchUpOnce, time.Hour + 30*time.Minute, catchUps(1), "catching up 1 missed run with one run"},
+		{"once, 150 missed", catchUpOnce, 150*time.Hour + 30*time.Minute, catchUps(1), "catching up 150 missed runs with one run"},
+		{"all, nothing due", catchUpAll, 30 * time.Minute, nil, ""},
+		{"all, 1 missed", catchUpAll, time.Hour + 30*time.Minute, catchUps(1), "catching up 1 missed run"},
+		{"all, 3 missed", catchUpAll, 3*time.Hour + 30*time.Minute, catchUps(3), "catching up 3 missed runs"},
+		{"all, 150 missed", catchUpAll, 150*time.Hour + 30*time.Minute, catchUps(maxCatchUp), "catching up the last 100 of 150 missed runs"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, log := newTestScheduler(t, Context{CatchUp: tt.catchUp})
+			now := schedulerEpoch.Add(tt.now)
+
+			s.fireDue(context.Background(), now)
+			if got := runs(s, 0); !slices.Equal(got, tt.want) {
+				t.Errorf("runs = %v, want %v", got, tt.want)
+			}
+			if !strings.Contains(log.String(), tt.wantLog) {
+				t.Errorf("log %q does not contain %q", log.String(), tt.wantLog)
+			}
+			if last, want := s.jobs["job"].last, now.Truncate(time.Hour); last != want {
+				t.Errorf("last handled %s, want %s", last, want)
+			}
+
+			// Nothing is due twice.
+			s.fireDue(context.Background(), now)
+			if got := runs(s, 0); len(got) != 0 {
+				t.Errorf("second fireDue started %v", got)
+			}
+		})
+	}
+}
+
+func TestSchedulerFireOverlap(t *testing.T) {
+	tests := []struct {
+		overlap string
+		want    []string
+		wantLog string
+	}{
+		{overlapSkip, nil, "still running, skipped the run of"},
+		{overlapQueue, []string{triggerSchedule}, "still running, queued the run of"},
+		{overlapAllow, []string{triggerSchedule}, "job: started"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.overlap, func(t *testing.T) {
+			s, log := newTestScheduler(t, Context{Overlap: tt.overlap})
+			s.running["job"] = 1
+
+			s.fire(context.Background(), s.jobs["job"].job, triggerSchedule, schedulerEpoch.Add(time.Hour))
+			if got := runs(s, 1); !slices.Equal(got, tt.want) {
+				t.Errorf("runs = %v, want %v", got, tt.want)
+			}
+			if !strings.Contains(log.String(), tt.wantLog) {
+				t.Errorf("log %q does not contain %q", log.String(), tt.wantLog)
+			}
+		})
+	}
+}
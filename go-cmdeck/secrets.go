@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// secretMask replaces secret values in everything go-cmdeck shows or
+// stores.
+const secretMask = "********"
+
+// Secret is a job variable whose value is read when the job runs instead
+// of being stored in the configuration. Exactly one of File, Env and
+// Command is set.
+type Secret struct {
+	File    string `json:"file,omitempty"`
+	Env     string `json:"env,omitempty"`
+	Command string `json:"command,omitempty"`
+}
+
+// String returns the secret's source in the form accepted by parseSecret.
+func (s Secret) String() string {
+	switch {
+	case s.File != "":
+		return "file:" + s.File
+	case s.Env != "":
+		return "env:" + s.Env
+	case s.Command != "":
+		return "cmd:" + s.Command
+	}
+	return ""
+}
+
+// parseSecret parses a secret source given as file:PATH, env:VAR or
+// cmd:COMMAND.
+func parseSecret(spec string) (Secret, error) {
+	kind, ref, _ := strings.Cut(spec, ":")
+	if ref == "" {
+		return Secret{}, fmt.Errorf("expected file:PATH, env:VAR or cmd:COMMAND, got %q", spec)
+	}
+	switch kind {
+	case "file":
+		return Secret{File: ref}, nil
+	case "env":
+		return Secret{Env: ref}, nil
+	case "cmd":
+		return Secret{Command: ref}, nil
+	}
+	return Secret{}, fmt.Errorf("unknown secret source %q (expected file, env or cmd)", kind)
+}
+
+func (s Secret) check() error {
+	sources := 0
+	for _, ref := range []string{s.File, s.Env, s.Command} {
+		if ref != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return fmt.Errorf("exactly one of file, env and command must be set")
+	}
+	return nil
+}
+
+// value reads the secret. Trailing newlines are removed from file
+// contents and command output.
+func (s Secret) value(ctx context.Context) (string, error) {
+	switch {
+	case s.File != "":
+		path := s.File
+		if rest, ok := strings.CutPrefix(path, "~/"); ok {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return "", err
+			}
+			path = filepath.Join(home, rest)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case s.Env != "":
+		value, ok := os.LookupEnv(s.Env)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", s.Env)
+		}
+		return value, nil
+	case s.Command != "":
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, "sh", "-c", s.Command)
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return "", fmt.Errorf("%s: %w: %s", s.Command, err, msg)
+			}
+			return "", fmt.Errorf("%s: %w", s.Command, err)
+		}
+		return strings.TrimRight(string(out), "\r\n"), nil
+	}
+	return "", fmt.Errorf("no source")
+}
+
+// checkSecrets reports secret definitions that cannot work.
+func (c Context) checkSecrets() error {
+	for _, name := range sortedSecretNames(c.Secrets) {
+		if !variableNamePattern.MatchString(name) {
+			return fmt.Errorf("job '%s': invalid secret name %q", c.Name, name)
+		}
+		if _, exists := c.Variables[name]; exists {
+			return fmt.Errorf("job '%s': %s is defined as both a variable and a secret", c.Name, name)
+		}
+		if err := c.Secrets[name].check(); err != nil {
+			return fmt.Errorf("job '%s': secret %s: %w", c.Name, name, err)
+		}
+	}
+	return nil
+}
+
+// maskedSecrets returns the secrets of the job with masked values, for
+// checking and showing commands without reading the secrets.
+func (c Context) maskedSecrets() map[string]string {
+	masked := make(map[string]string, len(c.Secrets))
+	for name := range c.Secrets {
+		if _, overridden := c.Variables[name]; !overridden {
+			masked[name] = secretMask
+		}
+	}
+	return masked
+}
+
+// resolveSecrets reads the secrets of the job. Secrets that were given a
+// value for this run (e.g. with -v) are not read.
+func (e *Executor) resolveSecrets(ctx context.Context, job Context) (map[string]string, error) {
+	values := make(map[string]string, len(job.Secrets))
+	for _, name := range sortedSecretNames(job.Secrets) {
+		if _, overridden := job.Variables[name]; overridden {
+			continue
+		}
+		value, err := job.Secrets[name].value(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("secret %s: %w", name, err)
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
+// secretMasker hides secret values in output. Multi-line values are also
+// masked line by line so that streamed output is covered.
+type secretMasker struct {
+	replacer *strings.Replacer
+}
+
+func newSecretMasker(secrets map[string]string) *secretMasker {
+	seen := make(map[string]bool)
+	var values []string
+	add := func(v string) {
+		if v != "" && !seen[v] {
+			seen[v] = true
+			values = append(values, v)
+		}
+	}
+	for _, value := range secrets {
+		add(value)
+		// Spliced values appear quoted in the Command: line.
+		add(shellQuote(value, true, false))
+		add(shellQuote(value, false, true))
+		for _, line := range strings.Split(value, "\n") {
+			add(strings.TrimSpace(line))
+		}
+	}
+	if len(values) == 0 {
+		return &secretMasker{}
+	}
+
+	// Longer values first, so that a secret containing another one is
+	// masked as a whole.
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	pairs := make([]string, 0, 2*len(values))
+	for _, v := range values {
+		pairs = append(pairs, v, secretMask)
+	}
+	return &secretMasker{replacer: strings.NewReplacer(pairs...)}
+}
+
+func (m *secretMasker) mask(s string) string {
+	if m.replacer == nil {
+		return s
+	}
+	return m.replacer.Replace(s)
+}
+
+func sortedSecretNames(secrets map[string]Secret) []string {
+	names := make([]string, 0, len(secrets))
+	for name := range secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
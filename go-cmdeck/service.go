@@ -0,0 +1,686 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Restart policies of a service.
+const (
+	restartNo        = "no"
+	restartOnFailure = "on-failure"
+	restartAlways    = "always"
+)
+
+// Service makes the run action of a job a long-running service: it is
+// started detached under a supervisor process instead of being waited
+// for. Restart is the restart policy ("no" by default). The first
+// restart waits RestartDelay (1s by default) and every further one
+// twice as long, up to maxRestartDelay. MaxRestarts limits consecutive
+// restarts; 0 is unlimited.
+type Service struct {
+	Restart      string `json:"restart,omitempty"`
+	RestartDelay string `json:"restart_delay,omitempty"`
+	MaxRestarts  int    `json:"max_restarts,omitempty"`
+}
+
+const (
+	defaultRestartDelay = time.Second
+	maxRestartDelay     = time.Minute
+	// stableRunTime is how long a service has to stay up for the
+	// restart delay and the consecutive restart count to start over.
+	stableRunTime = time.Minute
+	// maxServiceLog is the size beyond which a service log is moved to
+	// <name>.log.1 when the service is started.
+	maxServiceLog = 10 << 20
+)
+
+// States of a service.
+const (
+	serviceRunning    = "running"
+	serviceRestarting = "restarting"
+	serviceStopped    = "stopped"
+	// serviceOrphaned is a service process that outlived its supervisor,
+	// e.g. because the supervisor was killed.
+	serviceOrphaned = "orphaned"
+)
+
+func checkRestartPolicy(policy string) error {
+	switch policy {
+	case "", restartNo, restartOnFailure, restartAlways:
+		return nil
+	}
+	return fmt.Errorf("invalid restart policy %q (expected %s, %s or %s)", policy, restartNo, restartOnFailure, restartAlways)
+}
+
+// checkService reports service settings that cannot work.
+func (c Context) checkService() error {
+	if c.Service == nil {
+		return nil
+	}
+	if err := checkRestartPolicy(c.Service.Restart); err != nil {
+		return fmt.Errorf("job '%s': %w", c.Name, err)
+	}
+	if c.Service.RestartDelay != "" {
+		if _, err := parseDuration(c.Service.RestartDelay); err != nil {
+			return fmt.Errorf("job '%s': invalid restart_delay: %w", c.Name, err)
+		}
+	}
+	if c.Service.MaxRestarts < 0 {
+		return fmt.Errorf("job '%s': max_restarts must not be negative", c.Name)
+	}
+	return nil
+}
+
+// restartDelay returns the delay before the first restart.
+func (s *Service) restartDelay() time.Duration {
+	if d, err := parseDuration(s.RestartDelay); err == nil && d > 0 {
+		return d
+	}
+	return defaultRestartDelay
+}
+
+// restarts reports whether a service that exited with exitCode is
+// started again by its restart policy.
+func (s *Service) restarts(exitCode int) bool {
+	switch s.Restart {
+	case restartAlways:
+		return true
+	case restartOnFailure:
+		return exitCode != 0
+	}
+	return false
+}
+
+// getServicesDir returns the services directory of the configuration
+// file at configPath (see getDataDir).
+func getServicesDir(configPath string) string {
+	return filepath.Join(getDataDir(configPath), "services")
+}
+
+// Services keeps the files of the running services under dir:
+// <name>.pid holds the PID of the supervisor and is locked as long as it
+// runs, <name>.json its status (see serviceStatus) and <name>.log the
+// output of the service. Supervisors are started with configPath as
+// --config, if set, so that they see the same jobs.
+type Services struct {
+	dir        string
+	configPath string
+}
+
+func NewServices(dir, configPath string) *Services {
+	return &Services{dir: dir, configPath: configPath}
+}
+
+// path returns the file of a service with the extension ext. Job names
+// are escaped so that they are valid file names.
+func (s *Services) path(name, ext string) string {
+	return filepath.Join(s.dir, url.PathEscape(name)+ext)
+}
+
+func (s *Services) pidPath(name string) string    { return s.path(name, ".pid") }
+func (s *Services) statusPath(name string) string { return s.path(name, ".json") }
+func (s *Services) logPath(name string) string    { return s.path(name, ".log") }
+
+// serviceStatus is the status of a service as written by its
+// supervisor: the PID of the supervisor and of the service process,
+// when the supervisor started, since when the service is in its state,
+// how often it was restarted and the exit code of its last run.
+type serviceStatus struct {
+	Name       string    `json:"name"`
+	State      string    `json:"state"`
+	Supervisor int       `json:"supervisor,omitempty"`
+	PID        int       `json:"pid,omitempty"`
+	Started    time.Time `json:"started"`
+	Since      time.Time `json:"since"`
+	Restarts   int       `json:"restarts"`
+	ExitCode   int       `json:"exit_code"`
+	Log        string    `json:"log"`
+}
+
+// summary describes the status in a few words, such as "running 2h3m"
+// or "stopped, exit code 1".
+func (st serviceStatus) summary() string {
+	switch st.State {
+	case serviceRunning:
+		return fmt.Sprintf("running %s", time.Since(st.Since).Truncate(time.Second))
+	case serviceRestarting:
+		return fmt.Sprintf("restarting, %d restarts", st.Restarts)
+	case serviceStopped:
+		if st.ExitCode != 0 {
+			return fmt.Sprintf("stopped, exit code %d", st.ExitCode)
+		}
+	}
+	return st.State
+}
+
+// status returns the current status of a service. A service whose
+// supervisor is gone is stopped, or orphaned if its process is still
+// alive.
+func (s *Services) status(name string) serviceStatus {
+	st := serviceStatus{}
+	if data, err := os.ReadFile(s.statusPath(name)); err == nil {
+		json.Unmarshal(data, &st)
+	}
+	st.Name = name
+	st.Log = s.logPath(name)
+	if st.State == "" {
+		st.State = serviceStopped
+	}
+	if st.State != serviceStopped && !pidFileHeld(s.pidPath(name)) {
+		st.State = serviceStopped
+		if st.PID > 0 && processGroupExists(st.PID) {
+			st.State = serviceOrphaned
+		}
+		st.Supervisor = 0
+	}
+	return st
+}
+
+func (s *Services) writeStatus(st serviceStatus) error {
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.statusPath(st.Name), data, 0644)
+}
+
+// serviceJob returns the job of a service.
+func (e *Executor) serviceJob(name string) (Context, error) {
+	job, exists := e.config.Contexts[name]
+	if !exists {
+		return Context{}, fmt.Errorf("job '%s' not found", name)
+	}
+	if job.Service == nil {
+		return Context{}, fmt.Errorf("job '%s' is not a service", name)
+	}
+	if _, exists := job.Commands[defaultAction]; !exists {
+		return Context{}, fmt.Errorf("job '%s' has no %s command", name, defaultAction)
+	}
+	return job, nil
+}
+
+// serviceStartTimeout is how long startService waits for the supervisor
+// to start the service.
+const serviceStartTimeout = 5 * time.Second
+
+// startService starts a service; see Services.start.
+func (e *Executor) startService(name string) error {
+	job, err := e.serviceJob(name)
+	if err != nil {
+		return err
+	}
+	return e.services.start(job)
+}
+
+// stopService stops a service; see Services.stop.
+func (e *Executor) stopService(name string) error {
+	if _, exists := e.config.Contexts[name]; !exists {
+		return fmt.Errorf("job '%s' not found", name)
+	}
+	return e.services.stop(name)
+}
+
+// start starts the supervisor of a service as a detached go-cmdeck
+// process, which keeps running when go-cmdeck exits, and waits until
+// the supervisor has started the service.
+func (s *Services) start(job Context) error {
+	name := job.Name
+	if _, err := job.resolveParams(nil); err != nil {
+		return fmt.Errorf("job '%s': %w", name, err)
+	}
+	st := s.status(name)
+	switch st.State {
+	case serviceRunning, serviceRestarting:
+		return fmt.Errorf("service '%s' is already running", name)
+	case serviceOrphaned:
+		return fmt.Errorf("service '%s' is still running without its supervisor (pid %d); stop it first", name, st.PID)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	var args []string
+	if s.configPath != "" {
+		configPath, err := filepath.Abs(s.configPath)
+		if err != nil {
+			return err
+		}
+		args = append(args, "--config", configPath)
+	}
+	args = append(args, "service", "supervise", name)
+
+	logPath := s.logPath(name)
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	if info, err := os.Stat(logPath); err == nil && info.Size() > maxServiceLog {
+		os.Rename(logPath, logPath+".1")
+	}
+	log, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer log.Close()
+
+	cmd := exec.Command(executable, args...)
+	cmd.Stdout = log
+	cmd.Stderr = log
+	if err := detachProcess(cmd); err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start the supervisor: %w", err)
+	}
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+
+	deadline := time.After(serviceStartTimeout)
+	for {
+		st := s.status(name)
+		if st.Supervisor == cmd.Process.Pid && st.State != serviceStopped {
+			return nil
+		}
+		select {
+		case <-exited:
+			return fmt.Errorf("service '%s' exited right away; see %s", name, logPath)
+		case <-deadline:
+			return fmt.Errorf("service '%s' did not start within %s; see %s", name, serviceStartTimeout, logPath)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// stop stops a service and waits until its supervisor is gone. The
+// supervisor terminates the service like a cancelled job.
+func (s *Services) stop(name string) error {
+	st := s.status(name)
+	switch st.State {
+	case serviceStopped:
+		return fmt.Errorf("service '%s' is not running", name)
+	case serviceOrphaned:
+		if err := killProcessGroup(st.PID); err != nil {
+			return fmt.Errorf("failed to stop service '%s': %w", name, err)
+		}
+		st.State = serviceStopped
+		st.PID = 0
+		st.Since = time.Now()
+		return s.writeStatus(st)
+	}
+
+	if err := terminateProcess(st.Supervisor); err != nil {
+		return fmt.Errorf("failed to stop service '%s': %w", name, err)
+	}
+	deadline := time.Now().Add(killGracePeriod + 5*time.Second)
+	for time.Now().Before(deadline) {
+		if !pidFileHeld(s.pidPath(name)) {
+			return nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return fmt.Errorf("service '%s' did not stop within %s (supervisor pid %d)", name, killGracePeriod+5*time.Second, st.Supervisor)
+}
+
+// superviseService runs a service until ctx is cancelled, restarting it
+// according to its restart policy. It is the detached process started
+// by startService; its own output and the output of the service go to
+// the service log.
+func (e *Executor) superviseService(ctx context.Context, name string) error {
+	job, err := e.serviceJob(name)
+	if err != nil {
+		return err
+	}
+	release, err := holdPIDFile(e.services.pidPath(name))
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	logf := func(format string, args ...any) {
+		fmt.Fprintf(os.Stderr, "%s go-cmdeck: %s\n", time.Now().Format("2006-01-02 15:04:05"), fmt.Sprintf(format, args...))
+	}
+	st := serviceStatus{Name: name, Supervisor: os.Getpid(), Started: time.Now(), Log: e.services.logPath(name)}
+	setState := func(state string) {
+		st.State = state
+		st.Since = time.Now()
+		if err := e.services.writeStatus(st); err != nil {
+			logf("failed to write the status: %v", err)
+		}
+	}
+
+	delay := job.Service.restartDelay()
+	failures := 0
+	for {
+		started := time.Now()
+		exitCode, err := e.runService(ctx, job, func(pid int) {
+			logf("started %s (pid %d)", name, pid)
+			st.PID = pid
+			setState(serviceRunning)
+		})
+		st.PID = 0
+		st.ExitCode = exitCode
+		if ctx.Err() != nil {
+			// The exit code of a stopped service is that of SIGTERM.
+			st.ExitCode = 0
+			logf("stopped %s", name)
+			setState(serviceStopped)
+			return nil
+		}
+		if err != nil {
+			logf("%v", err)
+		} else {
+			logf("%s exited with code %d", name, exitCode)
+		}
+
+		if time.Since(started) >= stableRunTime {
+			delay = job.Service.restartDelay()
+			failures = 0
+		}
+		if !job.Service.restarts(exitCode) {
+			setState(serviceStopped)
+			return nil
+		}
+		if job.Service.MaxRestarts > 0 && failures >= job.Service.MaxRestarts {
+			logf("giving up after %d restarts", failures)
+			setState(serviceStopped)
+			return nil
+		}
+
+		failures++
+		st.Restarts++
+		setState(serviceRestarting)
+		logf("restarting in %s", delay)
+		select {
+		case <-ctx.Done():
+			logf("stopped %s", name)
+			setState(serviceStopped)
+			return nil
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxRestartDelay)
+	}
+}
+
+// runService runs the service once and returns its exit code. started is
+// called with the PID of the service process. Secret values are masked
+// in the log. Services have no timeout.
+func (e *Executor) runService(ctx context.Context, job Context, started func(pid int)) (int, error) {
+	overrides, err := job.resolveParams(nil)
+	if err != nil {
+		return exitInternal, fmt.Errorf("job '%s': %w", job.Name, err)
+	}
+	job = job.withValues(overrides)
+	secrets, err := e.resolveSecrets(ctx, job)
+	if err != nil {
+		return exitInternal, fmt.Errorf("job '%s': %w", job.Name, err)
+	}
+	command, env, err := e.prepareCommand(job.withValues(secrets), defaultAction)
+	if err != nil {
+		return exitInternal, fmt.Errorf("job '%s': %w", job.Name, err)
+	}
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	reap := setProcessGroup(cmd)
+	// Without secrets the service writes to the log directly.
+	var out io.Writer = os.Stderr
+	var masked *maskingWriter
+	if len(secrets) > 0 {
+		masked = &maskingWriter{w: os.Stderr, masker: newSecretMasker(secrets)}
+		out = masked
+	}
+	cmd.Stdout = out
+	cmd.Stderr = out
+
+	if err := cmd.Start(); err != nil {
+		return exitInternal, fmt.Errorf("failed to start %s: %w", job.Name, err)
+	}
+	started(cmd.Process.Pid)
+	err = cmd.Wait()
+	reap()
+	if masked != nil {
+		masked.flush()
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return commandExitCode(err), nil
+	}
+	if err != nil {
+		return exitInternal, err
+	}
+	return 0, nil
+}
+
+// maskingWriter masks secret values in the output it passes on, line by
+// line, without keeping the output.
+type maskingWriter struct {
+	w       io.Writer
+	masker  *secretMasker
+	pending []byte
+}
+
+func (w *maskingWriter) Write(p []byte) (int, error) {
+	w.pending = append(w.pending, p...)
+	i := bytes.LastIndexByte(w.pending, '\n')
+	if i < 0 {
+		return len(p), nil
+	}
+	if _, err := io.WriteString(w.w, w.masker.mask(string(w.pending[:i+1]))); err != nil {
+		return 0, err
+	}
+	w.pending = append(w.pending[:0], w.pending[i+1:]...)
+	return len(p), nil
+}
+
+func (w *maskingWriter) flush() {
+	if len(w.pending) > 0 {
+		io.WriteString(w.w, w.masker.mask(string(w.pending)))
+		w.pending = nil
+	}
+}
+
+// maxLogTail is how much of the end of a service log tailLog reads.
+const maxLogTail = 1 << 20
+
+// tailLog returns the last n lines of a service log, or as many as the
+// last maxLogTail bytes hold, and the offset they end at. n <= 0 returns
+// all of them.
+func (s *Services) tailLog(name string, n int) (string, int64, error) {
+	f, err := os.Open(s.logPath(name))
+	if os.IsNotExist(err) {
+		return "", 0, nil
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", 0, err
+	}
+	end := info.Size()
+	offset := max(end-maxLogTail, 0)
+	data := make([]byte, end-offset)
+	if _, err := f.ReadAt(data, offset); err != nil && err != io.EOF {
+		return "", 0, err
+	}
+	if offset > 0 {
+		// Drop the partial first line.
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			data = data[i+1:]
+		}
+	}
+
+	text := strings.TrimSuffix(string(data), "\n")
+	if text == "" {
+		return "", end, nil
+	}
+	lines := strings.Split(text, "\n")
+	if n > 0 && len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n") + "\n", end, nil
+}
+
+// followLog writes what is appended to a service log to w until ctx is
+// cancelled, starting at offset. A log that was rotated is followed
+// from its start.
+func (s *Services) followLog(ctx context.Context, name string, offset int64, w io.Writer) error {
+	path := s.logPath(name)
+	for {
+		f, err := os.Open(path)
+		if err == nil {
+			if info, err := f.Stat(); err == nil && info.Size() < offset {
+				offset = 0
+			}
+			n, _ := f.Seek(offset, io.SeekStart)
+			copied, err := io.Copy(w, f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+			offset = n + copied
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+// serviceRefresh is how often the TUI reads the status of the services.
+const serviceRefresh = time.Second
+
+// serviceLogLines is how many lines of a service log the details panel
+// of the TUI reads.
+const serviceLogLines = 100
+
+// serviceIcons are the status icons of services in the TUI list.
+var serviceIcons = map[string]string{
+	serviceRunning:    "●",
+	serviceRestarting: "↻",
+	serviceStopped:    "○",
+	serviceOrphaned:   "!",
+}
+
+type serviceTickMsg struct{}
+
+// serviceDoneMsg reports that starting or stopping a service finished.
+type serviceDoneMsg struct {
+	name    string
+	started bool
+	err     error
+}
+
+// serviceLog is the end of a service log shown in the details panel. It
+// is read again only when the log changed.
+type serviceLog struct {
+	name     string
+	size     int64
+	modified time.Time
+	text     string
+}
+
+func serviceTick() tea.Cmd {
+	return tea.Tick(serviceRefresh, func(time.Time) tea.Msg {
+		return serviceTickMsg{}
+	})
+}
+
+// refreshServices reads the status of the service jobs.
+func (m *model) refreshServices() {
+	m.services = make(map[string]serviceStatus)
+	for _, job := range m.contexts {
+		if job.Service != nil {
+			m.services[job.Name] = m.executor.services.status(job.Name)
+		}
+	}
+}
+
+// service returns the status of a service job. ok is false for other
+// jobs.
+func (m *model) service(job Context) (serviceStatus, bool) {
+	if job.Service == nil {
+		return serviceStatus{}, false
+	}
+	st, exists := m.services[job.Name]
+	if !exists {
+		st = serviceStatus{Name: job.Name, State: serviceStopped, Log: m.executor.services.logPath(job.Name)}
+	}
+	return st, true
+}
+
+// serviceLogTail returns the last lines of the log of a service.
+func (m *model) serviceLogTail(name string) string {
+	info, err := os.Stat(m.executor.services.logPath(name))
+	if err != nil {
+		return ""
+	}
+	cached := m.serviceLog
+	if cached.name != name || cached.size != info.Size() || !cached.modified.Equal(info.ModTime()) {
+		text, _, _ := m.executor.services.tailLog(name, serviceLogLines)
+		m.serviceLog = serviceLog{name: name, size: info.Size(), modified: info.ModTime(), text: text}
+	}
+	return m.serviceLog.text
+}
+
+// startService starts a service in the background; the outcome arrives
+// as a serviceDoneMsg.
+func (m *model) startService(job Context) tea.Cmd {
+	if st, _ := m.service(job); st.State == serviceRunning || st.State == serviceRestarting {
+		m.statusMessage = fmt.Sprintf("Service %s is already running (x: stop)", job.Name)
+		return nil
+	}
+	m.statusMessage = fmt.Sprintf("Starting service %s...", job.Name)
+	services := m.executor.services
+	return func() tea.Msg {
+		return serviceDoneMsg{name: job.Name, started: true, err: services.start(job)}
+	}
+}
+
+// stopService stops a service in the background; the outcome arrives as
+// a serviceDoneMsg.
+func (m *model) stopService(name string) tea.Cmd {
+	m.statusMessage = fmt.Sprintf("Stopping service %s...", name)
+	services := m.executor.services
+	return func() tea.Msg {
+		return serviceDoneMsg{name: name, err: services.stop(name)}
+	}
+}
+
+// serviceDone shows the outcome of starting or stopping a service.
+func (m *model) serviceDone(msg serviceDoneMsg) {
+	switch {
+	case msg.err != nil:
+		m.statusMessage = fmt.Sprintf("Error: %v", msg.err)
+	case msg.started:
+		m.statusMessage = fmt.Sprintf("Started service: %s", msg.name)
+	default:
+		m.statusMessage = fmt.Sprintf("Stopped service: %s", msg.name)
+	}
+	m.refreshServices()
+	if m.pager != nil {
+		m.refreshPager()
+	}
+}
@@ -0,0 +1,34 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+)
+
+var errServicesUnsupported = errors.New("services are not supported on this platform")
+
+func detachProcess(cmd *exec.Cmd) error {
+	return errServicesUnsupported
+}
+
+func holdPIDFile(path string) (func(), error) {
+	return nil, errServicesUnsupported
+}
+
+func pidFileHeld(path string) bool {
+	return false
+}
+
+func terminateProcess(pid int) error {
+	return errServicesUnsupported
+}
+
+func processGroupExists(pid int) bool {
+	return false
+}
+
+func killProcessGroup(pid int) error {
+	return errServicesUnsupported
+}
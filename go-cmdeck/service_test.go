@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServiceRestarts(t *testing.T) {
+	tests := []struct {
+		policy   string
+		exitCode int
+		want     bool
+	}{
+		{"", 0, false},
+		{"", 1, false},
+		{restartNo, 1, false},
+		{restartOnFailure, 0, false},
+		{restartOnFailure, 1, true},
+		{restartOnFailure, 137, true},
+		{restartAlways, 0, true},
+		{restartAlways, 1, true},
+	}
+	for _, tt := range tests {
+		s := Service{Restart: tt.policy}
+		if got := s.restarts(tt.exitCode); got != tt.want {
+			t.Errorf("restart %q, exit code %d: restarts = %v, want %v", tt.policy, tt.exitCode, got, tt.want)
+		}
+	}
+}
+
+func TestServiceRestartDelay(t *testing.T) {
+	tests := []struct {
+		delay string
+		want  time.Duration
+	}{
+		{"", defaultRestartDelay},
+		{"250ms", 250 * time.Millisecond},
+		{"2m", 2 * time.Minute},
+		{"0s", defaultRestartDelay},
+		{"-1s", defaultRestartDelay},
+		{"soon", defaultRestartDelay},
+	}
+	for _, tt := range tests {
+		s := Service{RestartDelay: tt.delay}
+		if got := s.restartDelay(); got != tt.want {
+			t.Errorf("restart_delay %q: restartDelay = %s, want %s", tt.delay, got, tt.want)
+		}
+	}
+}
+
+func TestCheckService(t *testing.T) {
+	tests := []struct {
+		name    string
+		service *Service
+		wantErr string
+	}{
+		{"not a service", nil, ""},
+		{"defaults", &Service{}, ""},
+		{"all set", &Service{Restart: restartOnFailure, RestartDelay: "5s", MaxRestarts: 3}, ""},
+		{"restart policy", &Service{Restart: "sometimes"}, "invalid restart policy"},
+		{"restart policy case", &Service{Restart: "Always"}, "invalid restart policy"},
+		{"restart_delay", &Service{Restart: restartAlways, RestartDelay: "soon"}, "invalid restart_delay"},
+		{"max_restarts", &Service{Restart: restartAlways, MaxRestarts: -1}, "max_restarts must not be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Context{Name: "web", Service: tt.service}.checkService()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("checkService = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSuperviseServiceGivesUp(t *testing.T) {
+	// The service counts its runs in a file in the working directory and
+	// always fails.
+	cli, _ := newTestCLI(t, map[string]Context{
+		"web": {
+			Name: "web", Label: "Web",
+			Commands: map[string]string{"run": `echo x >> runs; exit 3`},
+			Service:  &Service{Restart: restartOnFailure, RestartDelay: "1ms", MaxRestarts: 2},
+		},
+	})
+	services := cli.executor.services
+	if err := os.MkdirAll(services.dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cli.executor.superviseService(context.Background(), "web"); err != nil {
+		t.Fatal(err)
+	}
+	runs, err := os.ReadFile("runs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(runs), "x"); n != 3 {
+		t.Errorf("service ran %d times, want the first run and 2 restarts", n)
+	}
+	st := services.status("web")
+	if st.State != serviceStopped || st.Restarts != 2 || st.ExitCode != 3 {
+		t.Errorf("status = %+v, want stopped after 2 restarts with exit code 3", st)
+	}
+}
@@ -0,0 +1,105 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// detachProcess starts the command in a new session, so that it is not
+// stopped together with the terminal or the process group of go-cmdeck.
+func detachProcess(cmd *exec.Cmd) error {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	return nil
+}
+
+// holdPIDFile writes the PID of this process to path and locks the file
+// until the returned function is called, which also removes it. Another
+// process holding the lock means the service is already running.
+func holdPIDFile(path string) (func(), error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	// A status check may hold a shared lock for a moment.
+	deadline := time.Now().Add(time.Second)
+	for {
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) || time.Now().After(deadline) {
+			f.Close()
+			if errors.Is(err, syscall.EWOULDBLOCK) {
+				return nil, fmt.Errorf("already running (%s is locked)", path)
+			}
+			return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// The previous supervisor may have removed the file while this one
+	// was waiting for the lock.
+	locked, err := f.Stat()
+	if current, statErr := os.Stat(path); err != nil || statErr != nil || !os.SameFile(locked, current) {
+		f.Close()
+		return nil, fmt.Errorf("%s was replaced meanwhile", path)
+	}
+
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return func() {
+		os.Remove(path)
+		f.Close()
+	}, nil
+}
+
+// pidFileHeld reports whether a supervisor holds the lock of the PID
+// file at path.
+func pidFileHeld(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_SH|syscall.LOCK_NB)
+	if err != nil {
+		return errors.Is(err, syscall.EWOULDBLOCK)
+	}
+	syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	return false
+}
+
+// terminateProcess asks a supervisor to stop.
+func terminateProcess(pid int) error {
+	return syscall.Kill(pid, syscall.SIGTERM)
+}
+
+// processGroupExists reports whether the process group of a service
+// still has processes.
+func processGroupExists(pid int) bool {
+	return syscall.Kill(-pid, 0) == nil
+}
+
+// killProcessGroup stops the process group of a service like a
+// cancelled job: SIGTERM first and SIGKILL after killGracePeriod.
+func killProcessGroup(pid int) error {
+	if err := syscall.Kill(-pid, syscall.SIGTERM); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(killGracePeriod)
+	for time.Now().Before(deadline) {
+		if !processGroupExists(pid) {
+			return nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return syscall.Kill(-pid, syscall.SIGKILL)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// State is the runtime state of the jobs, kept in its own file apart
+// from the definitions users edit: the last result of each job and of
+// each of its actions, the last health check of each job, the last
+// scheduled time the scheduler handled for each job and the job last
+// selected in the TUI.
+type State struct {
+	Jobs         map[string]*JobState     `json:"jobs"`
+	Health       map[string]*HealthStatus `json:"health,omitempty"`
+	Scheduled    map[string]time.Time     `json:"scheduled,omitempty"`
+	LastSelected string                   `json:"last_selected,omitempty"`
+}
+
+// JobState is the state of one job. The fields are applied to the
+// job's LastResult and ActionResults.
+type JobState struct {
+	LastResult    *ExecutionResult            `json:"last_result,omitempty"`
+	ActionResults map[string]*ExecutionResult `json:"action_results,omitempty"`
+}
+
+// getStatePath returns the state file of the configuration file at
+// configPath (see getDataDir).
+func getStatePath(configPath string) string {
+	return filepath.Join(getDataDir(configPath), "state.json")
+}
+
+// StateStore keeps the state in a JSON file that several go-cmdeck
+// processes may update at the same time.
+type StateStore struct {
+	path string
+}
+
+func NewStateStore(path string) *StateStore {
+	return &StateStore{path: path}
+}
+
+// load reads the state file. A missing file is an empty state.
+func (s *StateStore) load() (*State, error) {
+	state := &State{Jobs: make(map[string]*JobState)}
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("%s: %w", s.path, err)
+	}
+	if state.Jobs == nil {
+		state.Jobs = make(map[string]*JobState)
+	}
+	return state, nil
+}
+
+// update applies change to the current state file and writes it back if
+// change reports a modification. The file is locked meanwhile, so that
+// updates of other processes are not lost. It returns the new state.
+func (s *StateStore) update(change func(*State) bool) (*State, error) {
+	unlock, err := lockFile(lockPath(s.path))
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	state, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	if !change(state) {
+		return state, nil
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(s.path, data, 0644); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// record stores results as the last results of their jobs and actions.
+// A result never replaces a newer one recorded by another process.
+func (s *StateStore) record(results ...*ExecutionResult) (*State, error) {
+	return s.update(func(state *State) bool {
+		changed := false
+		for _, result := range results {
+			job := state.job(result.Job)
+			if job.LastResult == nil || !job.LastResult.Timestamp.After(result.Timestamp) {
+				job.LastResult = result
+				changed = true
+			}
+			if last := job.ActionResults[result.Action]; last == nil || !last.Timestamp.After(result.Timestamp) {
+				job.ActionResults[result.Action] = result
+				changed = true
+			}
+		}
+		return changed
+	})
+}
+
+// setLastSelected remembers the job the TUI cursor was on when it was
+// closed.
+func (s *StateStore) setLastSelected(name string) error {
+	_, err := s.update(func(state *State) bool {
+		if state.LastSelected == name {
+			return false
+		}
+		state.LastSelected = name
+		return true
+	})
+	return err
+}
+
+func (s *State) job(name string) *JobState {
+	job, exists := s.Jobs[name]
+	if !exists {
+		job = &JobState{}
+		s.Jobs[name] = job
+	}
+	if job.ActionResults == nil {
+		job.ActionResults = make(map[string]*ExecutionResult)
+	}
+	return job
+}
+
+// applyState sets the results of the jobs from the state. Jobs without
+// state keep the results they were loaded with.
+func (c *Config) applyState(state *State) {
+	for name, job := range c.Contexts {
+		jobState, exists := state.Jobs[name]
+		if !exists {
+			continue
+		}
+		if jobState.LastResult != nil {
+			job.LastResult = jobState.LastResult
+		}
+		if len(jobState.ActionResults) > 0 {
+			job.ActionResults = jobState.ActionResults
+		}
+		c.Contexts[name] = job
+	}
+}
+
+// loadState applies the state file to the jobs. Results that older
+// versions stored in the configuration files are moved to the state file
+// for jobs that have no state yet; they are dropped from the
+// configuration the next time it is saved.
+func (e *Executor) loadState() error {
+	state, err := e.state.load()
+	if err != nil {
+		return fmt.Errorf("failed to load state: %w", err)
+	}
+
+	legacy := make(map[string]*JobState)
+	for name, job := range e.config.Contexts {
+		if _, exists := state.Jobs[name]; !exists && (job.LastResult != nil || len(job.ActionResults) > 0) {
+			legacy[name] = &JobState{LastResult: job.LastResult, ActionResults: job.ActionResults}
+		}
+	}
+	if len(legacy) > 0 {
+		state, err = e.state.update(func(state *State) bool {
+			changed := false
+			for name, jobState := range legacy {
+				if _, exists := state.Jobs[name]; !exists {
+					state.Jobs[name] = jobState
+					changed = true
+				}
+			}
+			return changed
+		})
+		if err != nil {
+			return fmt.Errorf("failed to move results to the state file: %w", err)
+		}
+	}
+
+	e.config.applyState(state)
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// tagPattern matches valid tags: one word without commas, so that tags
+// can be given comma-separated on the command line.
+var tagPattern = regexp.MustCompile(`^[^\s,]+$`)
+
+// checkTags reports invalid or repeated tags of the job.
+func (c Context) checkTags() error {
+	seen := make(map[string]bool)
+	for _, tag := range c.Tags {
+		if !tagPattern.MatchString(tag) {
+			return fmt.Errorf("job '%s': invalid tag %q (tags cannot contain spaces or commas)", c.Name, tag)
+		}
+		if seen[strings.ToLower(tag)] {
+			return fmt.Errorf("job '%s': duplicate tag '%s'", c.Name, tag)
+		}
+		seen[strings.ToLower(tag)] = true
+	}
+	return nil
+}
+
+// hasTags reports whether the job has all of the tags. Tags are
+// compared case-insensitively, like labels.
+func (c Context) hasTags(tags []string) bool {
+	for _, tag := range tags {
+		found := false
+		for _, own := range c.Tags {
+			if strings.EqualFold(own, tag) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// jobsWithTags returns the jobs that have all of the tags, sorted by
+// name. No tags return every job.
+func (e *Executor) jobsWithTags(tags []string) []Context {
+	jobs := make([]Context, 0)
+	for _, job := range e.listContexts() {
+		if job.hasTags(tags) {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs
+}
+
+// tagFilter describes a label and tag filter for messages, such as
+// "label 'Web' and tag 'infra'".
+func tagFilter(label string, tags []string) string {
+	var parts []string
+	if label != "" {
+		parts = append(parts, fmt.Sprintf("label '%s'", label))
+	}
+	for _, tag := range tags {
+		parts = append(parts, fmt.Sprintf("tag '%s'", tag))
+	}
+	return strings.Join(parts, " and ")
+}
+
+// removeTag returns tags without tag.
+func removeTag(tags []string, tag string) ([]string, bool) {
+	for i, own := range tags {
+		if strings.EqualFold(own, tag) {
+			return append(tags[:i:i], tags[i+1:]...), true
+		}
+	}
+	return tags, false
+}
+
+// sortedTags returns the distinct tags of the jobs in alphabetical
+// order. Tags that differ only in case are the same tag; the first
+// spelling is used.
+func sortedTags(jobs []Context) []string {
+	seen := make(map[string]bool)
+	var tags []string
+	for _, job := range jobs {
+		for _, tag := range job.Tags {
+			if !seen[strings.ToLower(tag)] {
+				seen[strings.ToLower(tag)] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+	sort.Slice(tags, func(i, j int) bool { return strings.ToLower(tags[i]) < strings.ToLower(tags[j]) })
+	return tags
+}
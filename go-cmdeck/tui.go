@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"os"
 	"strings"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
 )
 
 type TUI struct {
@@ -14,16 +17,113 @@ type TUI struct {
 }
 
 type model struct {
-	executor     *Executor
-	contexts     []Context
-	cursor       int
-	selected     map[int]struct{}
-	currentView  string
-	lastOutput   string
-	showOutput   bool
-	theme        ColorTheme
-	width        int
-	height       int
+	executor      *Executor
+	contexts      []Context
+	rows          []listRow
+	collapsed     map[string]bool
+	cursor        int
+	selected      map[int]struct{}
+	currentView   string
+	lastOutput    string
+	showOutput    bool
+	theme         ColorTheme
+	width         int
+	height        int
+	running       map[string]*jobRun
+	spinnerFrame  int
+	picking       bool
+	actionJob     string
+	actionChoices []string
+	actionCursor  int
+	form          *paramForm
+	statusMessage string
+	batch         *batchRun
+	filtering     bool
+	filter        string
+	matches       map[int]jobMatch
+	pager         *outputPager
+	jobForm       *jobForm
+	deleting      string
+	services      map[string]serviceStatus
+	serviceLog    serviceLog
+	health        map[string]HealthStatus
+	checking      map[string]bool
+	scheduler     bool
+	quitting      chan struct{}
+}
+
+// listRow is a line of the job list: the header of a tag group or a job,
+// given by its index in contexts. Jobs with several tags appear in each
+// of their groups.
+type listRow struct {
+	tag    string
+	job    int
+	header bool
+}
+
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// jobRun is a job action executing in the background. Its output lines
+// and the final result are delivered to Update through events. done is
+// closed when the run finished, with result and err set, so that the
+// TUI can wait for it after quitting. Runs started for selected jobs
+// belong to batch, as its job index.
+type jobRun struct {
+	name    string
+	action  string
+	started time.Time
+	lines   []string
+	attempt string
+	events  chan tea.Msg
+	done    chan struct{}
+	result  *ExecutionResult
+	err     error
+	cancel  context.CancelFunc
+	batch   *batchRun
+	index   int
+}
+
+// jobOutputMsg is a line of output of a run, or with attempt the
+// attempt it is at, such as "attempt 2/5".
+type jobOutputMsg struct {
+	key     string
+	line    string
+	attempt bool
+}
+
+func runKey(name, action string) string {
+	return name + "/" + action
+}
+
+type jobDoneMsg struct {
+	key    string
+	result *ExecutionResult
+	err    error
+}
+
+type spinnerTickMsg struct{}
+
+// paramForm asks for the parameters of a job before it is run.
+type paramForm struct {
+	name   string
+	label  string
+	action string
+	params []Param
+	values []string
+	cursor int
+	err    string
+}
+
+func waitForJobEvent(events chan tea.Msg) tea.Cmd {
+	return func() tea.Msg {
+		return <-events
+	}
+}
+
+func spinnerTick() tea.Cmd {
+	return tea.Tick(100*time.Millisecond, func(time.Time) tea.Msg {
+		return spinnerTickMsg{}
+	})
 }
 
 func getStyles(theme ColorTheme, width, height int) (titleStyle, selectedStyle, topPanelStyle, bottomPanelStyle, outputTitleStyle lipgloss.Style) {
@@ -77,6 +177,11 @@ func (t *TUI) Run() error {
 		executor:    t.executor,
 		contexts:    contexts,
 		selected:    make(map[int]struct{}),
+		collapsed:   make(map[string]bool),
+		running:     make(map[string]*jobRun),
+		health:      make(map[string]HealthStatus),
+		checking:    make(map[string]bool),
+		quitting:    make(chan struct{}),
 		currentView: "list",
 		lastOutput:  "Ready to execute commands...",
 		showOutput:  true,
@@ -85,13 +190,30 @@ func (t *TUI) Run() error {
 		height:      24,
 	}
 
+	m.buildRows()
+	m.refreshServices()
+	m.scheduler = schedulerRunning(t.executor.config.userLayer().Path)
+	if state, err := t.executor.state.load(); err == nil {
+		m.selectJob(state.LastSelected)
+		m.loadHealth(state)
+	}
+
 	p := tea.NewProgram(&m, tea.WithAltScreen())
 	_, err := p.Run()
-	return err
+	m.stopRuns()
+	if err != nil {
+		return err
+	}
+	if i, ok := m.currentJob(); ok {
+		if err := t.executor.state.setLastSelected(m.contexts[i].Name); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to remember the selected job: %v\n", err)
+		}
+	}
+	return nil
 }
 
 func (m *model) Init() tea.Cmd {
-	return nil
+	return tea.Batch(append(m.dueChecks(), serviceTick(), healthTick())...)
 }
 
 func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -99,62 +221,744 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case tea.WindowSizeMsg:
 		m.width = msg.Width
 		m.height = msg.Height
+		if m.pager != nil {
+			m.refreshPager()
+		}
 		return m, nil
 	case tea.KeyMsg:
+		if m.pager != nil {
+			return m, m.updatePager(msg)
+		}
+		if m.jobForm != nil {
+			return m, m.updateJobForm(msg)
+		}
+		if m.deleting != "" {
+			return m, m.updateDelete(msg)
+		}
+		if m.form != nil {
+			return m, m.updateParamForm(msg)
+		}
+		if m.picking {
+			return m, m.updateActionPicker(msg)
+		}
+		if m.filtering {
+			return m, m.updateFilter(msg)
+		}
 		switch msg.String() {
 		case "ctrl+c", "q":
-			return m, tea.Quit
+			return m, m.quit()
 		case "up", "k":
 			if m.cursor > 0 {
 				m.cursor--
 			}
 		case "down", "j":
-			if m.cursor < len(m.contexts)-1 {
+			if m.cursor < len(m.rows)-1 {
 				m.cursor++
 			}
+		case "home", "g":
+			m.moveCursor(-len(m.rows))
+		case "end", "G":
+			m.moveCursor(len(m.rows))
+		case "pgup", "ctrl+b":
+			m.moveCursor(-m.listHeight())
+		case "pgdown", "ctrl+f":
+			m.moveCursor(m.listHeight())
+		case "/":
+			m.filtering = true
+		case "tab":
+			if m.cursor < len(m.rows) {
+				m.toggleSelected(m.cursor)
+				if m.cursor < len(m.rows)-1 {
+					m.cursor++
+				}
+			}
+		case "esc":
+			if m.filter != "" {
+				m.setFilter("")
+			} else {
+				m.selected = make(map[int]struct{})
+			}
+		case "left", "h":
+			if m.grouped() && m.cursor < len(m.rows) {
+				m.setCollapsed(m.rows[m.cursor].tag, true)
+			}
+		case "right", "l":
+			if m.cursor < len(m.rows) && m.rows[m.cursor].header {
+				m.setCollapsed(m.rows[m.cursor].tag, false)
+			}
+		case "enter":
+			if m.cursor < len(m.rows) && m.rows[m.cursor].header {
+				tag := m.rows[m.cursor].tag
+				m.setCollapsed(tag, !m.collapsed[strings.ToLower(tag)])
+			} else {
+				m.openPager()
+			}
+		case "o":
+			m.openPager()
+		case "n":
+			m.openJobForm(jobFormNew)
+		case "e":
+			m.openJobForm(jobFormEdit)
+		case "c":
+			m.openJobForm(jobFormDuplicate)
+		case "d":
+			m.requestDelete()
 		case " ":
-			if len(m.contexts) > 0 {
-				currentContextName := m.contexts[m.cursor].Name
-				context := m.executor.config.Contexts[currentContextName]
-				
-				if runCmd, exists := context.Commands["run"]; exists {
-					output, exitCode, err := m.executor.executeJobWithOutput(runCmd, context.Variables)
-					
-					result := &ExecutionResult{
-						Timestamp: time.Now(),
-						Success:   err == nil && exitCode == 0,
-						ExitCode:  exitCode,
-						Output:    output,
-					}
-					
-					context.LastResult = result
-					m.executor.config.Contexts[currentContextName] = context
-					m.executor.config.save()
-					
-					oldCursor := m.cursor
-					m.contexts = m.executor.listContexts()
-					
-					for i, ctx := range m.contexts {
-						if ctx.Name == currentContextName {
-							m.cursor = i
-							break
-						}
-					}
-					
-					if m.cursor >= len(m.contexts) {
-						m.cursor = oldCursor
-						if m.cursor >= len(m.contexts) {
-							m.cursor = len(m.contexts) - 1
-						}
-					}
+			if len(m.selected) > 0 {
+				return m, m.startBatch()
+			}
+			if m.cursor < len(m.rows) && m.rows[m.cursor].header {
+				tag := m.rows[m.cursor].tag
+				m.setCollapsed(tag, !m.collapsed[strings.ToLower(tag)])
+			} else if i, ok := m.currentJob(); ok {
+				context := m.contexts[i]
+				if context.Service != nil {
+					return m, m.startService(context)
 				}
+				if _, exists := context.Commands[defaultAction]; exists {
+					return m, m.requestJob(context.Name, defaultAction)
+				}
+				m.openActionPicker()
+			}
+		case "a":
+			m.openActionPicker()
+		case "x":
+			// x cancels a run in the TUI first, then stops the service.
+			if i, ok := m.currentJob(); ok {
+				name := m.contexts[i].Name
+				if _, isRunning := m.runningJob(name); !isRunning && m.contexts[i].Service != nil {
+					return m, m.stopService(name)
+				}
+				m.cancelJob(name)
+			}
+		}
+	case jobOutputMsg:
+		if run, exists := m.running[msg.key]; exists {
+			switch {
+			case !msg.attempt:
+				run.lines = append(run.lines, msg.line)
+			case msg.line != "":
+				run.attempt = msg.line
+				run.lines = append(run.lines, "--- "+msg.line+" ---")
+			default:
+				run.attempt = ""
+			}
+			if m.pager != nil && m.pager.job == run.name {
+				m.pager.stale = true
+			}
+			return m, waitForJobEvent(run.events)
+		}
+	case jobDoneMsg:
+		run := m.running[msg.key]
+		delete(m.running, msg.key)
+		if msg.err != nil {
+			m.statusMessage = fmt.Sprintf("Error: %v", msg.err)
+			if m.pager != nil {
+				m.refreshPager()
+			}
+			if run != nil && run.batch != nil {
+				run.batch.skip(run.index)
+				return m, m.continueBatch()
+			}
+			return m, nil
+		}
+		m.statusMessage = ""
+		if err := m.executor.recordResult(msg.result); err != nil {
+			m.statusMessage = fmt.Sprintf("Error: %v", err)
+		}
+		notify := m.notify(msg.result)
+		m.refreshContexts()
+		if m.pager != nil {
+			m.refreshPager()
+		}
+		if run != nil && run.batch != nil {
+			run.batch.finish(run.index, msg.result)
+			return m, tea.Batch(notify, m.continueBatch())
+		}
+		return m, notify
+	case notifyMsg:
+		if msg.err != nil {
+			m.statusMessage = fmt.Sprintf("Error: %v", msg.err)
+		}
+	case clipboardMsg:
+		if m.pager != nil && msg.err != nil {
+			m.pager.message = fmt.Sprintf("Error: failed to copy the output: %v", msg.err)
+		} else if m.pager != nil {
+			m.pager.message = fmt.Sprintf("Copied %d bytes to the clipboard", msg.size)
+		}
+	case serviceDoneMsg:
+		m.serviceDone(msg)
+	case healthTickMsg:
+		return m, tea.Batch(append(m.dueChecks(), healthTick())...)
+	case healthMsg:
+		delete(m.checking, msg.status.Job)
+		m.health[msg.status.Job] = msg.status
+		if msg.err != nil {
+			m.statusMessage = fmt.Sprintf("Error: %v", msg.err)
+		}
+	case serviceTickMsg:
+		m.refreshServices()
+		m.scheduler = schedulerRunning(m.executor.config.userLayer().Path)
+		if m.pager != nil {
+			if job, exists := m.pagerJob(); exists && job.Service != nil {
+				m.refreshPager()
 			}
 		}
+		return m, serviceTick()
+	case spinnerTickMsg:
+		// Laying out the whole output for every line would take
+		// quadratic time on chatty jobs.
+		if m.pager != nil && m.pager.stale {
+			m.refreshPager()
+		}
+		if len(m.running) > 0 {
+			m.spinnerFrame = (m.spinnerFrame + 1) % len(spinnerFrames)
+			return m, spinnerTick()
+		}
 	}
 	return m, nil
 }
 
+// quit cancels the running jobs and ends the TUI. Run waits for the
+// jobs to stop.
+func (m *model) quit() tea.Cmd {
+	for _, run := range m.running {
+		run.cancel()
+	}
+	select {
+	case <-m.quitting:
+	default:
+		close(m.quitting)
+	}
+	return tea.Quit
+}
+
+// stopRuns waits for the jobs cancelled when the TUI quit until their
+// process groups are gone, at most as long as the SIGKILL after
+// killGracePeriod takes, and records their results.
+func (m *model) stopRuns() {
+	if len(m.running) == 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Waiting for %d running jobs to stop...\n", len(m.running))
+	deadline := time.After(killGracePeriod + 2*time.Second)
+	for _, run := range m.running {
+		select {
+		case <-run.done:
+		case <-deadline:
+			fmt.Fprintf(os.Stderr, "Warning: running jobs did not stop within %s\n", killGracePeriod+2*time.Second)
+			return
+		}
+		if run.err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", run.err)
+		} else if err := m.executor.recordResult(run.result); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}
+}
+
+func (m *model) openActionPicker() {
+	i, ok := m.currentJob()
+	if !ok {
+		return
+	}
+	actions := m.contexts[i].actions()
+	if len(actions) == 0 {
+		return
+	}
+	m.picking = true
+	m.actionJob = m.contexts[i].Name
+	m.actionChoices = actions
+	m.actionCursor = 0
+}
+
+func (m *model) updateActionPicker(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "ctrl+c":
+		return m.quit()
+	case "esc", "q", "a":
+		m.picking = false
+	case "up", "k":
+		if m.actionCursor > 0 {
+			m.actionCursor--
+		}
+	case "down", "j":
+		if m.actionCursor < len(m.actionChoices)-1 {
+			m.actionCursor++
+		}
+	case "enter", " ":
+		m.picking = false
+		return m.requestJob(m.actionJob, m.actionChoices[m.actionCursor])
+	}
+	return nil
+}
+
+// requestJob starts a job action, first showing the parameter form if
+// the job declares parameters.
+func (m *model) requestJob(name, action string) tea.Cmd {
+	job, exists := m.executor.config.Contexts[name]
+	if !exists || len(job.Params) == 0 {
+		return m.startJob(name, action, nil)
+	}
+
+	form := &paramForm{name: name, label: job.Label, action: action, params: job.Params}
+	for _, p := range job.Params {
+		value := p.Default
+		if value == "" {
+			value = job.Variables[p.Name]
+		}
+		form.values = append(form.values, value)
+	}
+	m.form = form
+	return nil
+}
+
+func (m *model) updateParamForm(msg tea.KeyMsg) tea.Cmd {
+	form := m.form
+	form.err = ""
+	param := form.params[form.cursor]
+	switch msg.String() {
+	case "ctrl+c":
+		return m.quit()
+	case "esc":
+		m.form = nil
+	case "up", "shift+tab":
+		if form.cursor > 0 {
+			form.cursor--
+		}
+	case "down", "tab":
+		if form.cursor < len(form.params)-1 {
+			form.cursor++
+		}
+	case "left", "right":
+		if len(param.Allowed) == 0 {
+			break
+		}
+		i := -1
+		for j, allowed := range param.Allowed {
+			if allowed == form.values[form.cursor] {
+				i = j
+			}
+		}
+		if msg.String() == "left" {
+			i = (i - 1 + len(param.Allowed)) % len(param.Allowed)
+		} else {
+			i = (i + 1) % len(param.Allowed)
+		}
+		form.values[form.cursor] = param.Allowed[i]
+	case "backspace":
+		if value := []rune(form.values[form.cursor]); len(value) > 0 && len(param.Allowed) == 0 {
+			form.values[form.cursor] = string(value[:len(value)-1])
+		}
+	case "enter":
+		values := make(map[string]string, len(form.params))
+		for i, p := range form.params {
+			if err := p.check(form.values[i]); err != nil {
+				form.err = err.Error()
+				form.cursor = i
+				return nil
+			}
+			values[p.Name] = form.values[i]
+		}
+		m.form = nil
+		return m.startJob(form.name, form.action, values)
+	default:
+		if (msg.Type == tea.KeyRunes || msg.Type == tea.KeySpace) && len(param.Allowed) == 0 {
+			form.values[form.cursor] += string(msg.Runes)
+		}
+	}
+	return nil
+}
+
+// cancelJob stops every running action of the job. The runs finish with
+// a cancelled result once their process group has exited.
+func (m *model) cancelJob(name string) {
+	for _, run := range m.running {
+		if run.name == name {
+			run.cancel()
+			m.statusMessage = fmt.Sprintf("Cancelling %s (%s)...", name, run.action)
+		}
+	}
+}
+
+// runningJob returns a running action of the job, if any.
+func (m *model) runningJob(name string) (*jobRun, bool) {
+	for _, run := range m.running {
+		if run.name == name {
+			return run, true
+		}
+	}
+	return nil, false
+}
+
+// startJob runs a job action in the background and returns the command
+// that feeds its output into Update. An action that is already running
+// is ignored. values set variables and parameters of the run.
+func (m *model) startJob(name, action string, values map[string]string) tea.Cmd {
+	key := runKey(name, action)
+	if _, running := m.running[key]; running {
+		return nil
+	}
+
+	// The plan is resolved here so that the goroutine below only works
+	// on copies of the job definitions.
+	plan, err := m.executor.planWorkflow(name, action, values)
+	if err != nil {
+		m.statusMessage = fmt.Sprintf("Error: %v", err)
+		return nil
+	}
+	m.statusMessage = ""
+
+	return m.startRun(&jobRun{name: name, action: action}, func(ctx context.Context, onLine func(stream, line string)) (*ExecutionResult, error) {
+		return m.executor.executeWorkflow(ctx, plan, onLine)
+	})
+}
+
+// startRun executes a run in the background and returns the command
+// that feeds its output into Update.
+func (m *model) startRun(run *jobRun, execute func(context.Context, func(stream, line string)) (*ExecutionResult, error)) tea.Cmd {
+	key := runKey(run.name, run.action)
+	ctx, cancel := context.WithCancel(context.Background())
+	run.started = time.Now()
+	run.events = make(chan tea.Msg, 64)
+	run.done = make(chan struct{})
+	run.cancel = cancel
+	m.running[key] = run
+
+	// Once the TUI quits nobody reads events any more.
+	quitting := m.quitting
+	send := func(msg tea.Msg) {
+		select {
+		case run.events <- msg:
+		case <-quitting:
+		}
+	}
+	go func() {
+		defer cancel()
+		result, err := execute(ctx, func(stream, line string) {
+			switch stream {
+			case streamAttempt:
+				send(jobOutputMsg{key: key, line: line, attempt: true})
+				return
+			case "stderr":
+				line = "[stderr] " + line
+			}
+			send(jobOutputMsg{key: key, line: line})
+		})
+		run.result, run.err = result, err
+		close(run.done)
+		send(jobDoneMsg{key: key, result: result, err: err})
+	}()
+
+	cmds := []tea.Cmd{waitForJobEvent(run.events)}
+	if len(m.running) == 1 {
+		cmds = append(cmds, spinnerTick())
+	}
+	return tea.Batch(cmds...)
+}
+
+// startBatch runs the selected jobs and their dependencies once each,
+// up to max_parallel at a time. Jobs start as soon as their
+// dependencies succeeded.
+func (m *model) startBatch() tea.Cmd {
+	if m.batch != nil {
+		m.statusMessage = "Error: the selected jobs of the last batch are still running"
+		return nil
+	}
+	var names []string
+	for i, job := range m.contexts {
+		if _, exists := m.selected[i]; exists {
+			names = append(names, job.Name)
+		}
+	}
+	jobs, err := m.executor.planBatch(names, nil)
+	if err != nil {
+		m.statusMessage = fmt.Sprintf("Error: %v", err)
+		return nil
+	}
+	for _, job := range jobs {
+		if _, running := m.running[runKey(job.Name, defaultAction)]; running {
+			m.statusMessage = fmt.Sprintf("Error: job '%s' is already running", job.Name)
+			return nil
+		}
+	}
+
+	m.selected = make(map[int]struct{})
+	m.batch = newBatchRun(jobs, m.executor.config.maxParallel())
+	m.statusMessage = ""
+	return m.continueBatch()
+}
+
+// continueBatch starts the jobs of the batch that became ready and
+// reports the outcome once every job is done or skipped.
+func (m *model) continueBatch() tea.Cmd {
+	batch := m.batch
+	if batch == nil {
+		return nil
+	}
+	var cmds []tea.Cmd
+	for _, i := range batch.next() {
+		job := batch.jobs[i]
+		cmds = append(cmds, m.startRun(&jobRun{name: job.Name, action: defaultAction, batch: batch, index: i},
+			func(ctx context.Context, onLine func(stream, line string)) (*ExecutionResult, error) {
+				return m.executor.executeJob(ctx, job, defaultAction, onLine)
+			}))
+	}
+	if batch.finished() {
+		succeeded, failed, skipped := batch.counts()
+		if m.statusMessage == "" {
+			m.statusMessage = fmt.Sprintf("Finished %d jobs: %d succeeded, %d failed, %d skipped",
+				len(batch.jobs), succeeded, failed, skipped)
+		}
+		m.batch = nil
+	}
+	return tea.Batch(cmds...)
+}
+
+// batchState returns the state of the job in the running batch, if it
+// is part of one.
+func (m *model) batchState(name string) (int, bool) {
+	if m.batch == nil {
+		return 0, false
+	}
+	i, exists := m.batch.index[name]
+	if !exists {
+		return 0, false
+	}
+	return m.batch.states[i], true
+}
+
+// groupJobs returns the jobs of a tag group as indexes in contexts. The
+// group "" holds the jobs without tags.
+func (m *model) groupJobs(tag string) []int {
+	var jobs []int
+	for i, job := range m.contexts {
+		if tag == "" && len(job.Tags) == 0 || tag != "" && job.hasTags([]string{tag}) {
+			jobs = append(jobs, i)
+		}
+	}
+	return jobs
+}
+
+// buildRows lays out the job list. With a filter it lists the matching
+// jobs, best match first. Without tags it lists the jobs as they are;
+// otherwise the jobs are grouped by tag, followed by the jobs
+// without tags, and collapsed groups show only their header.
+func (m *model) buildRows() {
+	m.rows = nil
+	m.matches = nil
+	if m.filter != "" {
+		m.filterRows()
+		return
+	}
+	tags := sortedTags(m.contexts)
+	if len(tags) == 0 {
+		for i := range m.contexts {
+			m.rows = append(m.rows, listRow{job: i})
+		}
+		return
+	}
+	for _, tag := range append(tags, "") {
+		jobs := m.groupJobs(tag)
+		if len(jobs) == 0 {
+			continue
+		}
+		m.rows = append(m.rows, listRow{tag: tag, job: -1, header: true})
+		if m.collapsed[strings.ToLower(tag)] {
+			continue
+		}
+		for _, i := range jobs {
+			m.rows = append(m.rows, listRow{tag: tag, job: i})
+		}
+	}
+}
+
+// grouped reports whether the list is grouped by tag.
+func (m *model) grouped() bool {
+	return len(m.rows) > 0 && m.rows[0].header
+}
+
+// currentJob returns the index in contexts of the job under the cursor.
+// ok is false on a group header and in an empty list.
+func (m *model) currentJob() (int, bool) {
+	if m.cursor >= len(m.rows) || m.rows[m.cursor].header {
+		return 0, false
+	}
+	return m.rows[m.cursor].job, true
+}
+
+// setCollapsed folds or unfolds a tag group and moves the cursor to its
+// header.
+func (m *model) setCollapsed(tag string, collapsed bool) {
+	m.collapsed[strings.ToLower(tag)] = collapsed
+	m.buildRows()
+	for i, row := range m.rows {
+		if row.header && row.tag == tag {
+			m.cursor = i
+			return
+		}
+	}
+}
+
+// toggleSelected marks or unmarks the job of a row. On a group header it
+// marks every job of the group, or unmarks them if all are marked.
+func (m *model) toggleSelected(row int) {
+	jobs := []int{m.rows[row].job}
+	if m.rows[row].header {
+		jobs = m.groupJobs(m.rows[row].tag)
+	}
+	all := true
+	for _, i := range jobs {
+		if _, exists := m.selected[i]; !exists {
+			all = false
+		}
+	}
+	for _, i := range jobs {
+		if all {
+			delete(m.selected, i)
+		} else {
+			m.selected[i] = struct{}{}
+		}
+	}
+}
+
+// refreshContexts reloads the job list and keeps the cursor and the
+// selection on the jobs they were pointing at.
+func (m *model) refreshContexts() {
+	var current listRow
+	currentContextName := ""
+	if m.cursor < len(m.rows) {
+		current = m.rows[m.cursor]
+		if !current.header {
+			currentContextName = m.contexts[current.job].Name
+		}
+	}
+	selectedNames := make(map[string]bool)
+	for i := range m.selected {
+		if i < len(m.contexts) {
+			selectedNames[m.contexts[i].Name] = true
+		}
+	}
+
+	oldCursor := m.cursor
+	m.contexts = m.executor.listContexts()
+	m.selected = make(map[int]struct{})
+	for i, ctx := range m.contexts {
+		if selectedNames[ctx.Name] {
+			m.selected[i] = struct{}{}
+		}
+	}
+	m.buildRows()
+
+	for i, row := range m.rows {
+		if row.header && current.header && row.tag == current.tag ||
+			!row.header && !current.header && row.tag == current.tag && m.contexts[row.job].Name == currentContextName {
+			m.cursor = i
+			return
+		}
+	}
+	for i, row := range m.rows {
+		if !row.header && m.contexts[row.job].Name == currentContextName {
+			m.cursor = i
+			return
+		}
+	}
+
+	m.cursor = oldCursor
+	if m.cursor >= len(m.rows) {
+		m.cursor = len(m.rows) - 1
+	}
+	if m.cursor < 0 {
+		m.cursor = 0
+	}
+}
+
+// groupName is how a tag group is shown; "" holds the jobs without tags.
+func groupName(tag string) string {
+	if tag == "" {
+		return "(no tag)"
+	}
+	return tag
+}
+
+// groupHeader renders the list line of a tag group header.
+func (m *model) groupHeader(tag, cursor string) string {
+	fold := "▾"
+	if m.collapsed[strings.ToLower(tag)] {
+		fold = "▸"
+	}
+	jobs := m.groupJobs(tag)
+	selected, running := 0, 0
+	for _, i := range jobs {
+		if _, isSelected := m.selected[i]; isSelected {
+			selected++
+		}
+		if _, isRunning := m.runningJob(m.contexts[i].Name); isRunning {
+			running++
+		}
+	}
+
+	mark := " "
+	if selected > 0 {
+		mark = "*"
+	}
+	line := fmt.Sprintf("%s%s%s %s (%d)", cursor, mark, fold, groupName(tag), len(jobs))
+	if running > 0 {
+		line += fmt.Sprintf(" %s %d running", spinnerFrames[m.spinnerFrame], running)
+	}
+	return line
+}
+
+// listHeight returns how many rows of the job list fit into the top
+// panel.
+func (m *model) listHeight() int {
+	// topHeight is the content area height set by lipgloss
+	topHeight := m.height/2 - 2
+	if topHeight < 8 {
+		topHeight = 8
+	}
+	// Account for: Title (1) + Empty line (1) + Help text (1) = 3
+	availableLines := topHeight - 3
+	if m.statusMessage != "" {
+		availableLines--
+	}
+	if availableLines < 1 {
+		availableLines = 1
+	}
+	return availableLines
+}
+
+// moveCursor moves the cursor by delta rows, stopping at the first and
+// the last row.
+func (m *model) moveCursor(delta int) {
+	m.cursor += delta
+	if m.cursor >= len(m.rows) {
+		m.cursor = len(m.rows) - 1
+	}
+	if m.cursor < 0 {
+		m.cursor = 0
+	}
+}
+
+// selectJob moves the cursor to the first row of the named job, if it
+// is listed.
+func (m *model) selectJob(name string) {
+	for i, row := range m.rows {
+		if !row.header && m.contexts[row.job].Name == name {
+			m.cursor = i
+			return
+		}
+	}
+}
+
 func (m *model) View() string {
+	if m.pager != nil {
+		return m.pagerView()
+	}
+	if m.jobForm != nil {
+		return m.jobFormView()
+	}
+
 	var topContent strings.Builder
 	var bottomContent strings.Builder
 
@@ -170,31 +974,30 @@ func (m *model) View() string {
 	}
 
 	topContent.WriteString(titleStyle.Render("Job Deck"))
+	if m.filtering || m.filter != "" {
+		topContent.WriteString(m.filterLine())
+	}
 	topContent.WriteString("\n\n")
 
 	if len(m.contexts) == 0 {
 		topContent.WriteString("No contexts available.")
+	} else if len(m.rows) == 0 {
+		topContent.WriteString("No jobs match the filter.\n")
 	} else {
-		// Calculate available space for context list
-		// topHeight is the content area height set by lipgloss
-		// Account for: Title (1) + Empty line (1) + Help text (1) = 3
-		availableLines := topHeight - 3
-		if availableLines < 1 {
-			availableLines = 1
-		}
+		availableLines := m.listHeight()
 		
-		// Show contexts around cursor position
+		// Show rows around cursor position
 		startIdx := 0
-		endIdx := len(m.contexts)
+		endIdx := len(m.rows)
 		
-		if len(m.contexts) > availableLines {
+		if len(m.rows) > availableLines {
 			startIdx = m.cursor - availableLines/2
 			if startIdx < 0 {
 				startIdx = 0
 			}
 			endIdx = startIdx + availableLines
-			if endIdx > len(m.contexts) {
-				endIdx = len(m.contexts)
+			if endIdx > len(m.rows) {
+				endIdx = len(m.rows)
 				startIdx = endIdx - availableLines
 				if startIdx < 0 {
 					startIdx = 0
@@ -202,12 +1005,40 @@ func (m *model) View() string {
 			}
 		}
 
-		for i := startIdx; i < endIdx; i++ {
-			context := m.contexts[i]
+		// Truncate long lines to fit within panel
+		// Width set in style - padding left/right (2*2=4)
+		maxLineWidth := m.width - 4 - 4 // total width - borders - padding
+		if maxLineWidth < 10 {
+			maxLineWidth = 10
+		}
+		indent := ""
+		if m.grouped() {
+			indent = "  "
+		}
+
+		for r := startIdx; r < endIdx; r++ {
+			row := m.rows[r]
 			cursor := " "
-			if m.cursor == i {
+			if m.cursor == r {
 				cursor = ">"
 			}
+			style := lipgloss.NewStyle()
+			if m.cursor == r {
+				style = selectedStyle
+			}
+
+			if row.header {
+				line := m.groupHeader(row.tag, cursor)
+				if len(line) > maxLineWidth {
+					line = line[:maxLineWidth-3] + "..."
+				}
+				topContent.WriteString(style.Render(line))
+				topContent.WriteString("\n")
+				continue
+			}
+
+			i := row.job
+			context := m.contexts[i]
 
 			statusIcon := " "
 			if context.LastResult != nil {
@@ -217,43 +1048,110 @@ func (m *model) View() string {
 					statusIcon = "✗"
 				}
 			}
-
-			style := lipgloss.NewStyle()
-			if m.cursor == i {
-				style = selectedStyle
+			batchState, inBatch := m.batchState(context.Name)
+			switch {
+			case inBatch && batchState == batchPending:
+				statusIcon = "·"
+			case inBatch && batchState == batchSkipped:
+				statusIcon = "-"
+			}
+			service, isService := m.service(context)
+			if isService {
+				statusIcon = serviceIcons[service.State]
+			}
+			health, isChecked := m.jobHealth(context)
+			if isChecked {
+				statusIcon = healthIcons[health.State]
+			}
+			run, isRunning := m.runningJob(context.Name)
+			if isRunning {
+				statusIcon = spinnerFrames[m.spinnerFrame]
+			}
+			mark := " "
+			if _, isSelected := m.selected[i]; isSelected {
+				mark = "*"
 			}
 
-			line := fmt.Sprintf("%s [%s] %s", 
-				cursor, statusIcon, context.Label)
+			line := fmt.Sprintf("%s%s%s[%s] ", cursor, mark, indent, statusIcon)
+			match, isMatch := m.matches[i]
+			var marks []int
+			if isMatch && match.field == matchLabel {
+				marks = offsetMarks(match.indexes, len(line))
+			}
+			line += context.Label
 			
+			if isRunning {
+				line += fmt.Sprintf(" (%s %s", run.action, time.Since(run.started).Truncate(time.Second))
+				if run.attempt != "" {
+					line += ", " + run.attempt
+				}
+				line += ")"
+			} else if inBatch && batchState == batchPending {
+				line += " (waiting)"
+			} else if inBatch && batchState == batchSkipped {
+				line += " (skipped)"
+			} else {
+				var status []string
+				if isService {
+					status = append(status, service.summary())
+				}
+				if isChecked {
+					status = append(status, health.summary())
+				}
+				if next, isScheduled := context.nextRun(time.Now()); isScheduled {
+					status = append(status, "next "+timeLabel(next))
+				}
+				if len(status) > 0 {
+					line += " (" + strings.Join(status, ", ") + ")"
+				}
+			}
 			if context.Description != "" {
-				line += fmt.Sprintf(" - %s", context.Description)
+				line += " - "
+				if isMatch && match.field == matchDescription {
+					marks = offsetMarks(match.indexes, len(line))
+				}
+				line += context.Description
 			}
-			
-			// Truncate long lines to fit within panel  
-			// Width set in style - padding left/right (2*2=4)
-			maxLineWidth := m.width - 4 - 4  // total width - borders - padding
-			if maxLineWidth < 10 {
-				maxLineWidth = 10
+			if isMatch && match.field != matchLabel && match.field != matchDescription {
+				line += fmt.Sprintf(" (%s: ", match.field)
+				text, indexes := match.snippet()
+				marks = offsetMarks(indexes, len(line))
+				line += text + ")"
 			}
+
 			if len(line) > maxLineWidth {
 				line = line[:maxLineWidth-3] + "..."
 			}
 
-			topContent.WriteString(style.Render(line))
+			topContent.WriteString(highlighted(line, marks, style, m.matchStyle(style)))
 			topContent.WriteString("\n")
 		}
 	}
 
-	topContent.WriteString("\n↑/↓ or j/k: navigate • space: execute • q: quit")
+	if m.statusMessage != "" {
+		topContent.WriteString("\n" + m.statusMessage)
+	}
+	if m.filtering {
+		topContent.WriteString("\n↑/↓: navigate • enter: apply filter • esc: clear filter")
+	} else if len(m.selected) > 0 {
+		topContent.WriteString(fmt.Sprintf("\n%d selected • tab: select • space: run selected • esc: clear • q: quit", len(m.selected)))
+	} else if m.filter != "" {
+		topContent.WriteString("\n↑/↓: navigate • space: execute • /: edit filter • esc: clear filter • q: quit")
+	} else if m.grouped() {
+		topContent.WriteString("\n↑/↓: navigate • space: execute • tab: select • ←/→: fold • /: filter • a: actions • q: quit")
+	} else {
+		topContent.WriteString("\n↑/↓ or j/k: navigate • space: execute • tab: select • /: filter • a: actions • x: cancel • q: quit")
+	}
 
 	bottomContent.WriteString(outputTitleStyle.Render("Job Details"))
+	bottomContent.WriteString("  enter: output • n: new • e: edit • c: duplicate • d: delete")
 	bottomContent.WriteString("\n")
 	bottomContent.WriteString("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━\n")
 	
 	var output string
-	if len(m.contexts) > 0 && m.cursor < len(m.contexts) {
-		selectedContext := m.contexts[m.cursor]
+	followTail := false
+	if i, ok := m.currentJob(); ok {
+		selectedContext := m.contexts[i]
 		
 		// Show job information
 		output = fmt.Sprintf("Name: %s\n", selectedContext.Name)
@@ -261,33 +1159,229 @@ func (m *model) View() string {
 		if selectedContext.Description != "" {
 			output += fmt.Sprintf("Description: %s\n", selectedContext.Description)
 		}
+		if len(selectedContext.Tags) > 0 {
+			output += fmt.Sprintf("Tags: %s\n", strings.Join(selectedContext.Tags, ", "))
+		}
+
+		if len(selectedContext.DependsOn) > 0 {
+			if order, err := m.executor.resolveDependencies(selectedContext.Name); err != nil {
+				output += fmt.Sprintf("Depends on: %s (%v)\n", strings.Join(selectedContext.DependsOn, ", "), err)
+			} else {
+				output += fmt.Sprintf("Dependency chain: %s\n", strings.Join(order, " → "))
+			}
+		}
+		if selectedContext.Retry != nil {
+			output += fmt.Sprintf("Retry: %s\n", selectedContext.Retry)
+		}
+		if len(selectedContext.Notify) > 0 {
+			notifications := make([]string, len(selectedContext.Notify))
+			for i, n := range selectedContext.Notify {
+				notifications[i] = n.String()
+			}
+			output += fmt.Sprintf("Notify: %s\n", strings.Join(notifications, ", "))
+		}
 		
-		if cmd, exists := selectedContext.Commands["run"]; exists {
-			output += fmt.Sprintf("Command: %s\n", cmd)
+		actions := selectedContext.actions()
+		if len(actions) == 1 && actions[0] == defaultAction {
+			output += fmt.Sprintf("Command: %s\n", selectedContext.Commands[defaultAction])
+		} else if len(actions) > 0 {
+			output += "\nActions:\n"
+			for _, action := range actions {
+				statusIcon := " "
+				if result := selectedContext.ActionResults[action]; result != nil {
+					statusIcon = map[bool]string{true: "✓", false: "✗"}[result.Success]
+				}
+				if _, isRunning := m.running[runKey(selectedContext.Name, action)]; isRunning {
+					statusIcon = spinnerFrames[m.spinnerFrame]
+				}
+				output += fmt.Sprintf("  [%s] %s: %s\n", statusIcon, action, selectedContext.Commands[action])
+			}
 		}
 		
 		if len(selectedContext.Variables) > 0 {
-			output += "\nVariables:\n"
+			if selectedContext.VariableMode == variableModeEnv {
+				output += "\nVariables (environment):\n"
+			} else {
+				output += "\nVariables:\n"
+			}
 			for k, v := range selectedContext.Variables {
 				output += fmt.Sprintf("  %s = %s\n", k, v)
 			}
 		}
 		
-		if selectedContext.LastResult != nil {
+		if len(selectedContext.Secrets) > 0 {
+			output += "\nSecrets:\n"
+			for _, name := range sortedSecretNames(selectedContext.Secrets) {
+				output += fmt.Sprintf("  %s = %s (%s)\n", name, secretMask, selectedContext.Secrets[name])
+			}
+		}
+
+		if len(selectedContext.Params) > 0 {
+			output += "\nParameters:\n"
+			for _, p := range selectedContext.Params {
+				line := "  " + p.Name
+				if p.Required {
+					line += " (required)"
+				}
+				if p.Default != "" {
+					line += " = " + p.Default
+				}
+				if p.Description != "" {
+					line += " - " + p.Description
+				}
+				output += line + "\n"
+			}
+		}
+
+		service, isService := m.service(selectedContext)
+		if isService {
+			output += fmt.Sprintf("\nService: %s", service.summary())
+			if service.PID > 0 {
+				output += fmt.Sprintf(", pid %d", service.PID)
+			}
+			if service.Restarts > 0 {
+				output += fmt.Sprintf(", %d restarts", service.Restarts)
+			}
+			output += fmt.Sprintf("\n  Restart: %s\n", firstNonEmpty(selectedContext.Service.Restart, restartNo))
+			output += fmt.Sprintf("  Log: %s\n", service.Log)
+			output += "  space: start • x: stop • enter: whole log\n"
+		}
+		if health, isChecked := m.jobHealth(selectedContext); isChecked {
+			output += "\nHealth: " + health.summary()
+			if health.Message != "" {
+				output += " (" + health.Message + ")"
+			}
+			output += fmt.Sprintf("\n  Check: %s, every %s", selectedContext.Check, selectedContext.Check.interval())
+			if !health.Checked.IsZero() {
+				output += fmt.Sprintf(", last %s ago", time.Since(health.Checked).Truncate(time.Second))
+			}
+			output += "\n"
+		}
+		if selectedContext.Schedule != "" {
+			output += fmt.Sprintf("\nSchedule: %s (overlap: %s, catch-up: %s)\n", selectedContext.Schedule, selectedContext.overlap(), selectedContext.catchUp())
+			if next, isScheduled := selectedContext.nextRun(time.Now()); !isScheduled {
+				output += "  Invalid schedule; run 'go-cmdeck validate'\n"
+			} else if m.scheduler {
+				output += "  Next run at " + timeLabel(next) + "\n"
+			} else {
+				output += "  Next run at " + timeLabel(next) + " (go-cmdeck scheduler is not running)\n"
+			}
+		}
+
+		if run, isRunning := m.runningJob(selectedContext.Name); isRunning {
+			followTail = true
+			output += fmt.Sprintf("\nRunning %s for %s", run.action, time.Since(run.started).Truncate(time.Second))
+			if run.attempt != "" {
+				output += " (" + run.attempt + ")"
+			}
+			output += "...\n"
+			output += "  Output:\n"
+			if len(run.lines) > 0 {
+				output += strings.Join(run.lines, "\n")
+			} else {
+				output += "(waiting for output)"
+			}
+		} else if isService {
+			// The end of the log fills the rest of the panel.
+			output += "\nLog:\n"
+			if tail := m.serviceLogTail(selectedContext.Name); tail != "" {
+				lines := strings.Split(strings.TrimSuffix(tail, "\n"), "\n")
+				room := max(bottomHeight-4-strings.Count(output, "\n"), 3)
+				if len(lines) > room {
+					lines = lines[len(lines)-room:]
+				}
+				output += strings.Join(lines, "\n")
+			} else {
+				output += "(no output yet)"
+			}
+		} else if selectedContext.LastResult != nil {
 			output += "\nLast Execution:\n"
+			if selectedContext.LastResult.Action != "" {
+				output += fmt.Sprintf("  Action: %s\n", selectedContext.LastResult.Action)
+			}
 			output += fmt.Sprintf("  Time: %s\n", selectedContext.LastResult.Timestamp.Format("2006-01-02 15:04:05"))
 			output += fmt.Sprintf("  Status: %s (Exit Code: %d)\n", 
-				map[bool]string{true: "SUCCESS", false: "FAILED"}[selectedContext.LastResult.Success],
+				strings.ToUpper(selectedContext.LastResult.status()),
 				selectedContext.LastResult.ExitCode)
+			if attempts := selectedContext.LastResult.Attempts; len(attempts) > 1 {
+				output += fmt.Sprintf("  Attempts: %s\n", attemptsLabel(attempts))
+			}
 			if selectedContext.LastResult.Output != "" {
 				output += fmt.Sprintf("  Output:\n%s\n", selectedContext.LastResult.Output)
 			}
 		} else {
 			output += "\nNever executed"
 		}
+	} else if m.cursor < len(m.rows) {
+		tag := m.rows[m.cursor].tag
+		jobs := m.groupJobs(tag)
+		if tag == "" {
+			output = fmt.Sprintf("Jobs without tags: %d\n\n", len(jobs))
+		} else {
+			output = fmt.Sprintf("Tag: %s\nJobs: %d\n\n", tag, len(jobs))
+		}
+		for _, i := range jobs {
+			context := m.contexts[i]
+			statusIcon := " "
+			if context.LastResult != nil {
+				statusIcon = map[bool]string{true: "✓", false: "✗"}[context.LastResult.Success]
+			}
+			if _, isRunning := m.runningJob(context.Name); isRunning {
+				statusIcon = spinnerFrames[m.spinnerFrame]
+			}
+			output += fmt.Sprintf("  [%s] %s (%s)\n", statusIcon, context.Label, context.Name)
+		}
+		output += "\nenter/space: fold or unfold • tab: select all jobs of the group"
 	} else {
 		output = "No job selected"
 	}
+
+	if m.picking {
+		followTail = false
+		pickedJob := m.executor.config.Contexts[m.actionJob]
+		output = fmt.Sprintf("Select action for %s:\n\n", pickedJob.Label)
+		for i, action := range m.actionChoices {
+			cursor := " "
+			if i == m.actionCursor {
+				cursor = ">"
+			}
+			output += fmt.Sprintf("%s %s: %s\n", cursor, action, pickedJob.Commands[action])
+		}
+		output += "\nenter: run • esc: cancel"
+	}
+	if m.form != nil {
+		followTail = false
+		output = fmt.Sprintf("Parameters for %s (%s):\n\n", m.form.label, m.form.action)
+		for i, p := range m.form.params {
+			cursor := " "
+			if i == m.form.cursor {
+				cursor = ">"
+			}
+			name := p.Name
+			if p.Required {
+				name += "*"
+			}
+			value := m.form.values[i]
+			switch {
+			case len(p.Allowed) > 0:
+				if value == "" {
+					value = "(choose)"
+				}
+				value = "◀ " + value + " ▶"
+			case i == m.form.cursor:
+				value += "_"
+			}
+			line := fmt.Sprintf("%s %s: %s", cursor, name, value)
+			if p.Description != "" {
+				line += "  " + p.Description
+			}
+			output += line + "\n"
+		}
+		if m.form.err != "" {
+			output += "\n" + m.form.err + "\n"
+		}
+		output += "\nenter: run • tab/↑/↓: field • ←/→: choose • esc: cancel"
+	}
 	contentWidth := m.width - 4 - 4  // total width - borders - padding
 	contentHeight := bottomHeight - 4  // title + separator + spacing + buffer
 	
@@ -295,26 +1389,28 @@ func (m *model) View() string {
 		contentHeight = 1
 	}
 	
-	// Split into lines and wrap long lines
+	// Split into lines and wrap long lines by display width
 	var processedLines []string
 	for _, line := range strings.Split(output, "\n") {
-		if len(line) <= contentWidth {
-			processedLines = append(processedLines, line)
-		} else {
-			// Wrap long lines
-			for i := 0; i < len(line); i += contentWidth {
-				end := i + contentWidth
-				if end > len(line) {
-					end = len(line)
-				}
-				processedLines = append(processedLines, line[i:end])
+		line = cleanOutputLine(line)
+		for _, part := range strings.Split(ansi.Hardwrap(line, max(contentWidth, 1), true), "\n") {
+			if strings.Contains(part, "\x1b") {
+				part += ansi.ResetStyle
 			}
+			processedLines = append(processedLines, part)
 		}
 	}
 	
-	// Limit to available height (show from beginning)
+	// Limit to available height (show from beginning, or the latest
+	// lines while the job is still running); the pager shows the rest
 	if len(processedLines) > contentHeight {
-		processedLines = processedLines[:contentHeight]
+		if followTail {
+			processedLines = processedLines[len(processedLines)-contentHeight:]
+		} else {
+			more := len(processedLines) - contentHeight + 1
+			processedLines = append(processedLines[:contentHeight-1],
+				fmt.Sprintf("... %d more lines (enter: view the whole output)", more))
+		}
 	}
 	
 	bottomContent.WriteString(strings.Join(processedLines, "\n"))
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"github.com/santhosh-tekuri/jsonschema/v6/kind"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// configSchemaURL is where the JSON Schema of the configuration is
+// published, for "$schema" in JSON files and the schema comments of
+// YAML and TOML editors.
+const configSchemaURL = "https://raw.githubusercontent.com/ToshihitoKon/agent-works/main/go-cmdeck/schema/cmdeck.schema.json"
+
+//go:embed schema/cmdeck.schema.json
+var configSchemaJSON []byte
+
+var configSchema = sync.OnceValues(func() (*jsonschema.Schema, error) {
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(configSchemaJSON))
+	if err != nil {
+		return nil, err
+	}
+	compiler := jsonschema.NewCompiler()
+	if err := compiler.AddResource(configSchemaURL, doc); err != nil {
+		return nil, err
+	}
+	return compiler.Compile(configSchemaURL)
+})
+
+// colorPattern matches the colors lipgloss understands: an ANSI color
+// number or a hex color.
+var colorPattern = regexp.MustCompile(`^(\d{1,3}|#[0-9A-Fa-f]{3}|#[0-9A-Fa-f]{6})$`)
+
+func validColor(color string) bool {
+	if !colorPattern.MatchString(color) {
+		return false
+	}
+	if n, err := strconv.Atoi(color); err == nil && n > 255 {
+		return false
+	}
+	return true
+}
+
+// validate returns the problems of the document: duplicate keys, schema
+// violations and settings that cannot work, ordered by line.
+func (d *configDocument) validate() []*configProblem {
+	var problems []*configProblem
+	for _, dup := range d.duplicates {
+		problems = append(problems, &configProblem{File: d.path, Line: dup.line,
+			Message: fmt.Sprintf("duplicate key %s (first defined on line %d)", displayPath(dup.pointer), dup.first)})
+	}
+	schemaProblems := d.schemaProblems()
+	for _, p := range schemaProblems {
+		problems = append(problems, p.configProblem)
+	}
+
+	// The remaining checks need the decoded values. Sections with wrong
+	// types are skipped, as are checks of values the schema already
+	// reported.
+	add := func(pointer string, format string, args ...any) {
+		for _, p := range schemaProblems {
+			if p.pointer == pointer || strings.HasPrefix(p.pointer, pointer+"/") {
+				return
+			}
+		}
+		problems = append(problems, d.problemf(pointer, format, args...))
+	}
+	if _, err := d.decode(); err != nil && len(problems) == 0 {
+		problems = append(problems, asConfigProblem(d.path, err))
+	}
+	root, _ := d.data.(map[string]any)
+
+	contexts, _ := root["contexts"].(map[string]any)
+	names := make([]string, 0, len(contexts))
+	for name := range contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var job Context
+		if decodeValue(contexts[name], &job) != nil {
+			continue
+		}
+		at := jsonPointer("contexts", name)
+		if job.Name != "" && job.Name != name {
+			add(at+"/name", "job '%s' has name '%s'; the name must match the key", name, job.Name)
+		}
+		job.Name = name
+		if _, exists := job.Commands[defaultAction]; !exists {
+			add(at+"/commands", "job '%s' has no %s command", name, defaultAction)
+		}
+		if job.Timeout != "" {
+			if _, err := parseDuration(job.Timeout); err != nil {
+				add(at+"/timeout", "job '%s': invalid timeout: %v", name, err)
+			}
+		}
+		if err := job.checkParams(); err != nil {
+			add(at+"/params", "%v", err)
+		}
+		if err := job.checkSecrets(); err != nil {
+			add(at+"/secrets", "%v", err)
+		}
+		if err := job.checkTags(); err != nil {
+			add(at+"/tags", "%v", err)
+		}
+		if err := job.checkService(); err != nil {
+			add(at+"/service", "%v", err)
+		}
+		if err := job.checkHealth(); err != nil {
+			add(at+"/check", "%v", err)
+		}
+		if err := job.checkSchedule(); err != nil {
+			add(at+"/schedule", "%v", err)
+		}
+		if err := job.checkRetry(); err != nil {
+			add(at+"/retry", "%v", err)
+		}
+		if err := job.checkNotify(); err != nil {
+			add(at+"/notify", "%v", err)
+		}
+	}
+
+	theme, _ := root["theme"].(map[string]any)
+	for _, key := range []string{"title", "selected", "border", "output_title"} {
+		if color, ok := theme[key].(string); ok && color != "" && !validColor(color) {
+			add(jsonPointer("theme", key), "theme.%s: invalid color '%s' (use 0-255, #RGB or #RRGGBB)", key, color)
+		}
+	}
+	if timeout, ok := root["default_timeout"].(string); ok && timeout != "" {
+		if _, err := parseDuration(timeout); err != nil {
+			add("/default_timeout", "default_timeout: %v", err)
+		}
+	}
+	var notifications []Notification
+	if decodeValue(root["notify"], &notifications) == nil {
+		if err := checkNotifications(notifications); err != nil {
+			add("/notify", "notify: %v", err)
+		}
+	}
+	var history HistoryConfig
+	if decodeValue(root["history"], &history) == nil && history.MaxAge != "" {
+		if _, err := parseDuration(history.MaxAge); err != nil {
+			add("/history/max_age", "history.max_age: %v", err)
+		}
+	}
+	return sortProblems(problems)
+}
+
+// schemaProblem is a schema violation at the value at pointer.
+type schemaProblem struct {
+	*configProblem
+	pointer string
+}
+
+// schemaProblems validates the document against the JSON Schema.
+func (d *configDocument) schemaProblems() []schemaProblem {
+	schema, err := configSchema()
+	if err != nil {
+		return []schemaProblem{{&configProblem{File: d.path, Message: "invalid schema: " + err.Error()}, ""}}
+	}
+	var validationErr *jsonschema.ValidationError
+	if !errors.As(schema.Validate(d.data), &validationErr) {
+		return nil
+	}
+
+	printer := message.NewPrinter(language.English)
+	var problems []schemaProblem
+	var walk func(*jsonschema.ValidationError)
+	walk = func(e *jsonschema.ValidationError) {
+		if len(e.Causes) > 0 {
+			for _, cause := range e.Causes {
+				walk(cause)
+			}
+			return
+		}
+
+		pointer := jsonPointer(e.InstanceLocation...)
+		if additional, ok := e.ErrorKind.(*kind.AdditionalProperties); ok {
+			for _, property := range additional.Properties {
+				msg := fmt.Sprintf("unknown field '%s'", property)
+				if pointer != "" {
+					msg += " in " + displayPath(pointer)
+				}
+				at := pointer + jsonPointer(property)
+				problems = append(problems, schemaProblem{d.problemf(at, "%s", msg), at})
+			}
+			return
+		}
+		problems = append(problems, schemaProblem{d.problemf(pointer, "%s: %s",
+			displayPath(pointer), e.ErrorKind.LocalizedString(printer)), pointer})
+	}
+	walk(validationErr)
+	return problems
+}
+
+// decodeValue decodes a value of the generic document into v.
+func decodeValue(value any, v any) error {
+	if value == nil {
+		return fmt.Errorf("no value")
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// displayPath shows a JSON pointer as a dotted path such as
+// contexts.build.timeout.
+func displayPath(pointer string) string {
+	if pointer == "" {
+		return "(top level)"
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return strings.Join(tokens, ".")
+}
+
+func sortProblems(problems []*configProblem) []*configProblem {
+	sort.SliceStable(problems, func(i, j int) bool { return problems[i].Line < problems[j].Line })
+	return problems
+}
+
+// validate returns the problems of the files the configuration was
+// merged from.
+func (c *Config) validate() []*configProblem {
+	var problems []*configProblem
+	for _, layer := range c.layers {
+		if layer.doc != nil {
+			problems = append(problems, layer.doc.validate()...)
+		}
+	}
+	return append(problems, c.dependencyProblems()...)
+}
+
+// dependencyProblems reports dependencies on unknown jobs and dependency
+// cycles of the merged configuration, located in the file that defines
+// the depending job.
+func (c *Config) dependencyProblems() []*configProblem {
+	var problems []*configProblem
+	at := func(name string, pointer string, format string, args ...any) *configProblem {
+		layer := c.source(name)
+		if layer.doc == nil {
+			return &configProblem{File: layer.Path, Message: fmt.Sprintf(format, args...)}
+		}
+		return layer.doc.problemf(pointer, format, args...)
+	}
+
+	names := make([]string, 0, len(c.Contexts))
+	for name := range c.Contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for i, dep := range c.Contexts[name].DependsOn {
+			if _, exists := c.Contexts[dep]; !exists {
+				problems = append(problems, at(name, jsonPointer("contexts", name, "depends_on", strconv.Itoa(i)),
+					"job '%s' depends on unknown job '%s'", name, dep))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+
+	// Only cycles are left; every job that reaches one reports it.
+	executor := &Executor{config: c}
+	reported := make(map[string]bool)
+	for _, name := range names {
+		if _, err := executor.resolveDependencies(name); err != nil && !reported[err.Error()] {
+			reported[err.Error()] = true
+			problems = append(problems, at(name, jsonPointer("contexts", name, "depends_on"), "%v", err))
+		}
+	}
+	return problems
+}
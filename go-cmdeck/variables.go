@@ -0,0 +1,326 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Variable sources reported by `go-cmdeck vars`.
+const (
+	sourceJob      = "job"
+	sourceParam    = "param"
+	sourceSecret   = "secret"
+	sourceOverride = "override"
+	sourceEnv      = "env"
+	sourceDefault  = "default"
+)
+
+var variableNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// varResolver expands ${NAME} and ${NAME:-default} placeholders. Names
+// are looked up in the job variables first and then in the process
+// environment. Variable values may reference other variables; cycles are
+// reported as errors. Other shell expansions such as ${NAME%.txt} are
+// left for the shell, and $${ produces a literal ${.
+type varResolver struct {
+	vars      map[string]string
+	lookupEnv func(string) (string, bool)
+
+	resolved map[string]string
+	sources  map[string]string
+	stack    []string
+	missing  map[string]bool
+}
+
+func newVarResolver(vars map[string]string) *varResolver {
+	return &varResolver{
+		vars:      vars,
+		lookupEnv: os.LookupEnv,
+		resolved:  make(map[string]string),
+		sources:   make(map[string]string),
+		missing:   make(map[string]bool),
+	}
+}
+
+// expand replaces the placeholders in s. Unknown variables without a
+// default are collected and reported by unresolvedError. If quote is
+// set, s is a shell command and every value is quoted for the position
+// it is inserted at, so that it stays a single literal word.
+func (r *varResolver) expand(s string, quote bool) (string, error) {
+	var out strings.Builder
+	var single, double bool
+	for i := 0; i < len(s); {
+		if strings.HasPrefix(s[i:], "$${") {
+			out.WriteString("${")
+			i += 3
+			continue
+		}
+		if !strings.HasPrefix(s[i:], "${") {
+			c := s[i]
+			switch {
+			case !quote:
+			case c == '\\' && !single && i+1 < len(s):
+				out.WriteString(s[i : i+2])
+				i += 2
+				continue
+			case c == '\'' && !double:
+				single = !single
+			case c == '"' && !single:
+				double = !double
+			}
+			out.WriteByte(c)
+			i++
+			continue
+		}
+
+		end := matchingBrace(s, i+2)
+		if end < 0 {
+			out.WriteString(s[i:])
+			break
+		}
+
+		value, ok, err := r.placeholder(s[i+2 : end])
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case !ok:
+			out.WriteString(s[i : end+1])
+		case quote:
+			out.WriteString(shellQuote(value, single, double))
+		default:
+			out.WriteString(value)
+		}
+		i = end + 1
+	}
+	return out.String(), nil
+}
+
+var shellSafePattern = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// shellQuote quotes a value for insertion into a shell command, inside
+// single quotes, inside double quotes or unquoted.
+func shellQuote(value string, single, double bool) string {
+	switch {
+	case single:
+		return strings.ReplaceAll(value, "'", `'\''`)
+	case double:
+		return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`").Replace(value)
+	case shellSafePattern.MatchString(value):
+		return value
+	}
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// placeholder resolves the body of a ${...} expression. ok is false if
+// the expression is not one of ours or cannot be resolved.
+func (r *varResolver) placeholder(body string) (string, bool, error) {
+	name, def, hasDefault := strings.Cut(body, ":-")
+	if !variableNamePattern.MatchString(name) {
+		return "", false, nil
+	}
+
+	value, found, err := r.lookup(name)
+	if err != nil {
+		return "", false, err
+	}
+	if found && (value != "" || !hasDefault) {
+		return value, true, nil
+	}
+	if !hasDefault {
+		r.missing[name] = true
+		return "", false, nil
+	}
+
+	value, err = r.expand(def, false)
+	if err != nil {
+		return "", false, err
+	}
+	if _, seen := r.sources[name]; !seen {
+		r.sources[name] = sourceDefault
+		r.resolved[name] = value
+	}
+	return value, true, nil
+}
+
+// lookup returns the fully expanded value of a variable.
+func (r *varResolver) lookup(name string) (string, bool, error) {
+	if value, ok := r.resolved[name]; ok && r.sources[name] != sourceDefault {
+		return value, true, nil
+	}
+
+	for i, entry := range r.stack {
+		if entry == name {
+			cycle := append(append([]string{}, r.stack[i:]...), name)
+			return "", false, fmt.Errorf("variable cycle detected: %s", strings.Join(cycle, " -> "))
+		}
+	}
+
+	if raw, ok := r.vars[name]; ok {
+		r.stack = append(r.stack, name)
+		value, err := r.expand(raw, false)
+		r.stack = r.stack[:len(r.stack)-1]
+		if err != nil {
+			return "", false, err
+		}
+		r.resolved[name] = value
+		r.sources[name] = sourceJob
+		return value, true, nil
+	}
+
+	if value, ok := r.lookupEnv(name); ok {
+		r.resolved[name] = value
+		r.sources[name] = sourceEnv
+		return value, true, nil
+	}
+
+	return "", false, nil
+}
+
+// unresolvedError lists every placeholder that could not be resolved.
+func (r *varResolver) unresolvedError() error {
+	if len(r.missing) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(r.missing))
+	for name := range r.missing {
+		names = append(names, "${"+name+"}")
+	}
+	sort.Strings(names)
+	return fmt.Errorf("unresolved variables: %s", strings.Join(names, ", "))
+}
+
+// matchingBrace returns the index of the "}" closing a "${" whose body
+// starts at start, honouring nested "${...}".
+func matchingBrace(s string, start int) int {
+	depth := 1
+	for i := start; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], "${"):
+			depth++
+			i++
+		case s[i] == '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// expandCommand resolves all placeholders of a command, quoting the
+// values, and fails if any of them cannot be resolved.
+func expandCommand(command string, variables map[string]string) (string, error) {
+	r := newVarResolver(variables)
+	expanded, err := r.expand(command, true)
+	if err != nil {
+		return "", err
+	}
+	if err := r.unresolvedError(); err != nil {
+		return "", err
+	}
+	return expanded, nil
+}
+
+// expandText resolves all placeholders of a plain value such as an
+// address, without quoting, and fails if any of them cannot be resolved.
+func expandText(text string, variables map[string]string) (string, error) {
+	r := newVarResolver(variables)
+	expanded, err := r.expand(text, false)
+	if err != nil {
+		return "", err
+	}
+	if err := r.unresolvedError(); err != nil {
+		return "", err
+	}
+	return expanded, nil
+}
+
+// commandEnv resolves the variables of a job in env mode. Every variable
+// is returned as KEY=VALUE with its references expanded; the command is
+// only checked for unresolved placeholders and left to the shell.
+func commandEnv(command string, variables map[string]string) ([]string, error) {
+	r := newVarResolver(variables)
+	env := make([]string, 0, len(variables))
+	for _, name := range sortedKeys(variables) {
+		value, _, err := r.lookup(name)
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, name+"="+value)
+	}
+	if _, err := r.expand(command, false); err != nil {
+		return nil, err
+	}
+	if err := r.unresolvedError(); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// resolvedVariable is a variable as printed by `go-cmdeck vars`.
+type resolvedVariable struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// resolveJobVariables resolves every variable of the job and every
+// placeholder used by its commands, with parameters and values applied
+// as for a run. Secrets are shown masked without being read. Missing
+// parameters are reported like unresolved placeholders.
+func resolveJobVariables(job Context, values map[string]string) ([]resolvedVariable, error) {
+	overrides, paramErr := job.resolveParams(values)
+	if paramErr != nil {
+		overrides = values
+	}
+	job = job.withValues(overrides)
+	secrets := job.maskedSecrets()
+	r := newVarResolver(job.withValues(secrets).Variables)
+
+	for _, name := range sortedKeys(job.Variables) {
+		if _, _, err := r.lookup(name); err != nil {
+			return nil, err
+		}
+	}
+	for _, action := range job.actions() {
+		if _, err := r.expand(job.Commands[action], false); err != nil {
+			return nil, err
+		}
+	}
+
+	names := make([]string, 0, len(r.sources))
+	for name := range r.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fromParam := make(map[string]bool)
+	for _, p := range job.Params {
+		if _, given := values[p.Name]; !given && p.Default != "" {
+			fromParam[p.Name] = true
+		}
+	}
+
+	vars := make([]resolvedVariable, 0, len(names))
+	for _, name := range names {
+		source := r.sources[name]
+		if _, given := values[name]; given && source == sourceJob {
+			source = sourceOverride
+		} else if fromParam[name] && source == sourceJob {
+			source = sourceParam
+		} else if _, isSecret := secrets[name]; isSecret && source == sourceJob {
+			source = sourceSecret
+		}
+		vars = append(vars, resolvedVariable{Name: name, Value: r.resolved[name], Source: source})
+	}
+	if paramErr != nil {
+		return vars, paramErr
+	}
+	return vars, r.unresolvedError()
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// newTestResolver returns a resolver over vars with env as the process
+// environment.
+func newTestResolver(vars, env map[string]string) *varResolver {
+	r := newVarResolver(vars)
+	r.lookupEnv = func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+	return r
+}
+
+func TestVarResolverExpand(t *testing.T) {
+	tests := []struct {
+		name       string
+		vars       map[string]string
+		env        map[string]string
+		in         string
+		want       string
+		wantErr    string
+		unresolved string
+	}{
+		{name: "plain", vars: map[string]string{"A": "a"}, in: "x ${A} y", want: "x a y"},
+		{name: "default for missing", in: "${A:-def}", want: "def"},
+		{name: "default for empty", vars: map[string]string{"A": ""}, in: "${A:-def}", want: "def"},
+		{name: "set value wins over default", vars: map[string]string{"A": "a"}, in: "${A:-def}", want: "a"},
+		{name: "empty value without default", vars: map[string]string{"A": ""}, in: "[${A}]", want: "[]"},
+		{name: "nested defaults", vars: map[string]string{"C": "c"}, in: "${A:-${B:-${C}}}", want: "c"},
+		{name: "nested default falls through", in: "${A:-${B:-last}}", want: "last"},
+		{name: "variable referencing variables", vars: map[string]string{"URL": "${HOST}:${PORT:-80}", "HOST": "example.com"}, in: "${URL}", want: "example.com:80"},
+		{name: "environment", env: map[string]string{"HOME": "/home/me"}, in: "${HOME}/bin", want: "/home/me/bin"},
+		{name: "job variable shadows environment", vars: map[string]string{"HOME": "/srv"}, env: map[string]string{"HOME": "/home/me"}, in: "${HOME}", want: "/srv"},
+		{name: "escaped placeholder", vars: map[string]string{"A": "a"}, in: "$${A} ${A}", want: "${A} a"},
+		{name: "shell expansions are left alone", vars: map[string]string{"F": "x.txt"}, in: "${F%.txt} $F ${#F}", want: "${F%.txt} $F ${#F}"},
+		{name: "unterminated placeholder", vars: map[string]string{"A": "a"}, in: "${A} ${A", want: "a ${A"},
+		{name: "cycle", vars: map[string]string{"A": "${B}", "B": "${A}"}, in: "${A}", wantErr: "variable cycle detected: A -> B -> A"},
+		{name: "self reference", vars: map[string]string{"A": "x${A}"}, in: "${A}", wantErr: "variable cycle detected: A -> A"},
+		{name: "cycle through a default", vars: map[string]string{"A": "${B:-${A}}"}, in: "${A}", wantErr: "variable cycle detected"},
+		{name: "unresolved", vars: map[string]string{"A": "${MISSING}"}, in: "${A} ${OTHER}", want: "${MISSING} ${OTHER}", unresolved: "unresolved variables: ${MISSING}, ${OTHER}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestResolver(tt.vars, tt.env)
+			got, err := r.expand(tt.in, false)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expand(%q) error = %v, want %q", tt.in, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expand(%q): %v", tt.in, err)
+			}
+			if got != tt.want {
+				t.Errorf("expand(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+			err = r.unresolvedError()
+			if (err == nil) != (tt.unresolved == "") || err != nil && err.Error() != tt.unresolved {
+				t.Errorf("unresolved = %v, want %q", err, tt.unresolved)
+			}
+		})
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		value          string
+		single, double bool
+		want           string
+	}{
+		{value: "plain", want: "plain"},
+		{value: "a/b.txt:8080", want: "a/b.txt:8080"},
+		{value: "", want: "''"},
+		{value: "two words", want: "'two words'"},
+		{value: "it's", want: `'it'\''s'`},
+		{value: "a; rm -rf /", want: "'a; rm -rf /'"},
+		{value: "$(id)", want: "'$(id)'"},
+		{value: "it's", single: true, want: `it'\''s`},
+		{value: "$(id); x", single: true, want: "$(id); x"},
+		{value: `say "hi" \ $(id) ` + "`id`", double: true, want: `say \"hi\" \\ \$(id) ` + "\\`id\\`"},
+		{value: "it's; x", double: true, want: "it's; x"},
+	}
+	for _, tt := range tests {
+		if got := shellQuote(tt.value, tt.single, tt.double); got != tt.want {
+			t.Errorf("shellQuote(%q, %v, %v) = %s, want %s", tt.value, tt.single, tt.double, got, tt.want)
+		}
+	}
+}
+
+// TestExpandCommandKeepsValuesLiteral runs spliced commands through sh
+// and checks that every value arrives as the single literal word it was.
+func TestExpandCommandKeepsValuesLiteral(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh")
+	}
+	values := []string{
+		"plain",
+		"",
+		"two  words",
+		"it's",
+		"a; echo injected",
+		"$(echo injected)",
+		"`echo injected`",
+		`back\slash "quoted" $HOME`,
+		"*",
+		"'; echo injected; '",
+		`"; echo injected; "`,
+	}
+	commands := []string{
+		`printf '%s|' ${V}`,
+		`printf '%s|' '${V}'`,
+		`printf '%s|' "${V}"`,
+		`printf '%s|' "pre ${V} post"`,
+		`printf '%s|' 'pre ${V} post'`,
+		`printf '%s|' pre${V}post`,
+	}
+	for _, command := range commands {
+		for _, value := range values {
+			expanded, err := expandCommand(command, map[string]string{"V": value})
+			if err != nil {
+				t.Fatalf("expandCommand(%q) with %q: %v", command, value, err)
+			}
+			output, err := exec.Command("sh", "-c", expanded).Output()
+			if err != nil {
+				t.Fatalf("%s: %v", expanded, err)
+			}
+			want := strings.NewReplacer("${V}", value, "'", "", `"`, "").Replace(strings.TrimPrefix(command, `printf '%s|' `)) + "|"
+			if string(output) != want {
+				t.Errorf("%s with V=%q printed %q, want %q", command, value, output, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// resolveDependencies returns the jobs that have to run for name, in
+// topological order and ending with name itself. Unknown jobs and
+// dependency cycles are reported as errors.
+func (e *Executor) resolveDependencies(name string) ([]string, error) {
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[string]int)
+	var order []string
+	var path []string
+
+	var visit func(string) error
+	visit = func(current string) error {
+		switch state[current] {
+		case done:
+			return nil
+		case visiting:
+			start := 0
+			for i, p := range path {
+				if p == current {
+					start = i
+					break
+				}
+			}
+			cycle := append(append([]string{}, path[start:]...), current)
+			return fmt.Errorf("dependency cycle detected: %s", strings.Join(cycle, " -> "))
+		}
+
+		job, exists := e.config.Contexts[current]
+		if !exists {
+			if len(path) == 0 {
+				return fmt.Errorf("job '%s' not found", current)
+			}
+			return fmt.Errorf("job '%s' depends on unknown job '%s'", path[len(path)-1], current)
+		}
+
+		state[current] = visiting
+		path = append(path, current)
+		for _, dep := range job.DependsOn {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[current] = done
+		order = append(order, current)
+		return nil
+	}
+
+	if err := visit(name); err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// workflowPlan is a resolved run of a job action: the jobs in steps
+// run in order with their default action before the job itself.
+type workflowPlan struct {
+	job    Context
+	action string
+	steps  []Context
+}
+
+// planWorkflow resolves what running an action of a job involves.
+// Dependencies only apply to the default action; other actions such as
+// stop or status run on their own. values set variables and parameters
+// of the job itself, not of its dependencies.
+func (e *Executor) planWorkflow(name, action string, values map[string]string) (*workflowPlan, error) {
+	job, exists := e.config.Contexts[name]
+	if !exists {
+		return nil, fmt.Errorf("job '%s' not found", name)
+	}
+	if _, exists := job.Commands[action]; !exists {
+		return nil, fmt.Errorf("job '%s' has no %s command", name, action)
+	}
+
+	job, err := e.prepareJob(job, action, values)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &workflowPlan{job: job, action: action}
+	if action != defaultAction {
+		return plan, nil
+	}
+
+	order, err := e.resolveDependencies(name)
+	if err != nil {
+		return nil, err
+	}
+	for _, dep := range order[:len(order)-1] {
+		depJob := e.config.Contexts[dep]
+		if _, exists := depJob.Commands[defaultAction]; !exists {
+			return nil, fmt.Errorf("dependency '%s' of job '%s' has no %s command", dep, name, defaultAction)
+		}
+		depJob, err = e.prepareJob(depJob, defaultAction, nil)
+		if err != nil {
+			return nil, err
+		}
+		plan.steps = append(plan.steps, depJob)
+	}
+	return plan, nil
+}
+
+// chain returns the job names of the plan in execution order.
+func (p *workflowPlan) chain() []string {
+	names := make([]string, 0, len(p.steps)+1)
+	for _, step := range p.steps {
+		names = append(names, step.Name)
+	}
+	return append(names, p.job.Name)
+}
+
+// executeWorkflow runs a planned workflow, stopping at the first step
+// that does not succeed. A plan without dependencies is executed
+// directly; otherwise the returned result describes the whole workflow
+// and carries the individual step results in Steps.
+func (e *Executor) executeWorkflow(ctx context.Context, plan *workflowPlan, onLine func(stream, line string)) (*ExecutionResult, error) {
+	if len(plan.steps) == 0 {
+		return e.executeJob(ctx, plan.job, plan.action, onLine)
+	}
+
+	start := time.Now()
+	workflow := &ExecutionResult{
+		RunID:     newRunID(start),
+		Job:       plan.job.Name,
+		Action:    plan.action,
+		Timestamp: start,
+		Success:   true,
+		Status:    StatusSuccess,
+	}
+
+	jobs := append(append([]Context{}, plan.steps...), plan.job)
+	var output strings.Builder
+	for i, job := range jobs {
+		header := fmt.Sprintf("=== [%d/%d] %s (%s) ===", i+1, len(jobs), job.Name, defaultAction)
+		if onLine != nil {
+			// Steps without retries clear the attempt of the last step.
+			onLine(streamAttempt, "")
+			onLine("stdout", header)
+		}
+		output.WriteString(header + "\n")
+
+		result, err := e.executeJob(ctx, job, defaultAction, onLine)
+		if err != nil {
+			return nil, err
+		}
+		workflow.Steps = append(workflow.Steps, result)
+		workflow.ExitCode = result.ExitCode
+		output.WriteString(result.Output)
+		output.WriteString("\n\n")
+		workflow.Stdout += result.Stdout
+		workflow.Stderr += result.Stderr
+
+		if !result.Success {
+			workflow.Success = false
+			workflow.Status = result.status()
+			if skipped := plan.chain()[i+1:]; len(skipped) > 0 {
+				output.WriteString(fmt.Sprintf("Stopped: %s %s, skipped %s\n",
+					job.Name, result.status(), strings.Join(skipped, ", ")))
+			}
+			break
+		}
+	}
+
+	workflow.EndTime = time.Now()
+	workflow.Duration = workflow.EndTime.Sub(start)
+	workflow.Output = strings.TrimRight(output.String(), "\n")
+	return workflow, nil
+}
+
+// planBatch returns the given jobs together with their dependencies in
+// one topological order, each job appearing once. values apply to the
+// given jobs only.
+func (e *Executor) planBatch(names []string, values map[string]string) ([]Context, error) {
+	selected := make(map[string]bool)
+	for _, name := range names {
+		selected[name] = true
+	}
+	seen := make(map[string]bool)
+	var jobs []Context
+	for _, name := range names {
+		order, err := e.resolveDependencies(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, dep := range order {
+			if seen[dep] {
+				continue
+			}
+			seen[dep] = true
+
+			job := e.config.Contexts[dep]
+			if _, exists := job.Commands[defaultAction]; !exists {
+				return nil, fmt.Errorf("job '%s' has no %s command", dep, defaultAction)
+			}
+			var jobValues map[string]string
+			if selected[dep] {
+				jobValues = values
+			}
+			job, err = e.prepareJob(job, defaultAction, jobValues)
+			if err != nil {
+				return nil, err
+			}
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+// prepareJob applies parameters and values to a job and checks that the
+// command of the action can be resolved. Secrets are not read yet.
+func (e *Executor) prepareJob(job Context, action string, values map[string]string) (Context, error) {
+	if err := job.checkParams(); err != nil {
+		return job, err
+	}
+	if err := job.checkSecrets(); err != nil {
+		return job, err
+	}
+	overrides, err := job.resolveParams(values)
+	if err != nil {
+		return job, fmt.Errorf("job '%s': %w", job.Name, err)
+	}
+	job = job.withValues(overrides)
+	if _, _, err := e.prepareCommand(job.withValues(job.maskedSecrets()), action); err != nil {
+		return job, fmt.Errorf("job '%s': %w", job.Name, err)
+	}
+	return job, nil
+}